@@ -2,19 +2,35 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/service"
 	"user_service/internal/util"
+	"user_service/pkg/policy"
 )
 
 type AuthMiddleware struct {
-	JwtService util.Jwt
+	JwtService           util.Jwt
+	Revocations          repository.RevocationStore
+	PersonalAccessTokens service.PersonalAccessTokenService
+	RBAC                 service.RBACService
+	Policies             *policy.Engine
+	Impersonations       service.ImpersonationService
+	LoginHistory         service.LoginHistoryService
 }
 
-func NewAuthMiddleware(jwtService util.Jwt) *AuthMiddleware {
-	return &AuthMiddleware{JwtService: jwtService}
+func NewAuthMiddleware(jwtService util.Jwt, revocations repository.RevocationStore,
+	personalAccessTokens service.PersonalAccessTokenService, rbac service.RBACService, policies *policy.Engine,
+	impersonations service.ImpersonationService, loginHistory service.LoginHistoryService) *AuthMiddleware {
+	return &AuthMiddleware{JwtService: jwtService, Revocations: revocations, PersonalAccessTokens: personalAccessTokens, RBAC: rbac,
+		Policies: policies, Impersonations: impersonations, LoginHistory: loginHistory}
 }
 
 func (auth *AuthMiddleware) AuthMiddleware() func(next http.Handler) http.Handler {
@@ -44,6 +60,11 @@ func (auth *AuthMiddleware) AuthMiddleware() func(next http.Handler) http.Handle
 			}
 			token = parts[1]
 
+			if strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+				auth.authenticatePersonalAccessToken(w, r, next, token)
+				return
+			}
+
 			claims, err := auth.JwtService.ValidateAccessToken(token)
 			if err != nil {
 				util.ResponseErr(w, util.ResponseError{
@@ -55,22 +76,150 @@ func (auth *AuthMiddleware) AuthMiddleware() func(next http.Handler) http.Handle
 				return
 			}
 
+			revoked, err := auth.isRevoked(r.Context(), claims)
+			if err != nil {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "INTERNAL_SERVER_ERROR",
+					TimeStamp: time.Now().String(),
+					Message:   "failed to check token",
+					Errors:    nil,
+				}, http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "UNAUTHORIZED",
+					TimeStamp: time.Now().String(),
+					Message:   "token has been revoked",
+					Errors:    nil,
+				}, http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), "user", claims)
+			if actor(claims) != "" {
+				auth.recordImpersonation(w, r.WithContext(ctx), next, claims)
+				return
+			}
+			auth.touchActivity(ctx, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// actor returns the user acting on behalf of the subject of an impersonation token (RFC 8693 act claim)
+func actor(claims jwt.MapClaims) string {
+	act, _ := claims["act"].(map[string]any)
+	sub, _ := act["sub"].(string)
+	return sub
+}
+
+// statusRecorder remembers the status code a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// recordImpersonation adds the request to the audit trail of the impersonation session, including requests
+// refused because they are not allowed while impersonating
+func (auth *AuthMiddleware) recordImpersonation(w http.ResponseWriter, r *http.Request, next http.Handler, claims jwt.MapClaims) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+
+	sessionID, _ := claims["jti"].(string)
+	auth.Impersonations.RecordAction(r.Context(), sessionID, r.Method, r.URL.Path, recorder.status)
+}
+
+// authenticatePersonalAccessToken puts the claims of the token's user on the context like for a JWT. Revoking
+// the token is the only way to invalidate it, so the JWT revocation checks do not apply.
+func (auth *AuthMiddleware) authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := auth.PersonalAccessTokens.Authenticate(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPersonalAccessToken) || errors.Is(err, service.ErrAccountInactive) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "UNAUTHORIZED",
+				TimeStamp: time.Now().String(),
+				Message:   "invalid token",
+				Errors:    nil,
+			}, http.StatusUnauthorized)
+			return
+		}
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "INTERNAL_SERVER_ERROR",
+			TimeStamp: time.Now().String(),
+			Message:   "failed to check token",
+			Errors:    nil,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "user", claims)
+	auth.touchActivity(ctx, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// touchActivity updates the last activity of the user making the request. Service accounts have no user
+// and an admin impersonating a user is not activity of the user.
+func (auth *AuthMiddleware) touchActivity(ctx context.Context, claims jwt.MapClaims) {
+	if isService(claims) {
+		return
+	}
+	if userID, _ := claims["userID"].(string); userID != "" {
+		auth.LoginHistory.TouchActivity(ctx, userID)
+	}
+}
+
+// isRevoked reports whether the token was revoked, see service.AccessTokenRevoked
+func (auth *AuthMiddleware) isRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	return service.AccessTokenRevoked(ctx, auth.Revocations, claims)
+}
+
+// roleScopes are the scopes standing in for a role when a service account calls a route restricted to it
+var roleScopes = map[string][]string{
+	models.RoleAdmin: {models.ScopeAdmin},
+	models.RoleUser:  {models.ScopeUsersRead, models.ScopeUsersWrite},
+}
+
+// isService reports whether the token was issued to a service account rather than a user
+func isService(claims jwt.MapClaims) bool {
+	return claims["sub_type"] == models.SubTypeService
+}
+
+// serviceRoles are the roles a service account stands in for through the scopes of its token
+func serviceRoles(claims jwt.MapClaims) []string {
+	scope, _ := claims["scope"].(string)
+	var roles []string
+	for role, scopes := range roleScopes {
+		if slices.ContainsFunc(scopes, func(s string) bool { return models.HasScope(scope, s) }) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// ACLMiddleware lets users with one of the roles through, as their primary role in the token or as an
+// additional role. Service accounts have no role, they need a scope standing in for one of the roles instead.
 func (auth *AuthMiddleware) ACLMiddleware(allowRoles ...string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			claims := r.Context().Value("user").(jwt.MapClaims)
-			role := claims["role"].(string)
-			for _, allowRole := range allowRoles {
-				if role == allowRole {
-					next.ServeHTTP(w, r)
-					return
-				}
+			allowed, err := auth.hasRole(r, allowRoles...)
+			if err != nil {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "INTERNAL_SERVER_ERROR",
+					TimeStamp: time.Now().String(),
+					Message:   "failed to check permissions",
+					Errors:    nil,
+				}, http.StatusInternalServerError)
+				return
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
 			}
 			util.ResponseErr(w, util.ResponseError{
 				Status:    "FORBIDDEN",
@@ -82,14 +231,139 @@ func (auth *AuthMiddleware) ACLMiddleware(allowRoles ...string) func(next http.H
 	}
 }
 
-func (auth *AuthMiddleware) OwnerMiddleware() func(next http.Handler) http.Handler {
+// hasRole reports whether the caller holds one of the roles, as the primary role in the token or as an
+// additional role, or for a service account through the scopes standing in for a role
+func (auth *AuthMiddleware) hasRole(r *http.Request, allowRoles ...string) (bool, error) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+	allowed := func(role string) bool { return slices.Contains(allowRoles, role) }
+	if isService(claims) {
+		return slices.ContainsFunc(serviceRoles(claims), allowed), nil
+	}
+
+	role, _ := claims["role"].(string)
+	if allowed(role) {
+		return true, nil
+	}
+
+	// additional roles are only looked up when the primary role is not enough
+	userID, _ := claims["userID"].(string)
+	roles, err := auth.RBAC.UserRoles(r.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(roles, allowed), nil
+}
+
+// RequirePermission lets users through whose roles grant the permission. Service accounts are granted
+// the permissions of the roles their scopes stand in for. Permissions are never exercised while impersonating.
+func (auth *AuthMiddleware) RequirePermission(permission string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := r.Context().Value("user").(jwt.MapClaims)
+			if actor(claims) != "" {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "FORBIDDEN",
+					TimeStamp: time.Now().String(),
+					Message:   "not allowed while impersonating",
+					Errors:    nil,
+				}, http.StatusForbidden)
+				return
+			}
+
+			allowed, err := auth.HasPermission(r, permission)
+			if err != nil {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "INTERNAL_SERVER_ERROR",
+					TimeStamp: time.Now().String(),
+					Message:   "failed to check permissions",
+					Errors:    nil,
+				}, http.StatusInternalServerError)
+				return
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   "missing permission " + permission,
+				Errors:    nil,
+			}, http.StatusForbidden)
+		})
+	}
+}
+
+// HasPermission reports whether the caller of an authenticated request holds the permission, for handlers
+// where only part of the request needs it. Impersonation tokens hold none.
+func (auth *AuthMiddleware) HasPermission(r *http.Request, permission string) (bool, error) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+	if actor(claims) != "" {
+		return false, nil
+	}
+
+	if isService(claims) {
+		return auth.RBAC.RolesHavePermission(r.Context(), serviceRoles(claims), permission)
+	}
+	userID, _ := claims["userID"].(string)
+	return auth.RBAC.HasPermission(r.Context(), userID, permission)
+}
+
+// RequireScope restricts tokens carrying a scope claim, personal access tokens and tokens issued to OAuth
+// clients, to routes accepting one of their scopes. Tokens from an interactive login have no scope and
+// pass. Without arguments the route is only reachable from an interactive login, which an impersonation
+// token is not.
+func (auth *AuthMiddleware) RequireScope(scopes ...string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			claims := r.Context().Value("user").(jwt.MapClaims)
-			role := claims["role"].(string)
-			user := claims["userID"].(string)
-			if role == "admin" ||
-				(role == "user" && (r.URL.Query().Get("id") == "" || user == r.URL.Query().Get("id"))) {
+			if len(scopes) == 0 && actor(claims) != "" {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "FORBIDDEN",
+					TimeStamp: time.Now().String(),
+					Message:   "not allowed while impersonating",
+					Errors:    nil,
+				}, http.StatusForbidden)
+				return
+			}
+			granted, scoped := claims["scope"].(string)
+			if !scoped || slices.ContainsFunc(scopes, func(scope string) bool { return models.HasScope(granted, scope) }) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   "token scope does not allow this request",
+				Errors:    nil,
+			}, http.StatusForbidden)
+		})
+	}
+}
+
+// SelfOrAdminMiddleware only lets a user through to routes about their own account, identified by the
+// {id} path variable. Admins may access every account, whether admin is their primary or an additional role.
+func (auth *AuthMiddleware) SelfOrAdminMiddleware() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := r.Context().Value("user").(jwt.MapClaims)
+			user, _ := claims["userID"].(string)
+			if user != "" && user == mux.Vars(r)["id"] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			admin, err := auth.hasRole(r, models.RoleAdmin)
+			if err != nil {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "INTERNAL_SERVER_ERROR",
+					TimeStamp: time.Now().String(),
+					Message:   "failed to check permissions",
+					Errors:    nil,
+				}, http.StatusInternalServerError)
+				return
+			}
+			if admin {
 				next.ServeHTTP(w, r)
 				return
 			}
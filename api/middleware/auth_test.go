@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"user_service/internal/models"
+	"user_service/internal/service"
+)
+
+// fakeRBAC knows the additional roles of users and what the roles grant
+type fakeRBAC struct {
+	service.RBACService
+	roles       map[string][]string
+	permissions map[string][]string
+	err         error
+}
+
+func (f fakeRBAC) UserRoles(_ context.Context, userID string) ([]string, error) {
+	return f.roles[userID], f.err
+}
+
+func (f fakeRBAC) RolePermissions(_ context.Context, roles []string) ([]string, error) {
+	var granted []string
+	for _, role := range roles {
+		granted = append(granted, f.permissions[role]...)
+	}
+	return granted, f.err
+}
+
+func TestSelfOrAdminMiddleware(t *testing.T) {
+	rbac := fakeRBAC{roles: map[string][]string{"assigned-admin": {models.RoleUser, models.RoleAdmin}}}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		rbac   fakeRBAC
+		want   int
+	}{
+		{"own account", jwt.MapClaims{"userID": "user-1", "role": models.RoleUser}, rbac, http.StatusOK},
+		{"another account", jwt.MapClaims{"userID": "user-2", "role": models.RoleUser}, rbac, http.StatusForbidden},
+		{"admin by primary role", jwt.MapClaims{"userID": "admin-1", "role": models.RoleAdmin}, rbac, http.StatusOK},
+		{"admin by assigned role", jwt.MapClaims{"userID": "assigned-admin", "role": models.RoleUser}, rbac, http.StatusOK},
+		{"role named like admin", jwt.MapClaims{"userID": "user-2", "role": "Admin"}, rbac, http.StatusForbidden},
+		{"service with admin scope", jwt.MapClaims{"sub": "svc", "sub_type": models.SubTypeService, "scope": models.ScopeAdmin},
+			rbac, http.StatusOK},
+		{"service without admin scope", jwt.MapClaims{"sub": "svc", "sub_type": models.SubTypeService, "scope": models.ScopeUsersRead},
+			rbac, http.StatusForbidden},
+		{"roles cannot be read", jwt.MapClaims{"userID": "user-2", "role": models.RoleUser},
+			fakeRBAC{err: errors.New("database down")}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &AuthMiddleware{RBAC: tt.rbac}
+			router := mux.NewRouter()
+			router.Handle("/users/{id}/sessions", auth.SelfOrAdminMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			r := httptest.NewRequest(http.MethodGet, "/users/user-1/sessions", nil)
+			r = r.WithContext(context.WithValue(r.Context(), "user", tt.claims))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubjectPermissions(t *testing.T) {
+	auth := &AuthMiddleware{RBAC: fakeRBAC{
+		roles:       map[string][]string{"support-1": {models.RoleUser, "support"}, "user-1": {models.RoleUser}},
+		permissions: map[string][]string{"support": {models.PermUsersDelete}},
+	}}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   []string
+	}{
+		{"role without permissions", jwt.MapClaims{"userID": "user-1", "role": models.RoleUser}, nil},
+		{"assigned role", jwt.MapClaims{"userID": "support-1", "role": models.RoleUser}, []string{models.PermUsersDelete}},
+		{"impersonation", jwt.MapClaims{"userID": "support-1", "role": models.RoleUser, "act": map[string]any{"sub": "admin-1"}},
+			[]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := auth.subject(context.Background(), tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := subject["permissions"].([]string)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("permissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
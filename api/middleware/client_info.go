@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"user_service/internal/util"
+)
+
+// ClientInfoMiddleware attaches the client IP, user agent and device label to the request context.
+// Clients may name their device with the X-Device-Label header, otherwise it is derived from the user agent.
+// Apps keeping an id for their installation send it in X-Device-Id, it identifies the device better than the
+// user agent when detecting logins from new devices. X-Forwarded-For is only trusted from trustedProxies.
+func ClientInfoMiddleware(trustedProxies []netip.Prefix) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := util.ClientInfo{
+				IPAddress:   util.ClientIP(r, trustedProxies),
+				UserAgent:   r.UserAgent(),
+				DeviceLabel: r.Header.Get("X-Device-Label"),
+				DeviceID:    r.Header.Get("X-Device-Id"),
+			}
+			if info.DeviceLabel == "" {
+				info.DeviceLabel = util.DeviceLabelFromUserAgent(info.UserAgent)
+			}
+			if len(info.DeviceLabel) > 100 {
+				info.DeviceLabel = info.DeviceLabel[:100]
+			}
+			if len(info.DeviceID) > 200 {
+				info.DeviceID = info.DeviceID[:200]
+			}
+
+			next.ServeHTTP(w, r.WithContext(util.WithClientInfo(r.Context(), info)))
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+	"user_service/internal/util"
+	"user_service/pkg/policy"
+)
+
+// ResourceLoader returns the attributes of the resource a request is about, identified by the route
+// variables. It returns nil when the resource does not exist, the handler reports that.
+type ResourceLoader func(ctx context.Context, vars map[string]string) (policy.Attributes, error)
+
+// Subject types in policy requests
+const (
+	SubjectUser    = "user"
+	SubjectService = "service"
+)
+
+// Authorize asks the policy engine whether the caller may perform the action on the resource. The
+// resource attributes are the route variables, so {id} is resource.id, completed by the loader.
+func (auth *AuthMiddleware) Authorize(action string, resourceType string, load ResourceLoader) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := r.Context().Value("user").(jwt.MapClaims)
+
+			subject, err := auth.subject(r.Context(), claims)
+			if err != nil {
+				util.ResponseErr(w, util.ResponseError{
+					Status:    "INTERNAL_SERVER_ERROR",
+					TimeStamp: time.Now().String(),
+					Message:   "failed to check permissions",
+					Errors:    nil,
+				}, http.StatusInternalServerError)
+				return
+			}
+
+			vars := mux.Vars(r)
+			resource := policy.Attributes{}
+			if load != nil {
+				loaded, err := load(r.Context(), vars)
+				if err != nil {
+					util.ResponseErr(w, util.ResponseError{
+						Status:    "INTERNAL_SERVER_ERROR",
+						TimeStamp: time.Now().String(),
+						Message:   "failed to load resource",
+						Errors:    nil,
+					}, http.StatusInternalServerError)
+					return
+				}
+				for name, value := range loaded {
+					resource[name] = value
+				}
+			}
+			// the route decides which resource is meant, a loader cannot override it
+			for name, value := range vars {
+				resource[name] = value
+			}
+			resource["type"] = resourceType
+
+			decision := auth.Policies.Decide(policy.Request{Subject: subject, Action: action, Resource: resource})
+			if decision.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   "forbidden",
+				Errors:    nil,
+			}, http.StatusForbidden)
+		})
+	}
+}
+
+// subject describes the caller to the policy engine, roles are the effective roles of a user or the roles
+// the scopes of a service account stand in for and permissions what those roles grant. actor is set while
+// an admin impersonates the user, who then holds no permissions, as in HasPermission.
+func (auth *AuthMiddleware) subject(ctx context.Context, claims jwt.MapClaims) (policy.Attributes, error) {
+	subject := policy.Attributes{}
+	for name, claim := range map[string]string{"role": "role", "scope": "scope", "client_id": "client_id"} {
+		if value, _ := claims[claim].(string); value != "" {
+			subject[name] = value
+		}
+	}
+
+	var roles []string
+	if isService(claims) {
+		subject["type"] = SubjectService
+		subject["id"], _ = claims["sub"].(string)
+		roles = serviceRoles(claims)
+	} else {
+		userID, _ := claims["userID"].(string)
+		var err error
+		if roles, err = auth.RBAC.UserRoles(ctx, userID); err != nil {
+			return nil, err
+		}
+		subject["type"] = SubjectUser
+		subject["id"] = userID
+	}
+	subject["roles"] = roles
+
+	permissions := []string{}
+	if actor := actor(claims); actor != "" {
+		subject["actor"] = actor
+	} else {
+		granted, err := auth.RBAC.RolePermissions(ctx, roles)
+		if err != nil {
+			return nil, err
+		}
+		permissions = granted
+	}
+	subject["permissions"] = permissions
+	return subject, nil
+}
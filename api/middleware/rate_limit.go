@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+// RateLimitKeyFunc returns the client a request is counted for
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitPolicy allows Limit requests per Window and client. Windows longer than a day are not supported.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    RateLimitKeyFunc
+}
+
+// RateLimiter limits requests with a sliding window: the count of the previous fixed window is weighted by
+// how much of it still overlaps the sliding window. Rejected requests are counted as well.
+type RateLimiter struct {
+	store repository.RateLimitStore
+	log   *zap.Logger
+}
+
+func NewRateLimiter(store repository.RateLimitStore, log *zap.Logger) *RateLimiter {
+	return &RateLimiter{store: store, log: log}
+}
+
+// KeyByIP counts requests per client IP, as resolved by ClientInfoMiddleware from the trusted proxies only.
+// Without it the peer address is used, never a header the client could rotate to get a fresh limit.
+func KeyByIP(r *http.Request) string {
+	if ip := util.ClientInfoFromContext(r.Context()).IPAddress; ip != "" {
+		return "ip:" + ip
+	}
+	return "ip:" + util.RemoteIP(r)
+}
+
+// KeyByUser counts requests per user or service account of a valid bearer token, anonymous requests per client IP.
+// It does not depend on AuthMiddleware so it can be used before routing.
+func KeyByUser(jwtService util.Jwt) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if claims, err := jwtService.ValidateAccessToken(token); err == nil {
+				if userID, ok := claims["userID"].(string); ok {
+					return "user:" + userID
+				}
+				if sub, ok := claims["sub"].(string); ok && isService(claims) {
+					return "service:" + sub
+				}
+			}
+		}
+		return KeyByIP(r)
+	}
+}
+
+// KeyByRoute shares one limit between every client of a route
+func KeyByRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return "route:" + r.Method + " " + template
+		}
+	}
+	return "route:" + r.Method + " " + r.URL.Path
+}
+
+// Limit applies a single policy, meant to wrap a handler
+func (l *RateLimiter) Limit(policy RateLimitPolicy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.allow(w, r, policy) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RouteMiddleware picks the policy by the path template of the matched route, the longest prefix in routes
+// wins and requests matching none use fallback. Prefixes match whole path segments: "/auth/verify" covers
+// "/auth/verify/{id}" but not "/auth/verify-email". It has to be added with Router.Use so the route is known.
+func (l *RateLimiter) RouteMiddleware(routes map[string]RateLimitPolicy, fallback RateLimitPolicy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := fallback
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					matched := ""
+					for prefix, p := range routes {
+						if matchesSegments(template, prefix) && len(prefix) > len(matched) {
+							matched, policy = prefix, p
+						}
+					}
+				}
+			}
+
+			if l.allow(w, r, policy) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// matchesSegments reports whether the path template is prefix or lies below it
+func matchesSegments(template, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return template == prefix || strings.HasPrefix(template, prefix+"/")
+}
+
+// allow counts the request and sets the RateLimit headers, it answers 429 itself when the limit is exceeded
+func (l *RateLimiter) allow(w http.ResponseWriter, r *http.Request, policy RateLimitPolicy) bool {
+	key := policy.Name + ":" + policy.Key(r)
+	current, previous, windowStart, err := l.store.Hit(r.Context(), key, policy.Window)
+	if err != nil {
+		// fail open, an unavailable store must not take the whole service down
+		l.log.Error("[Middleware][RateLimit] failed to count request", zap.String("policy", policy.Name), zap.Error(err))
+		return true
+	}
+
+	elapsed := time.Since(windowStart)
+	weight := 1 - float64(elapsed)/float64(policy.Window)
+	used := int(math.Ceil(float64(previous)*weight)) + current
+	reset := int(math.Ceil((policy.Window - elapsed).Seconds()))
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(policy.Limit-used, 0)))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+
+	if used <= policy.Limit {
+		return true
+	}
+
+	l.log.Info("[Middleware][RateLimit] rate limit exceeded", zap.String("policy", policy.Name), zap.String("key", key))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter(policy, current, previous, elapsed).Seconds()))))
+	util.ResponseErr(w, util.ResponseError{
+		Status:    "TOO_MANY_REQUESTS",
+		TimeStamp: time.Now().String(),
+		Message:   "too many requests",
+		Errors:    nil,
+	}, http.StatusTooManyRequests)
+	return false
+}
+
+// retryAfter returns how long until one more request fits into the sliding window, assuming the client waits
+func retryAfter(policy RateLimitPolicy, current, previous int, elapsed time.Duration) time.Duration {
+	window := float64(policy.Window)
+	free := float64(policy.Limit - 1)
+
+	// the weighted previous window has to decay until the request fits next to the current one
+	if current <= policy.Limit-1 && previous > 0 {
+		wait := window*(1-(free-float64(current))/float64(previous)) - float64(elapsed)
+		return time.Duration(max(wait, 0))
+	}
+
+	// the current window alone is full, wait for it to become the previous window and decay
+	wait := float64(policy.Window-elapsed) + window*(1-free/float64(current))
+	return time.Duration(max(wait, 0))
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+	"user_service/internal/repository/memory"
+)
+
+func TestKeyByIPIgnoresForwardedForFromClients(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:4000", "", "ip:203.0.113.7"},
+		{"direct client forging the header", "203.0.113.7:4000", "198.51.100.1", "ip:203.0.113.7"},
+		{"through a trusted proxy", "10.0.0.2:4000", "203.0.113.7", "ip:203.0.113.7"},
+		{"forged entry before the proxy's", "10.0.0.2:4000", "198.51.100.1, 203.0.113.7", "ip:203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:4000", "203.0.113.7, 10.0.0.3", "ip:203.0.113.7"},
+		{"invalid entry", "10.0.0.2:4000", "nonsense", "ip:10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := ClientInfoMiddleware(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = KeyByIP(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("KeyByIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitNotBypassedByRotatingForwardedFor(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), zap.NewNop())
+	policy := RateLimitPolicy{Name: "login", Limit: 3, Window: time.Hour, Key: KeyByIP}
+	handler := ClientInfoMiddleware(nil)(limiter.Limit(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for i, forwarded := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"} {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		r.RemoteAddr = "203.0.113.7:4000"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		want := http.StatusOK
+		if i >= policy.Limit {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+// fixedRateLimitStore reports the same counts for every hit, the current window started elapsed ago
+type fixedRateLimitStore struct {
+	current  int
+	previous int
+	elapsed  time.Duration
+	err      error
+}
+
+func (s fixedRateLimitStore) Hit(context.Context, string, time.Duration) (int, int, time.Time, error) {
+	return s.current, s.previous, time.Now().Add(-s.elapsed), s.err
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	tests := []struct {
+		name          string
+		store         fixedRateLimitStore
+		wantStatus    int
+		wantRemaining string
+		wantReset     string
+		wantRetry     string
+	}{
+		{
+			// 8 * 0.75 + 3 = 9
+			name:          "previous window weighted by its overlap",
+			store:         fixedRateLimitStore{current: 3, previous: 8, elapsed: 15 * time.Second},
+			wantStatus:    http.StatusOK,
+			wantRemaining: "1",
+			wantReset:     "45",
+		},
+		{
+			// 8 * 0.75 + 5 = 11, the previous window has to decay to 4 requests: 60s * (1 - 4/8) - 15s
+			name:          "over the limit with the previous window",
+			store:         fixedRateLimitStore{current: 5, previous: 8, elapsed: 15 * time.Second},
+			wantStatus:    http.StatusTooManyRequests,
+			wantRemaining: "0",
+			wantReset:     "45",
+			wantRetry:     "15",
+		},
+		{
+			name:          "exactly at the limit",
+			store:         fixedRateLimitStore{current: 10, elapsed: 30 * time.Second},
+			wantStatus:    http.StatusOK,
+			wantRemaining: "0",
+			wantReset:     "30",
+		},
+		{
+			// the current window becomes the previous one in 30s and has to decay to 9 of 11: 30s + 60s * 2/11
+			name:          "current window alone over the limit",
+			store:         fixedRateLimitStore{current: 11, elapsed: 30 * time.Second},
+			wantStatus:    http.StatusTooManyRequests,
+			wantRemaining: "0",
+			wantReset:     "30",
+			wantRetry:     "41",
+		},
+		{
+			// a partial request of the previous window still counts as one: ceil(20 / 60) + 1 = 2
+			name:          "previous window almost gone",
+			store:         fixedRateLimitStore{current: 1, previous: 20, elapsed: 59 * time.Second},
+			wantStatus:    http.StatusOK,
+			wantRemaining: "8",
+			wantReset:     "1",
+		},
+		{
+			// 10 + 1 = 11, the previous window has to decay to 8 requests: 60s * (1 - 8/10)
+			name:          "window just started after a full one",
+			store:         fixedRateLimitStore{current: 1, previous: 10},
+			wantStatus:    http.StatusTooManyRequests,
+			wantRemaining: "0",
+			wantReset:     "60",
+			wantRetry:     "12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(tt.store, zap.NewNop())
+			policy := RateLimitPolicy{Name: "test", Limit: 10, Window: time.Minute, Key: KeyByIP}
+			handler := limiter.Limit(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			headers := map[string]string{
+				"RateLimit-Policy":    "10;w=60",
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": tt.wantRemaining,
+				"RateLimit-Reset":     tt.wantReset,
+				"Retry-After":         tt.wantRetry,
+			}
+			for name, want := range headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	limiter := NewRateLimiter(fixedRateLimitStore{err: errors.New("store down")}, zap.NewNop())
+	policy := RateLimitPolicy{Name: "test", Limit: 1, Window: time.Minute, Key: KeyByIP}
+	handler := limiter.Limit(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("status %d with headers %v, want the request through without headers", w.Code, w.Header())
+	}
+}
+
+// TestRetryAfterLetsTheNextRequestThrough replays the window math at the time Retry-After points to
+func TestRetryAfterLetsTheNextRequestThrough(t *testing.T) {
+	policy := RateLimitPolicy{Limit: 10, Window: time.Minute}
+	window := float64(policy.Window)
+
+	for _, elapsed := range []time.Duration{0, time.Second, 15 * time.Second, 30 * time.Second, 59 * time.Second} {
+		for previous := 0; previous <= 25; previous++ {
+			for current := 1; current <= 25; current++ {
+				weight := 1 - float64(elapsed)/window
+				if int(math.Ceil(float64(previous)*weight))+current <= policy.Limit {
+					continue
+				}
+
+				// a millisecond more, Retry-After is rounded up to whole seconds anyway
+				at := elapsed + retryAfter(policy, current, previous, elapsed) + time.Millisecond
+				used := 0
+				switch {
+				case at < policy.Window:
+					used = int(math.Ceil(float64(previous)*(1-float64(at)/window))) + current + 1
+				case at < 2*policy.Window:
+					used = int(math.Ceil(float64(current)*(1-float64(at-policy.Window)/window))) + 1
+				default:
+					used = 1
+				}
+				if used > policy.Limit {
+					t.Errorf("current %d, previous %d, elapsed %s: %d requests used after waiting until %s",
+						current, previous, elapsed, used, at)
+				}
+			}
+		}
+	}
+}
+
+func TestRouteMiddlewareMatchesWholeSegments(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), zap.NewNop())
+	perIP := func(name string, limit int) RateLimitPolicy {
+		return RateLimitPolicy{Name: name, Limit: limit, Window: time.Minute, Key: KeyByIP}
+	}
+
+	router := mux.NewRouter()
+	router.Use(limiter.RouteMiddleware(map[string]RateLimitPolicy{
+		"/auth/verify":             perIP("verify", 1),
+		"/auth/magic-link":         perIP("magic-link", 2),
+		"/auth/magic-link/consume": perIP("magic-link-consume", 3),
+		"/users/":                  perIP("users", 4),
+	}, perIP("default", 10)))
+	for _, path := range []string{"/auth/verify", "/auth/verify-email", "/auth/magic-link", "/auth/magic-link/consume",
+		"/users", "/users/{id}", "/usersettings"} {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {})
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/auth/verify", "1"},
+		{"/auth/verify-email", "10"},
+		{"/auth/magic-link", "2"},
+		{"/auth/magic-link/consume", "3"},
+		{"/users", "4"},
+		{"/users/42", "4"},
+		{"/usersettings", "10"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := w.Header().Get("RateLimit-Limit"); got != tt.want {
+			t.Errorf("%s: limit %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// AdminHandler serves the account administration endpoints under /admin, each restricted to a permission
+type AdminHandler struct {
+	userService    service.UserService
+	lockoutService service.LockoutService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewAdminHandler(userService service.UserService, lockoutService service.LockoutService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *AdminHandler {
+	return &AdminHandler{userService: userService, lockoutService: lockoutService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *AdminHandler) RegisterRoutes(r *mux.Router) {
+	r = r.PathPrefix("/admin").Subrouter()
+	r.Use(h.authMiddleware.AuthMiddleware())
+	r.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	r.Handle("/users/{id}/unlock", h.authMiddleware.RequirePermission(models.PermUsersUnlock)(http.HandlerFunc(h.UnlockUser))).Methods(http.MethodPost)
+}
+
+var (
+	MessageUnlockUserSuccess = "Mở khóa tài khoản thành công"
+)
+
+// UnlockUser godoc
+// @Summary Unlock user
+// @Description Clear the failed login attempts of a locked account
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Success      200  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/users/{id}/unlock [post]
+func (h *AdminHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userService.GetByID(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   ErrNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][UnlockUser] failed to get user", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.lockoutService.Unlock(r.Context(), user.Email); err != nil {
+		h.log.Error("[Handler][UnlockUser] failed to unlock user", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	h.log.Info("[Handler][UnlockUser] account unlocked", zap.String("userID", user.ID))
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageUnlockUserSuccess,
+	}, http.StatusOK)
+}
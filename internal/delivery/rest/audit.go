@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// AuditHandler lets auditors search the audit log and check it was not tampered with
+type AuditHandler struct {
+	auditService   service.AuditService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewAuditHandler(auditService service.AuditService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *AuditHandler {
+	return &AuditHandler{auditService: auditService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *AuditHandler) RegisterRoutes(r *mux.Router) {
+	audit := r.PathPrefix("/admin/audit-events").Subrouter()
+	audit.Use(h.authMiddleware.AuthMiddleware())
+	audit.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	audit.Use(h.authMiddleware.RequirePermission(models.PermAuditRead))
+	audit.HandleFunc("", h.ListAuditEvents).Methods(http.MethodGet)
+	audit.HandleFunc("/verify", h.VerifyAuditLog).Methods(http.MethodGet)
+}
+
+var MessageInvalidTime = "Thời gian không hợp lệ, định dạng RFC 3339"
+
+// ListAuditEvents godoc
+// @Summary List audit events
+// @Description List security and admin events, newest first. Requires the audit:read permission.
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param actorId query string false "Actor ID"
+// @Param action query string false "Action, e.g. user.update"
+// @Param targetType query string false "Target type"
+// @Param targetId query string false "Target ID"
+// @Param from query string false "Events at or after this time (RFC 3339)"
+// @Param to query string false "Events before this time (RFC 3339)"
+// @Param page query int false "Page number (starts from 0)"
+// @Param pageSize query int false "Page size"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/audit-events [get]
+func (h *AuditHandler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		ActorID:    query.Get("actorId"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("targetType"),
+		TargetID:   query.Get("targetId"),
+	}
+	for field, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(field)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInvalidRequest,
+				Errors:    []util.ErrReason{{Field: field, Message: MessageInvalidTime}},
+			}, http.StatusBadRequest)
+			return
+		}
+		*bound = &t
+	}
+
+	params := util.GetPaginationParams(r)
+	events, total, err := h.auditService.List(r.Context(), filter, params)
+	if err != nil {
+		h.log.Error("[Handler][ListAuditEvents] failed to list events", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.CreatePaginationResponse(events, total, params), http.StatusOK)
+}
+
+// VerifyAuditLog godoc
+// @Summary Verify audit log
+// @Description Recompute the hash chain of the audit log. An event that was changed, or removed from between others, breaks the chain.
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {object}  models.AuditVerification
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/audit-events/verify [get]
+func (h *AuditHandler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	result, err := h.auditService.Verify(r.Context())
+	if err != nil {
+		h.log.Error("[Handler][VerifyAuditLog] failed to verify audit log", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, result, http.StatusOK)
+}
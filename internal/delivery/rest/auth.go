@@ -5,7 +5,10 @@ import (
 	"errors"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 	"user_service/internal/models"
 	"user_service/internal/service"
@@ -13,15 +16,24 @@ import (
 )
 
 type AuthHandler struct {
-	authService models.AuthService
-	userService service.UserService
-	jwtService  util.JwtImpl
-	router      *mux.Router
-	log         *zap.Logger
+	authService              models.AuthService
+	userService              service.UserService
+	passwordResetService     service.PasswordResetService
+	emailVerificationService service.EmailVerificationService
+	mfaService               service.MFAService
+	lockoutService           service.LockoutService
+	loginHistory             service.LoginHistoryService
+	jwtService               util.JwtImpl
+	router                   *mux.Router
+	log                      *zap.Logger
 }
 
-func NewAuthHandler(authService models.AuthService, userService service.UserService, router *mux.Router, log *zap.Logger, jwtService util.JwtImpl) *AuthHandler {
-	return &AuthHandler{authService: authService, log: log, router: router, userService: userService, jwtService: jwtService}
+func NewAuthHandler(authService models.AuthService, userService service.UserService, passwordResetService service.PasswordResetService,
+	emailVerificationService service.EmailVerificationService, mfaService service.MFAService, lockoutService service.LockoutService,
+	loginHistory service.LoginHistoryService, router *mux.Router, log *zap.Logger, jwtService util.JwtImpl) *AuthHandler {
+	return &AuthHandler{authService: authService, log: log, router: router, userService: userService, passwordResetService: passwordResetService,
+		emailVerificationService: emailVerificationService, mfaService: mfaService, lockoutService: lockoutService, loginHistory: loginHistory,
+		jwtService: jwtService}
 }
 
 func (h *AuthHandler) RegisterRoutes() {
@@ -30,8 +42,10 @@ func (h *AuthHandler) RegisterRoutes() {
 	h.router.HandleFunc("/register", h.Register).Methods("POST")
 	h.router.HandleFunc("/refresh", h.RefreshToken).Methods("POST")
 	h.router.HandleFunc("/logout", h.Logout).Methods("POST")
-	//h.router.HandleFunc("/forgot-password", h.ForgotPassword).Methods("POST")
-	//h.router.HandleFunc("/reset-password", h.ResetPassword).Methods("POST")
+	h.router.HandleFunc("/forgot-password", h.ForgotPassword).Methods("POST")
+	h.router.HandleFunc("/reset-password", h.ResetPassword).Methods("POST")
+	h.router.HandleFunc("/verify-email", h.VerifyEmail).Methods("POST")
+	h.router.HandleFunc("/resend-verification", h.ResendVerification).Methods("POST")
 }
 
 var (
@@ -42,12 +56,29 @@ var (
 	MessageRefreshTokenExpired  = "Refresh token hết hạn"
 	MessageRefreshTokenSuccess  = "Refresh token thành công"
 	MessageInvalidCredentials   = "Thông tin đăng nhập không hợp lệ"
+	MessageForgotPasswordSent   = "Nếu email tồn tại, hướng dẫn đặt lại mật khẩu đã được gửi"
+	MessageResetPasswordSuccess = "Đặt lại mật khẩu thành công"
+	MessageInvalidResetToken    = "Liên kết đặt lại mật khẩu không hợp lệ hoặc đã hết hạn"
+	MessageRegisterVerifyEmail  = "Đăng ký thành công, vui lòng kiểm tra email để xác thực tài khoản"
+	MessageEmailNotVerified     = "Email chưa được xác thực, vui lòng kiểm tra hộp thư"
+	MessageVerifyEmailSuccess   = "Xác thực email thành công"
+	MessageVerificationSent     = "Nếu tài khoản đang chờ xác thực, email xác thực đã được gửi lại"
+	MessageInvalidVerifyToken   = "Liên kết xác thực không hợp lệ hoặc đã hết hạn"
+	MessageRefreshTokenReused   = "Phiên đăng nhập đã bị thu hồi, vui lòng đăng nhập lại"
+	MessageAccountLocked        = "Tài khoản tạm thời bị khóa do đăng nhập sai nhiều lần, vui lòng thử lại sau"
+	MessageTooManyAttempts      = "Đăng nhập sai nhiều lần, vui lòng chờ trước khi thử lại"
+	MessageLoginConfirmation    = "Đăng nhập từ thiết bị hoặc vị trí lạ, vui lòng xác nhận qua liên kết đã gửi tới email"
 )
 
 const (
 	BAD_REQUEST           = "BAD_REQUEST"
 	UNAUTHORIZED          = "UNAUTHORIZED"
 	INTERNAL_SERVER_ERROR = "INTERNAL_SERVER_ERROR"
+	EMAIL_NOT_VERIFIED    = "EMAIL_NOT_VERIFIED"
+	REFRESH_TOKEN_REUSED  = "REFRESH_TOKEN_REUSED"
+	ACCOUNT_LOCKED        = "ACCOUNT_LOCKED"
+	TOO_MANY_ATTEMPTS     = "TOO_MANY_ATTEMPTS"
+	LOGIN_CONFIRMATION    = "LOGIN_CONFIRMATION_REQUIRED"
 )
 
 // Login godoc
@@ -59,7 +90,9 @@ const (
 // @Param login body models.LoginRequest true "Login request"
 // @Success      200  {object}  util.Response
 // @Failure      400  {object}  util.Response
-// @Failure      404  {object}  util.Response
+// @Failure      401  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      429  {object}  util.Response
 // @Failure      500  {object}  util.Response
 // @Router       /auth/login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -100,11 +133,26 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := util.ClientInfoFromContext(r.Context()).IPAddress
+	if err := h.lockoutService.Check(r.Context(), loginRequest.Email, ip); err != nil {
+		var lockoutErr *service.LockoutError
+		if errors.As(err, &lockoutErr) {
+			h.loginHistory.RecordFailure(r.Context(), "", loginRequest.Email, models.LoginMethodPassword, models.LoginFailureLocked)
+		}
+		h.lockoutErr(w, err)
+		return
+	}
+
 	// Call service
 	res, err := h.userService.Validate(r.Context(), loginRequest.Email, loginRequest.Password)
 	if err != nil {
-		if errors.Is(err, service.ErrUserNotFound) {
-			h.log.Error("[Handler][Login] user not found", zap.Error(err))
+		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidEmailOrPassword) {
+			h.log.Info("[Handler][Login] invalid credentials", zap.Error(err))
+			h.loginHistory.RecordFailure(r.Context(), "", loginRequest.Email, models.LoginMethodPassword, models.LoginFailureInvalidCredentials)
+			if err := h.lockoutService.RegisterFailure(r.Context(), loginRequest.Email, ip); err != nil {
+				h.lockoutErr(w, err)
+				return
+			}
 			util.ResponseErr(w, util.ResponseError{
 				Status:    UNAUTHORIZED,
 				TimeStamp: time.Now().String(),
@@ -113,6 +161,28 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 			}, http.StatusUnauthorized)
 			return
 		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			h.log.Info("[Handler][Login] email not verified", zap.Error(err))
+			h.loginHistory.RecordFailure(r.Context(), "", loginRequest.Email, models.LoginMethodPassword, models.LoginFailureEmailNotVerified)
+			util.ResponseErr(w, util.ResponseError{
+				Status:    EMAIL_NOT_VERIFIED,
+				TimeStamp: time.Now().String(),
+				Message:   MessageEmailNotVerified,
+				Errors:    nil,
+			}, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, service.ErrAccountInactive) {
+			h.log.Info("[Handler][Login] user inactive", zap.Error(err))
+			h.loginHistory.RecordFailure(r.Context(), "", loginRequest.Email, models.LoginMethodPassword, models.LoginFailureInactive)
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   MessageAccountInactive,
+				Errors:    nil,
+			}, http.StatusForbidden)
+			return
+		}
 		h.log.Error("[Handler][Login] failed to login", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
@@ -123,9 +193,20 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.jwtService.GenerateAccessToken(res.ID, res.Role)
+	if err := h.lockoutService.RegisterSuccess(r.Context(), loginRequest.Email); err != nil {
+		h.log.Error("[Handler][Login] failed to reset login failures", zap.Error(err))
+	}
+
+	completeLogin(w, r, h.log, h.mfaService, h.authService, res, models.LoginMethodPassword, "[Handler][Login]")
+}
+
+// completeLogin answers a login once the first factor was checked. Accounts with MFA only get a challenge here,
+// tokens are issued by /auth/mfa/verify.
+func completeLogin(w http.ResponseWriter, r *http.Request, log *zap.Logger, mfaService service.MFAService, authService models.AuthService,
+	user *models.User, method string, scope string) {
+	mfaEnabled, err := mfaService.IsEnabled(r.Context(), user.ID)
 	if err != nil {
-		h.log.Error("[Handler][Login] failed to generate token", zap.Error(err))
+		log.Error(scope+" failed to check mfa", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
 			TimeStamp: time.Now().String(),
@@ -135,10 +216,36 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate refresh token
-	refreshToken, err := h.jwtService.GenerateRefreshToken(res.ID, res.Role)
+	if mfaEnabled {
+		challenge, err := mfaService.CreateChallenge(r.Context(), user.ID)
+		if err != nil {
+			log.Error(scope+" failed to create mfa challenge", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    INTERNAL_SERVER_ERROR,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInternalServerError,
+				Errors:    nil,
+			}, http.StatusInternalServerError)
+			return
+		}
+
+		util.ResponseOK(w, challenge, http.StatusOK)
+		return
+	}
+
+	loginResponse, err := authService.IssueTokens(r.Context(), user, method)
 	if err != nil {
-		h.log.Error("[AuthService][Login] failed to generate refresh token", zap.Error(err))
+		if errors.Is(err, service.ErrStepUpRequired) {
+			log.Info(scope+" suspicious login must be confirmed", zap.String("userID", user.ID))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    LOGIN_CONFIRMATION,
+				TimeStamp: time.Now().String(),
+				Message:   MessageLoginConfirmation,
+				Errors:    nil,
+			}, http.StatusForbidden)
+			return
+		}
+		log.Error(scope+" failed to issue tokens", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
 			TimeStamp: time.Now().String(),
@@ -148,30 +255,39 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Store refresh token
-	err = h.authService.SaveToken(r.Context(), refreshToken, res.ID)
-	if err != nil {
-		h.log.Error("[AuthService][Login] failed to save refresh token", zap.Error(err))
+	// Response
+	util.ResponseOK(w, loginResponse, http.StatusOK)
+}
+
+func (h *AuthHandler) lockoutErr(w http.ResponseWriter, err error) {
+	respondLockout(w, h.log, "[Handler][Login]", err)
+}
+
+// respondLockout answers a login refused by the lockout service, Retry-After tells the client when to try again
+func respondLockout(w http.ResponseWriter, log *zap.Logger, scope string, err error) {
+	var lockoutErr *service.LockoutError
+	if !errors.As(err, &lockoutErr) {
+		log.Error(scope+" failed to check lockout", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
 			TimeStamp: time.Now().String(),
 			Message:   ErrInternalServerError,
-			Errors:    nil,
 		}, http.StatusInternalServerError)
 		return
 	}
 
-	// Response
-	util.ResponseOK(w, models.LoginResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-		User: &models.UserSummary{
-			Id:    res.ID,
-			Name:  res.FullName,
-			Email: res.Email,
-			Role:  res.Role,
-		},
-	}, http.StatusOK)
+	status, message := TOO_MANY_ATTEMPTS, MessageTooManyAttempts
+	if errors.Is(err, service.ErrAccountLocked) {
+		status, message = ACCOUNT_LOCKED, MessageAccountLocked
+	}
+
+	log.Info(scope+" login refused", zap.Error(err), zap.Duration("retryAfter", lockoutErr.RetryAfter))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockoutErr.RetryAfter.Seconds()))))
+	util.ResponseErr(w, util.ResponseError{
+		Status:    status,
+		TimeStamp: time.Now().String(),
+		Message:   message,
+	}, http.StatusTooManyRequests)
 }
 
 // Register godoc
@@ -235,6 +351,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status := models.StatusActive
+	if h.emailVerificationService.Enabled() {
+		status = models.StatusPendingVerification
+	}
+
 	// Call service
 	user, err = h.userService.Create(r.Context(), models.CreateUserInput{
 		Email:    registerRequest.Email,
@@ -242,9 +363,13 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		FullName: registerRequest.FullName,
 		Phone:    "default",
 		Role:     models.RoleUser,
+		Status:   status,
 	})
 
 	if err != nil {
+		if passwordPolicyErr(w, err, "password") {
+			return
+		}
 		h.log.Error("[Handler][Register] failed to register", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
@@ -255,18 +380,22 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	message := MessageRegisterSuccess
+	if user.Status == models.StatusPendingVerification {
+		// the account exists at this point, a failed mail can be retried through /auth/resend-verification
+		if err := h.emailVerificationService.SendVerification(r.Context(), user); err != nil {
+			h.log.Error("[Handler][Register] failed to send verification email", zap.Error(err))
+		}
+		message = MessageRegisterVerifyEmail
+	}
+
 	// Response
 	util.ResponseOK(w, struct {
 		Message string              `json:"message"`
 		User    *models.UserSummary `json:"user"`
 	}{
-		Message: MessageRegisterSuccess,
-		User: &models.UserSummary{
-			Id:    user.ID,
-			Name:  user.FullName,
-			Email: user.Email,
-			Role:  user.Role,
-		},
+		Message: message,
+		User:    models.NewUserSummary(user),
 	}, http.StatusCreated)
 }
 
@@ -299,6 +428,16 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	// Call service
 	accessToken, refreshToken, err := h.authService.RefreshToken(r.Context(), refreshTokenRequest.RefreshToken)
 	if err != nil {
+		if errors.Is(err, service.ErrTokenReused) {
+			h.log.Error("[Handler][RefreshToken] refresh token reused, session revoked", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    REFRESH_TOKEN_REUSED,
+				TimeStamp: time.Now().String(),
+				Message:   MessageRefreshTokenReused,
+				Errors:    nil,
+			}, http.StatusUnauthorized)
+			return
+		}
 		if errors.Is(err, service.ErrExpiredToken) {
 			h.log.Error("[Handler][RefreshToken] token expired", zap.Error(err))
 			util.ResponseErr(w, util.ResponseError{
@@ -363,7 +502,266 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the access token sent along is denylisted too, otherwise it stays usable until it expires
+	if accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if err := h.authService.RevokeAccessToken(r.Context(), accessToken); err != nil {
+			h.log.Error("[Handler][Logout] failed to revoke access token", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    INTERNAL_SERVER_ERROR,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInternalServerError,
+			}, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	util.ResponseOK(w, util.ResponseSuccess{
 		Message: "Operation successful",
 	}, http.StatusOK)
 }
+
+// ForgotPassword godoc
+// @Summary Forgot password
+// @Description Send a password reset link to the user's email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param forgot_password body models.ForgotPasswordRequest true "Forgot password request"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/forgot-password [post]
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&forgotRequest); err != nil {
+		h.log.Error("[Handler][ForgotPassword] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := forgotRequest.Validate(); err != nil {
+		h.log.Error("[Handler][ForgotPassword] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "email",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.passwordResetService.ForgotPassword(r.Context(), forgotRequest.Email); err != nil {
+		h.log.Error("[Handler][ForgotPassword] failed to send reset link", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageForgotPasswordSent,
+	}, http.StatusOK)
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password using the token from the reset link
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param reset_password body models.ResetPasswordRequest true "Reset password request"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/reset-password [post]
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var resetRequest models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil {
+		h.log.Error("[Handler][ResetPassword] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := resetRequest.Validate(); err != nil {
+		field := "newPassword"
+		if errors.Is(err, models.ErrTokenEmpty) {
+			field = "token"
+		}
+		h.log.Error("[Handler][ResetPassword] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   field,
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.passwordResetService.ResetPassword(r.Context(), resetRequest.Token, resetRequest.NewPassword); err != nil {
+		if passwordPolicyErr(w, err, "newPassword") {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			h.log.Error("[Handler][ResetPassword] invalid reset token", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   MessageInvalidResetToken,
+			}, http.StatusBadRequest)
+			return
+		}
+		h.log.Error("[Handler][ResetPassword] failed to reset password", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageResetPasswordSuccess,
+	}, http.StatusOK)
+}
+
+// VerifyEmail godoc
+// @Summary Verify email
+// @Description Verify the user's email using the token from the verification link
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param verify_email body models.VerifyEmailRequest true "Verify email request"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil {
+		h.log.Error("[Handler][VerifyEmail] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyRequest.Validate(); err != nil {
+		h.log.Error("[Handler][VerifyEmail] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "token",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.emailVerificationService.Verify(r.Context(), verifyRequest.Token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidVerificationToken) {
+			h.log.Error("[Handler][VerifyEmail] invalid verification token", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   MessageInvalidVerifyToken,
+			}, http.StatusBadRequest)
+			return
+		}
+		h.log.Error("[Handler][VerifyEmail] failed to verify email", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, struct {
+		Message string              `json:"message"`
+		User    *models.UserSummary `json:"user"`
+	}{
+		Message: MessageVerifyEmailSuccess,
+		User:    models.NewUserSummary(user),
+	}, http.StatusOK)
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification link to an account pending verification
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param resend_verification body models.ResendVerificationRequest true "Resend verification request"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/resend-verification [post]
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var resendRequest models.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&resendRequest); err != nil {
+		h.log.Error("[Handler][ResendVerification] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := resendRequest.Validate(); err != nil {
+		h.log.Error("[Handler][ResendVerification] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "email",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.emailVerificationService.Resend(r.Context(), resendRequest.Email); err != nil {
+		h.log.Error("[Handler][ResendVerification] failed to resend verification", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageVerificationSent,
+	}, http.StatusOK)
+}
@@ -0,0 +1,351 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// ExternalLoginHandler serves the login through external OpenID Connect providers, the identities linked
+// to an account and the provider administration
+type ExternalLoginHandler struct {
+	federationService service.FederationService
+	mfaService        service.MFAService
+	authService       models.AuthService
+	authMiddleware    *middleware.AuthMiddleware
+	log               *zap.Logger
+}
+
+func NewExternalLoginHandler(federationService service.FederationService, mfaService service.MFAService, authService models.AuthService,
+	authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *ExternalLoginHandler {
+	return &ExternalLoginHandler{federationService: federationService, mfaService: mfaService, authService: authService,
+		authMiddleware: authMiddleware, log: log}
+}
+
+func (h *ExternalLoginHandler) RegisterRoutes(r *mux.Router) {
+	external := r.PathPrefix("/auth/external").Subrouter()
+	external.HandleFunc("/providers", h.ListProviders).Methods(http.MethodGet)
+	external.HandleFunc("/{provider}/login", h.StartLogin).Methods(http.MethodGet)
+	external.HandleFunc("/{provider}/callback", h.Callback).Methods(http.MethodPost)
+
+	identities := r.PathPrefix("/users/{id}/identities").Subrouter()
+	identities.Use(h.authMiddleware.AuthMiddleware())
+	identities.Use(h.authMiddleware.SelfOrAdminMiddleware())
+	identities.Use(h.authMiddleware.RequireScope())
+	identities.HandleFunc("", h.ListIdentities).Methods(http.MethodGet)
+	identities.HandleFunc("/{iid}", h.Unlink).Methods(http.MethodDelete)
+
+	admin := r.PathPrefix("/admin/identity-providers").Subrouter()
+	admin.Use(h.authMiddleware.AuthMiddleware())
+	admin.Use(h.authMiddleware.ACLMiddleware("admin"))
+	admin.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	admin.HandleFunc("", h.AdminListProviders).Methods(http.MethodGet)
+	admin.HandleFunc("/{provider}", h.SaveProvider).Methods(http.MethodPut)
+	admin.HandleFunc("/{provider}", h.DeleteProvider).Methods(http.MethodDelete)
+}
+
+var (
+	MessageUnlinkIdentitySuccess   = "Đã hủy liên kết tài khoản"
+	MessageDeleteProviderSuccess   = "Xóa nhà cung cấp đăng nhập thành công"
+	MessageInvalidExternalLogin    = "Đăng nhập không hợp lệ hoặc đã hết hạn, vui lòng thử lại"
+	MessageExternalEmailUnverified = "Nhà cung cấp đăng nhập chưa xác thực email của bạn"
+	MessageProviderUnavailable     = "Nhà cung cấp đăng nhập hiện không khả dụng"
+)
+
+// ListProviders godoc
+// @Summary List login providers
+// @Description List the enabled external identity providers the login page can offer
+// @Tags auth
+// @Produce json
+// @Success      200  {array}   models.IdentityProviderSummary
+// @Failure      500  {object}  util.Response
+// @Router       /auth/external/providers [get]
+func (h *ExternalLoginHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := h.federationService.ListProviders(r.Context(), true)
+	if err != nil {
+		h.log.Error("[Handler][ListProviders] failed to list providers", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]models.IdentityProviderSummary, 0, len(providers))
+	for _, provider := range providers {
+		summaries = append(summaries, models.IdentityProviderSummary{ID: provider.ID, Name: provider.Name})
+	}
+
+	util.ResponseOK(w, summaries, http.StatusOK)
+}
+
+// StartLogin godoc
+// @Summary Login with external provider
+// @Description Redirect the browser to the identity provider. The provider redirects back to the frontend, which posts code and state to the callback endpoint and should check the state is the one it started with.
+// @Tags auth
+// @Param provider path string true "Provider ID"
+// @Success      302
+// @Failure      404  {object}  util.Response
+// @Failure      502  {object}  util.Response
+// @Router       /auth/external/{provider}/login [get]
+func (h *ExternalLoginHandler) StartLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, err := h.federationService.StartLogin(r.Context(), mux.Vars(r)["provider"])
+	if err != nil {
+		h.federationErr(w, err, "[Handler][ExternalLogin]")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback godoc
+// @Summary Complete external login
+// @Description Redeem the code the identity provider returned. Returns tokens, or an MFA challenge when the account has MFA enabled.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param provider path string true "Provider ID"
+// @Param callback body models.ExternalLoginRequest true "Code and state from the provider redirect"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      400  {object}  util.Response
+// @Failure      401  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Router       /auth/external/{provider}/callback [post]
+func (h *ExternalLoginHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	var req models.ExternalLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "callback",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.federationService.CompleteLogin(r.Context(), mux.Vars(r)["provider"], req)
+	if err != nil {
+		h.federationErr(w, err, "[Handler][ExternalLoginCallback]")
+		return
+	}
+
+	completeLogin(w, r, h.log, h.mfaService, h.authService, user, models.LoginMethodExternal, "[Handler][ExternalLoginCallback]")
+}
+
+// ListIdentities godoc
+// @Summary List linked identities
+// @Description List the external provider identities linked to a user
+// @Tags users
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Success      200  {array}   models.ExternalIdentity
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/identities [get]
+func (h *ExternalLoginHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	identities, err := h.federationService.ListIdentities(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.log.Error("[Handler][ListIdentities] failed to list identities", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, identities, http.StatusOK)
+}
+
+// Unlink godoc
+// @Summary Unlink identity
+// @Description Remove the link between a user and an external provider identity
+// @Tags users
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param iid path string true "Identity ID"
+// @Success      200  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Router       /users/{id}/identities/{iid} [delete]
+func (h *ExternalLoginHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.federationService.Unlink(r.Context(), vars["id"], vars["iid"]); err != nil {
+		h.federationErr(w, err, "[Handler][Unlink]")
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageUnlinkIdentitySuccess,
+	}, http.StatusOK)
+}
+
+// AdminListProviders godoc
+// @Summary List identity providers
+// @Description List every configured external identity provider, the client secrets are not returned
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {array}   models.IdentityProvider
+// @Failure      500  {object}  util.Response
+// @Router       /admin/identity-providers [get]
+func (h *ExternalLoginHandler) AdminListProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := h.federationService.ListProviders(r.Context(), false)
+	if err != nil {
+		h.log.Error("[Handler][AdminListProviders] failed to list providers", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, providers, http.StatusOK)
+}
+
+// SaveProvider godoc
+// @Summary Save identity provider
+// @Description Create or replace an external OpenID Connect provider. An empty client secret keeps the stored one.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param provider path string true "Provider ID, a lowercase slug"
+// @Param config body models.SaveIdentityProviderInput true "Provider configuration"
+// @Success      200  {object}  models.IdentityProvider
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/identity-providers/{provider} [put]
+func (h *ExternalLoginHandler) SaveProvider(w http.ResponseWriter, r *http.Request) {
+	var input models.SaveIdentityProviderInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["provider"]
+	err := models.ValidateProviderID(id)
+	if err == nil {
+		err = input.Validate()
+	}
+	if err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "provider",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	provider, err := h.federationService.SaveProvider(r.Context(), id, input)
+	if err != nil {
+		h.log.Error("[Handler][SaveProvider] failed to save provider", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, provider, http.StatusOK)
+}
+
+// DeleteProvider godoc
+// @Summary Delete identity provider
+// @Description Delete an external identity provider together with the identities linked through it
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param provider path string true "Provider ID"
+// @Success      200  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Router       /admin/identity-providers/{provider} [delete]
+func (h *ExternalLoginHandler) DeleteProvider(w http.ResponseWriter, r *http.Request) {
+	if err := h.federationService.DeleteProvider(r.Context(), mux.Vars(r)["provider"]); err != nil {
+		h.federationErr(w, err, "[Handler][DeleteProvider]")
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageDeleteProviderSuccess,
+	}, http.StatusOK)
+}
+
+func (h *ExternalLoginHandler) federationErr(w http.ResponseWriter, err error, scope string) {
+	switch {
+	case errors.Is(err, service.ErrProviderNotFound), errors.Is(err, service.ErrIdentityNotFound):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "NOT_FOUND",
+			TimeStamp: time.Now().String(),
+			Message:   ErrNotFound,
+		}, http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidExternalLogin):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    UNAUTHORIZED,
+			TimeStamp: time.Now().String(),
+			Message:   MessageInvalidExternalLogin,
+		}, http.StatusUnauthorized)
+	case errors.Is(err, service.ErrExternalEmailNotVerified):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    EMAIL_NOT_VERIFIED,
+			TimeStamp: time.Now().String(),
+			Message:   MessageExternalEmailUnverified,
+		}, http.StatusForbidden)
+	case errors.Is(err, service.ErrAccountInactive):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "FORBIDDEN",
+			TimeStamp: time.Now().String(),
+			Message:   MessageAccountInactive,
+		}, http.StatusForbidden)
+	case errors.Is(err, service.ErrExternalProviderFailed):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "BAD_GATEWAY",
+			TimeStamp: time.Now().String(),
+			Message:   MessageProviderUnavailable,
+		}, http.StatusBadGateway)
+	default:
+		h.log.Error(scope+" unexpected error", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+	}
+}
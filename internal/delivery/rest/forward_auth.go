@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"user_service/api/middleware"
+)
+
+// ForwardAuthHandler lets an API gateway (Kong, nginx auth_request, Traefik ForwardAuth) authenticate the
+// requests to the services behind it. The gateway has to drop the X-User-* headers clients send and
+// forward the ones of the response instead.
+type ForwardAuthHandler struct {
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewForwardAuthHandler(authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *ForwardAuthHandler {
+	return &ForwardAuthHandler{authMiddleware: authMiddleware, log: log}
+}
+
+// Forward auth headers, requests name the roles they require in HeaderRequiredRole and the identity of the
+// caller is returned in the others
+const (
+	HeaderRequiredRole = "X-Required-Role"
+	HeaderUserID       = "X-User-Id"
+	HeaderUserRole     = "X-User-Role"
+	HeaderClientID     = "X-Client-Id"
+	HeaderScope        = "X-Scope"
+	HeaderActorID      = "X-Actor-Id"
+)
+
+func (h *ForwardAuthHandler) RegisterRoutes(r *mux.Router) {
+	// every method, gateways forward the method of the original request
+	r.Handle("/auth/verify", h.authMiddleware.AuthMiddleware()(http.HandlerFunc(h.Verify)))
+}
+
+// Verify godoc
+// @Summary Forward auth
+// @Description Validate the bearer token like every authenticated route does, for API gateways. Answers 200 with the caller in X-User-Id, X-User-Role, for OAuth clients and service accounts X-Client-Id and X-Scope, and while an admin impersonates the user X-Actor-Id. Required roles are given as a comma separated list in the X-Required-Role header or the role query parameter, any of them is enough.
+// @Tags auth
+// @Security JWT
+// @Param role query string false "Required roles, comma separated"
+// @Param X-Required-Role header string false "Required roles, comma separated"
+// @Success      200
+// @Failure      401  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Router       /auth/verify [get]
+func (h *ForwardAuthHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	roles := requiredRoles(r)
+	if len(roles) == 0 {
+		h.allow(w, r)
+		return
+	}
+
+	h.authMiddleware.ACLMiddleware(roles...)(http.HandlerFunc(h.allow)).ServeHTTP(w, r)
+}
+
+func (h *ForwardAuthHandler) allow(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+
+	for header, claim := range map[string]string{
+		HeaderUserID:   "userID",
+		HeaderUserRole: "role",
+		HeaderClientID: "client_id",
+		HeaderScope:    "scope",
+	} {
+		if value, _ := claims[claim].(string); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+
+	// the admin impersonating the user, services should refuse sensitive actions when it is set
+	if act, ok := claims["act"].(map[string]any); ok {
+		if actor, _ := act["sub"].(string); actor != "" {
+			w.Header().Set(HeaderActorID, actor)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// requiredRoles reads the roles from the header, falling back to the query string
+func requiredRoles(r *http.Request) []string {
+	value := r.Header.Get(HeaderRequiredRole)
+	if value == "" {
+		value = r.URL.Query().Get("role")
+	}
+
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/gorilla/mux"
@@ -11,6 +12,8 @@ import (
 	"user_service/internal/models"
 	"user_service/internal/service"
 	"user_service/internal/util"
+	"user_service/pkg/password"
+	"user_service/pkg/policy"
 )
 
 type UserHandler struct {
@@ -30,19 +33,49 @@ func (h *UserHandler) RegisterRoutes(r *mux.Router) {
 	})
 	r = r.PathPrefix("/users").Subrouter()
 	r.Use(h.authMiddleware.AuthMiddleware())
-	r.Use(h.authMiddleware.OwnerMiddleware())
-	r.Handle("", h.authMiddleware.ACLMiddleware("admin", "user")((http.HandlerFunc)(h.ListUsers))).Methods(http.MethodGet)
-	r.Handle("/", h.authMiddleware.ACLMiddleware("admin")((http.HandlerFunc)(h.CreateUser))).Methods(http.MethodPost)
-	r.Handle("/{id}", h.authMiddleware.ACLMiddleware("admin", "user")((http.HandlerFunc)(h.GetUser))).Methods(http.MethodGet)
-	r.Handle("/{id}", h.authMiddleware.ACLMiddleware("admin", "user")((http.HandlerFunc)(h.UpdateUser))).Methods(http.MethodPut)
-	r.Handle("/{id}", h.authMiddleware.ACLMiddleware("admin")((http.HandlerFunc)(h.DeleteUser))).Methods(http.MethodDelete)
-	r.Handle("/{id}/change-password", h.authMiddleware.ACLMiddleware("admin", "user")((http.HandlerFunc)(h.ChangePassword))).Methods(http.MethodPut)
+	read := h.authMiddleware.RequireScope(models.ScopeUsersRead, models.ScopeUsersWrite)
+	write := h.authMiddleware.RequireScope(models.ScopeUsersWrite)
+	interactive := h.authMiddleware.RequireScope()
+	authorize := func(action string) func(http.Handler) http.Handler {
+		return h.authMiddleware.Authorize(action, ResourceUser, h.loadUser)
+	}
+	r.Handle("", h.authMiddleware.ACLMiddleware("admin", "user")(read(http.HandlerFunc(h.ListUsers)))).Methods(http.MethodGet)
+	r.Handle("/", h.authMiddleware.RequirePermission(models.PermUsersCreate)(write(http.HandlerFunc(h.CreateUser)))).Methods(http.MethodPost)
+	r.Handle("/{id}", read(authorize(ActionUsersRead)(http.HandlerFunc(h.GetUser)))).Methods(http.MethodGet)
+	r.Handle("/{id}", write(authorize(ActionUsersUpdate)(http.HandlerFunc(h.UpdateUser)))).Methods(http.MethodPut)
+	r.Handle("/{id}", h.authMiddleware.RequirePermission(models.PermUsersDelete)(write(authorize(ActionUsersDelete)(http.HandlerFunc(h.DeleteUser))))).Methods(http.MethodDelete)
+	r.Handle("/{id}/change-password", interactive(authorize(ActionUsersChangePassword)(http.HandlerFunc(h.ChangePassword)))).Methods(http.MethodPut)
+}
+
+var MessageInvalidStatus = "Trạng thái không hợp lệ, chỉ chấp nhận active, inactive hoặc pending_verification"
+
+// Policy actions on users and the resource type they apply to, see policies/users.json
+const (
+	ResourceUser              = "user"
+	ActionUsersRead           = "users:read"
+	ActionUsersUpdate         = "users:update"
+	ActionUsersDelete         = "users:delete"
+	ActionUsersChangePassword = "users:change-password"
+)
+
+// loadUser gives the policies the account a request is about
+func (h *UserHandler) loadUser(ctx context.Context, vars map[string]string) (policy.Attributes, error) {
+	user, err := h.userService.GetByID(ctx, vars["id"])
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return policy.Attributes{"id": user.ID, "role": user.Role, "status": user.Status}, nil
 }
 
 var (
 	ErrInvalidRequest      = "Dữ liệu không hợp lệ, vui lòng kiểm tra lại"
 	ErrInternalServerError = "Lỗi hệ thống, vui lòng thử lại sau"
 	ErrNotFound            = "Không tìm thấy, vui lòng kiểm tra lại"
+	ErrWeakPassword        = "Mật khẩu không đáp ứng yêu cầu bảo mật"
 )
 
 var (
@@ -79,6 +112,9 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.userService.Create(r.Context(), input)
 	if err != nil {
+		if passwordPolicyErr(w, err, "password") {
+			return
+		}
 		h.log.Error("[Handler][CreateUser] failed to create user", zap.Error(err))
 		util.ResponseErr(w, util.ResponseError{
 			Status:    INTERNAL_SERVER_ERROR,
@@ -136,6 +172,7 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 // @Param update_user body models.UpdateUserInput true "Update user request"
 // @Success      200  {object}  util.Response
 // @Failure      400  {object}  util.Response
+// @Failure      403  {object}  util.Response
 // @Failure      500  {object}  util.Response
 // @Router       /users/{id} [put]
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -151,8 +188,39 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the account status is not the user's to change, an unverified account would verify itself
+	if input.Status != "" {
+		allowed, err := h.authMiddleware.HasPermission(r, models.PermUsersStatus)
+		if err != nil {
+			h.log.Error("[Handler][UpdateUser] failed to check permissions", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    INTERNAL_SERVER_ERROR,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInternalServerError,
+			}, http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   "missing permission " + models.PermUsersStatus,
+			}, http.StatusForbidden)
+			return
+		}
+	}
+
 	user, err := h.userService.Update(r.Context(), id, input)
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidStatus) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInvalidRequest,
+				Errors:    []util.ErrReason{{Field: "status", Message: MessageInvalidStatus}},
+			}, http.StatusBadRequest)
+			return
+		}
 		if errors.Is(err, service.ErrUserNotFound) {
 			h.log.Error("[Handler][UpdateUser] user not found", zap.Error(err))
 			util.ResponseErr(w, util.ResponseError{
@@ -301,6 +369,9 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.userService.ChangePassword(r.Context(), id, input); err != nil {
+		if passwordPolicyErr(w, err, "newPassword") {
+			return
+		}
 		if errors.Is(err, service.ErrUserNotFound) {
 			h.log.Info("[Handler][ChangePassword] user not found", zap.Error(err))
 			util.ResponseErr(w, util.ResponseError{
@@ -325,3 +396,27 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	}, http.StatusOK)
 
 }
+
+// passwordPolicyErr answers 400 with one ErrReason per broken rule when err is a *password.PolicyError
+func passwordPolicyErr(w http.ResponseWriter, err error, field string) bool {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	reasons := make([]util.ErrReason, 0, len(policyErr.Violations))
+	for _, v := range policyErr.Violations {
+		reasons = append(reasons, util.ErrReason{
+			Field:   field,
+			Message: v.Message,
+		})
+	}
+
+	util.ResponseErr(w, util.ResponseError{
+		Status:    BAD_REQUEST,
+		TimeStamp: time.Now().String(),
+		Message:   ErrWeakPassword,
+		Errors:    reasons,
+	}, http.StatusBadRequest)
+	return true
+}
@@ -0,0 +1,271 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// ImpersonationHandler lets support staff act as a user and admins review what was done meanwhile
+type ImpersonationHandler struct {
+	impersonationService service.ImpersonationService
+	authMiddleware       *middleware.AuthMiddleware
+	log                  *zap.Logger
+}
+
+func NewImpersonationHandler(impersonationService service.ImpersonationService, authMiddleware *middleware.AuthMiddleware,
+	log *zap.Logger) *ImpersonationHandler {
+	return &ImpersonationHandler{impersonationService: impersonationService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *ImpersonationHandler) RegisterRoutes(r *mux.Router) {
+	// only from an interactive login, an impersonation token cannot start another impersonation
+	start := r.PathPrefix("/admin/impersonate").Subrouter()
+	start.Use(h.authMiddleware.AuthMiddleware())
+	start.Use(h.authMiddleware.RequireScope())
+	start.Use(h.authMiddleware.RequirePermission(models.PermUsersImpersonate))
+	start.HandleFunc("/{id}", h.Impersonate).Methods(http.MethodPost)
+
+	// called with the impersonation token itself
+	r.Handle("/impersonation/end", h.authMiddleware.AuthMiddleware()(http.HandlerFunc(h.EndOwnImpersonation))).Methods(http.MethodPost)
+
+	sessions := r.PathPrefix("/admin/impersonations").Subrouter()
+	sessions.Use(h.authMiddleware.AuthMiddleware())
+	sessions.Use(h.authMiddleware.ACLMiddleware("admin"))
+	sessions.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	sessions.HandleFunc("", h.ListImpersonations).Methods(http.MethodGet)
+	sessions.HandleFunc("/{id}", h.GetImpersonation).Methods(http.MethodGet)
+	sessions.HandleFunc("/{id}", h.EndImpersonation).Methods(http.MethodDelete)
+}
+
+var (
+	MessageEndImpersonationSuccess = "Đã kết thúc phiên đóng vai"
+	MessageImpersonateSelf         = "Không thể đóng vai chính mình"
+	MessageImpersonateAdmin        = "Không thể đóng vai quản trị viên"
+	MessageImpersonateInactive     = "Tài khoản không hoạt động"
+	MessageNotImpersonating        = "Token không phải của một phiên đóng vai"
+	MessageImpersonationNotFound   = "Không tìm thấy phiên đóng vai"
+)
+
+// Impersonate godoc
+// @Summary Impersonate user
+// @Description Issue a short-lived access token for the user carrying the caller in its act claim (RFC 8693). Changing the account, its password, MFA, sessions and tokens is blocked with it and every request is recorded. Requires the users:impersonate permission, admins cannot be impersonated.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param impersonate body models.ImpersonateInput true "Reason"
+// @Success      201  {object}  models.ImpersonateResponse
+// @Failure      400  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/impersonate/{id} [post]
+func (h *ImpersonationHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	var input models.ImpersonateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "reason",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	actorID, _ := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+	res, err := h.impersonationService.Start(r.Context(), actorID, mux.Vars(r)["id"], input)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   ErrNotFound,
+			}, http.StatusNotFound)
+		case errors.Is(err, service.ErrImpersonateSelf):
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   MessageImpersonateSelf,
+			}, http.StatusBadRequest)
+		case errors.Is(err, service.ErrImpersonateAdmin):
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   MessageImpersonateAdmin,
+			}, http.StatusForbidden)
+		case errors.Is(err, service.ErrAccountInactive):
+			util.ResponseErr(w, util.ResponseError{
+				Status:    BAD_REQUEST,
+				TimeStamp: time.Now().String(),
+				Message:   MessageImpersonateInactive,
+			}, http.StatusBadRequest)
+		default:
+			h.log.Error("[Handler][Impersonate] failed to start impersonation", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    INTERNAL_SERVER_ERROR,
+				TimeStamp: time.Now().String(),
+				Message:   ErrInternalServerError,
+			}, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	util.ResponseOK(w, res, http.StatusCreated)
+}
+
+// EndOwnImpersonation godoc
+// @Summary End impersonation
+// @Description End the impersonation session of the token the request is made with, the token stops working
+// @Tags auth
+// @Produce json
+// @Security JWT
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /impersonation/end [post]
+func (h *ImpersonationHandler) EndOwnImpersonation(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+	if _, ok := claims["act"]; !ok {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   MessageNotImpersonating,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	sessionID, _ := claims["jti"].(string)
+	h.end(w, r, sessionID)
+}
+
+// EndImpersonation godoc
+// @Summary End impersonation session
+// @Description End an impersonation session of any admin, its token stops working
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "Session ID"
+// @Success      200  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/impersonations/{id} [delete]
+func (h *ImpersonationHandler) EndImpersonation(w http.ResponseWriter, r *http.Request) {
+	h.end(w, r, mux.Vars(r)["id"])
+}
+
+func (h *ImpersonationHandler) end(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if err := h.impersonationService.End(r.Context(), sessionID); err != nil {
+		if errors.Is(err, service.ErrImpersonationNotFound) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   MessageImpersonationNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][EndImpersonation] failed to end impersonation", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageEndImpersonationSuccess,
+	}, http.StatusOK)
+}
+
+// ListImpersonations godoc
+// @Summary List impersonation sessions
+// @Description List impersonation sessions, most recent first
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param actorId query string false "Admin who impersonated"
+// @Param subjectId query string false "Impersonated user"
+// @Param limit query int false "At most 200, default 50"
+// @Success      200  {array}   models.ImpersonationSession
+// @Failure      500  {object}  util.Response
+// @Router       /admin/impersonations [get]
+func (h *ImpersonationHandler) ListImpersonations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.ImpersonationFilter{ActorID: query.Get("actorId"), SubjectID: query.Get("subjectId")}
+	if limit, err := util.ParseToInt(query.Get("limit")); err == nil {
+		filter.Limit = limit
+	}
+
+	sessions, err := h.impersonationService.List(r.Context(), filter)
+	if err != nil {
+		h.log.Error("[Handler][ListImpersonations] failed to list sessions", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, sessions, http.StatusOK)
+}
+
+// GetImpersonation godoc
+// @Summary Get impersonation session
+// @Description Get an impersonation session with every request made during it
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "Session ID"
+// @Success      200  {object}  models.ImpersonationSession
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/impersonations/{id} [get]
+func (h *ImpersonationHandler) GetImpersonation(w http.ResponseWriter, r *http.Request) {
+	session, err := h.impersonationService.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, service.ErrImpersonationNotFound) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   MessageImpersonationNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][GetImpersonation] failed to get session", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, session, http.StatusOK)
+}
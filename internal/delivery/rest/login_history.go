@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+type LoginHistoryHandler struct {
+	loginHistory   service.LoginHistoryService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewLoginHistoryHandler(loginHistory service.LoginHistoryService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *LoginHistoryHandler {
+	return &LoginHistoryHandler{loginHistory: loginHistory, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *LoginHistoryHandler) RegisterRoutes(r *mux.Router) {
+	users := r.PathPrefix("/users/{id}/login-history").Subrouter()
+	users.Use(h.authMiddleware.AuthMiddleware())
+	users.Use(h.authMiddleware.SelfOrAdminMiddleware())
+	users.Use(h.authMiddleware.RequireScope())
+	users.HandleFunc("", h.ListLoginHistory).Methods(http.MethodGet)
+}
+
+// ListLoginHistory godoc
+// @Summary Login history
+// @Description List the login attempts on a user's account, successful and failed, most recent first
+// @Tags sessions
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param page query int false "Page number (starts from 0)"
+// @Param pageSize query int false "Page size"
+// @Success      200  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/login-history [get]
+func (h *LoginHistoryHandler) ListLoginHistory(w http.ResponseWriter, r *http.Request) {
+	params := util.GetPaginationParams(r)
+
+	events, total, err := h.loginHistory.List(r.Context(), mux.Vars(r)["id"], params)
+	if err != nil {
+		h.log.Error("[Handler][ListLoginHistory] failed to list logins", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.CreatePaginationResponse(events, total, params), http.StatusOK)
+}
@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+type MagicLinkHandler struct {
+	magicLinkService service.MagicLinkService
+	mfaService       service.MFAService
+	authService      models.AuthService
+	log              *zap.Logger
+}
+
+func NewMagicLinkHandler(magicLinkService service.MagicLinkService, mfaService service.MFAService, authService models.AuthService, log *zap.Logger) *MagicLinkHandler {
+	return &MagicLinkHandler{magicLinkService: magicLinkService, mfaService: mfaService, authService: authService, log: log}
+}
+
+func (h *MagicLinkHandler) RegisterRoutes(r *mux.Router) {
+	r = r.PathPrefix("/auth/magic-link").Subrouter()
+	r.HandleFunc("", h.SendLink).Methods(http.MethodPost)
+	r.HandleFunc("/consume", h.Consume).Methods(http.MethodPost)
+}
+
+var (
+	MessageMagicLinkSent    = "Nếu email tồn tại, liên kết đăng nhập đã được gửi"
+	MessageInvalidMagicLink = "Liên kết đăng nhập không hợp lệ hoặc đã hết hạn"
+	MessageAccountInactive  = "Tài khoản đã bị vô hiệu hóa"
+)
+
+// SendLink godoc
+// @Summary Request magic link
+// @Description Mail a single-use login link to the user
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param magic_link body models.MagicLinkRequest true "Magic link request"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/magic-link [post]
+func (h *MagicLinkHandler) SendLink(w http.ResponseWriter, r *http.Request) {
+	var req models.MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Error("[Handler][MagicLink] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.log.Error("[Handler][MagicLink] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "email",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.magicLinkService.SendLink(r.Context(), req.Email); err != nil {
+		h.log.Error("[Handler][MagicLink] failed to send magic link", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageMagicLinkSent,
+	}, http.StatusOK)
+}
+
+// Consume godoc
+// @Summary Login with magic link
+// @Description Exchange a magic link token for tokens, or for an MFA challenge when the account has MFA enabled
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param consume body models.MagicLinkConsumeRequest true "Magic link token"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      400  {object}  util.Response
+// @Failure      401  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/magic-link/consume [post]
+func (h *MagicLinkHandler) Consume(w http.ResponseWriter, r *http.Request) {
+	var req models.MagicLinkConsumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Error("[Handler][MagicLinkConsume] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.log.Error("[Handler][MagicLinkConsume] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "token",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.magicLinkService.Consume(r.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMagicLink) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    UNAUTHORIZED,
+				TimeStamp: time.Now().String(),
+				Message:   MessageInvalidMagicLink,
+			}, http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, service.ErrAccountInactive) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   MessageAccountInactive,
+			}, http.StatusForbidden)
+			return
+		}
+		h.log.Error("[Handler][MagicLinkConsume] failed to consume magic link", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	completeLogin(w, r, h.log, h.mfaService, h.authService, user, models.LoginMethodMagicLink, "[Handler][MagicLinkConsume]")
+}
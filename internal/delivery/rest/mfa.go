@@ -0,0 +1,314 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+type MFAHandler struct {
+	mfaService     service.MFAService
+	authService    models.AuthService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewMFAHandler(mfaService service.MFAService, authService models.AuthService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *MFAHandler {
+	return &MFAHandler{mfaService: mfaService, authService: authService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *MFAHandler) RegisterRoutes(r *mux.Router) {
+	r = r.PathPrefix("/auth/mfa").Subrouter()
+	r.HandleFunc("/verify", h.Verify).Methods(http.MethodPost)
+
+	interactive := h.authMiddleware.RequireScope()
+	r.Handle("/enroll", h.authMiddleware.AuthMiddleware()(interactive(http.HandlerFunc(h.Enroll)))).Methods(http.MethodPost)
+	r.Handle("/confirm", h.authMiddleware.AuthMiddleware()(interactive(http.HandlerFunc(h.Confirm)))).Methods(http.MethodPost)
+	r.Handle("/disable", h.authMiddleware.AuthMiddleware()(interactive(http.HandlerFunc(h.Disable)))).Methods(http.MethodPost)
+	r.Handle("/recovery-codes", h.authMiddleware.AuthMiddleware()(interactive(http.HandlerFunc(h.RegenerateRecoveryCodes)))).Methods(http.MethodPost)
+}
+
+var (
+	MessageMFADisabled       = "Đã tắt xác thực hai bước"
+	MessageInvalidMFACode    = "Mã xác thực không hợp lệ"
+	MessageMFAAlreadyEnabled = "Xác thực hai bước đã được bật"
+	MessageMFANotEnabled     = "Xác thực hai bước chưa được bật"
+	MessageMFANotEnrolled    = "Chưa bắt đầu đăng ký xác thực hai bước"
+	MessageInvalidMFAToken   = "Phiên xác thực hai bước không hợp lệ hoặc đã hết hạn"
+)
+
+const (
+	MFA_INVALID_CODE = "MFA_INVALID_CODE"
+)
+
+// Enroll godoc
+// @Summary Start MFA enrollment
+// @Description Generate a TOTP secret and otpauth:// URI for the current user
+// @Tags mfa
+// @Produce json
+// @Security JWT
+// @Success      200  {object}  models.MFAEnrollResponse
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/mfa/enroll [post]
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+
+	res, err := h.mfaService.Enroll(r.Context(), userID)
+	if err != nil {
+		h.handleError(w, "[Handler][MFA][Enroll]", err)
+		return
+	}
+
+	util.ResponseOK(w, res, http.StatusOK)
+}
+
+// Confirm godoc
+// @Summary Confirm MFA enrollment
+// @Description Enable MFA with a first code from the authenticator app and return recovery codes
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param confirm body models.MFACodeRequest true "TOTP code"
+// @Success      200  {object}  models.MFARecoveryCodesResponse
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/mfa/confirm [post]
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+
+	req, ok := h.decodeCodeRequest(w, r, "[Handler][MFA][Confirm]")
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfaService.Confirm(r.Context(), userID, req.Code)
+	if err != nil {
+		h.handleError(w, "[Handler][MFA][Confirm]", err)
+		return
+	}
+
+	util.ResponseOK(w, models.MFARecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// Disable godoc
+// @Summary Disable MFA
+// @Description Disable MFA with a TOTP code or a recovery code
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param disable body models.MFACodeRequest true "TOTP or recovery code"
+// @Success      200  {object}  util.Response
+// @Failure      400  {object}  util.Response
+// @Failure      429  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/mfa/disable [post]
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+
+	req, ok := h.decodeCodeRequest(w, r, "[Handler][MFA][Disable]")
+	if !ok {
+		return
+	}
+
+	if err := h.mfaService.Disable(r.Context(), userID, req.Code, req.RecoveryCode); err != nil {
+		h.handleError(w, "[Handler][MFA][Disable]", err)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageMFADisabled,
+	}, http.StatusOK)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes, previous codes stop working
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param regenerate body models.MFACodeRequest true "TOTP code"
+// @Success      200  {object}  models.MFARecoveryCodesResponse
+// @Failure      400  {object}  util.Response
+// @Failure      429  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+
+	req, ok := h.decodeCodeRequest(w, r, "[Handler][MFA][RegenerateRecoveryCodes]")
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		h.handleError(w, "[Handler][MFA][RegenerateRecoveryCodes]", err)
+		return
+	}
+
+	util.ResponseOK(w, models.MFARecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// Verify godoc
+// @Summary Complete MFA login
+// @Description Exchange the MFA challenge token from /auth/login and a TOTP or recovery code for tokens
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param verify body models.MFAVerifyRequest true "MFA verify request"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      400  {object}  util.Response
+// @Failure      401  {object}  util.Response
+// @Failure      429  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/mfa/verify [post]
+func (h *MFAHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Error("[Handler][MFA][Verify] failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.log.Error("[Handler][MFA][Verify] invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "code",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.mfaService.VerifyChallenge(r.Context(), req.MFAToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMFAChallenge) || errors.Is(err, service.ErrMFANotEnabled) {
+			h.log.Error("[Handler][MFA][Verify] invalid challenge", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    UNAUTHORIZED,
+				TimeStamp: time.Now().String(),
+				Message:   MessageInvalidMFAToken,
+			}, http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			h.log.Info("[Handler][MFA][Verify] invalid code", zap.Error(err))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    MFA_INVALID_CODE,
+				TimeStamp: time.Now().String(),
+				Message:   MessageInvalidMFACode,
+			}, http.StatusUnauthorized)
+			return
+		}
+		var lockoutErr *service.LockoutError
+		if errors.As(err, &lockoutErr) || errors.Is(err, service.ErrorCheckingLockout) {
+			respondLockout(w, h.log, "[Handler][MFA][Verify]", err)
+			return
+		}
+		h.log.Error("[Handler][MFA][Verify] failed to verify challenge", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	loginResponse, err := h.authService.IssueTokens(r.Context(), user, models.LoginMethodMFA)
+	if err != nil {
+		h.log.Error("[Handler][MFA][Verify] failed to issue tokens", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, loginResponse, http.StatusOK)
+}
+
+func (h *MFAHandler) decodeCodeRequest(w http.ResponseWriter, r *http.Request, scope string) (*models.MFACodeRequest, bool) {
+	var req models.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Error(scope+" failed to parse request", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return nil, false
+	}
+
+	if err := req.Validate(); err != nil {
+		h.log.Error(scope+" invalid request body", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "code",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func (h *MFAHandler) handleError(w http.ResponseWriter, scope string, err error) {
+	var message, status string
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode):
+		message, status = MessageInvalidMFACode, MFA_INVALID_CODE
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		message, status = MessageMFAAlreadyEnabled, BAD_REQUEST
+	case errors.Is(err, service.ErrMFANotEnabled):
+		message, status = MessageMFANotEnabled, BAD_REQUEST
+	case errors.Is(err, service.ErrMFANotEnrolled):
+		message, status = MessageMFANotEnrolled, BAD_REQUEST
+	case errors.As(err, new(*service.LockoutError)) || errors.Is(err, service.ErrorCheckingLockout):
+		respondLockout(w, h.log, scope, err)
+		return
+	default:
+		h.log.Error(scope+" failed", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	h.log.Info(scope+" rejected", zap.Error(err))
+	util.ResponseErr(w, util.ResponseError{
+		Status:    status,
+		TimeStamp: time.Now().String(),
+		Message:   message,
+	}, http.StatusBadRequest)
+}
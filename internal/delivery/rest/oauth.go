@@ -0,0 +1,558 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// OAuthHandler serves the OAuth2 / OpenID Connect endpoints. The protocol endpoints answer with the error
+// format of RFC 6749 instead of util.ResponseError so standard client libraries understand them.
+type OAuthHandler struct {
+	oauthService         service.OAuthService
+	introspectionService service.IntrospectionService
+	authMiddleware       *middleware.AuthMiddleware
+	log                  *zap.Logger
+	loginURL             string
+}
+
+// NewOAuthHandler creates the OAuth handler, loginURL is the frontend page that signs the user in and
+// completes the authorization request with POST /oauth/authorize
+func NewOAuthHandler(oauthService service.OAuthService, introspectionService service.IntrospectionService,
+	authMiddleware *middleware.AuthMiddleware, log *zap.Logger, loginURL string) *OAuthHandler {
+	return &OAuthHandler{oauthService: oauthService, introspectionService: introspectionService, authMiddleware: authMiddleware,
+		log: log, loginURL: loginURL}
+}
+
+func (h *OAuthHandler) RegisterRoutes(r *mux.Router) {
+	oauth := r.PathPrefix("/oauth").Subrouter()
+	oauth.HandleFunc("/authorize", h.StartAuthorization).Methods(http.MethodGet)
+	oauth.Handle("/authorize", h.authMiddleware.AuthMiddleware()(h.authMiddleware.RequireScope()(http.HandlerFunc(h.Authorize)))).Methods(http.MethodPost)
+	oauth.HandleFunc("/token", h.Token).Methods(http.MethodPost)
+	oauth.HandleFunc("/introspect", h.Introspect).Methods(http.MethodPost)
+	oauth.HandleFunc("/revoke", h.Revoke).Methods(http.MethodPost)
+
+	r.Handle("/userinfo", h.authMiddleware.AuthMiddleware()(http.HandlerFunc(h.UserInfo))).Methods(http.MethodGet, http.MethodPost)
+
+	admin := r.PathPrefix("/admin/oauth/clients").Subrouter()
+	admin.Use(h.authMiddleware.AuthMiddleware())
+	admin.Use(h.authMiddleware.ACLMiddleware("admin"))
+	admin.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	admin.HandleFunc("", h.CreateClient).Methods(http.MethodPost)
+	admin.HandleFunc("", h.ListClients).Methods(http.MethodGet)
+	admin.HandleFunc("/{id}", h.DeleteClient).Methods(http.MethodDelete)
+
+	accounts := r.PathPrefix("/admin/service-accounts").Subrouter()
+	accounts.Use(h.authMiddleware.AuthMiddleware())
+	accounts.Use(h.authMiddleware.ACLMiddleware("admin"))
+	accounts.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	accounts.HandleFunc("", h.CreateServiceAccount).Methods(http.MethodPost)
+	accounts.HandleFunc("", h.ListServiceAccounts).Methods(http.MethodGet)
+	accounts.HandleFunc("/{id}", h.DeleteClient).Methods(http.MethodDelete)
+}
+
+var (
+	MessageDeleteClientSuccess = "Xóa ứng dụng OAuth thành công"
+)
+
+// StartAuthorization godoc
+// @Summary OAuth2 authorization endpoint
+// @Description Validate an authorization request and send the browser to the login page, which completes it with POST /oauth/authorize
+// @Tags oauth
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param response_type query string true "code"
+// @Param scope query string false "Space separated scopes"
+// @Param state query string false "State"
+// @Param nonce query string false "OpenID Connect nonce"
+// @Param code_challenge query string false "PKCE challenge, required for public clients"
+// @Param code_challenge_method query string false "S256"
+// @Success      302
+// @Failure      400  {object}  service.OAuthError
+// @Router       /oauth/authorize [get]
+func (h *OAuthHandler) StartAuthorization(w http.ResponseWriter, r *http.Request) {
+	req := authorizeRequestFromQuery(r.URL.Query())
+
+	if _, err := h.oauthService.ValidateAuthorization(r.Context(), req); err != nil {
+		h.oauthErr(w, err)
+		return
+	}
+
+	// the login page gets the original request and posts it back once the user is signed in
+	target, err := url.Parse(h.loginURL)
+	if err != nil {
+		h.log.Error("[Handler][OAuthAuthorize] invalid login url", zap.Error(err))
+		h.oauthErr(w, err)
+		return
+	}
+	target.RawQuery = r.URL.RawQuery
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Authorize godoc
+// @Summary Approve authorization request
+// @Description Issue an authorization code for the signed in user, the response tells the frontend where to redirect the browser
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param request body models.AuthorizeRequest true "Authorization request parameters"
+// @Success      200  {object}  models.AuthorizeResponse
+// @Failure      400  {object}  service.OAuthError
+// @Failure      403  {object}  util.Response
+// @Router       /oauth/authorize [post]
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+
+	// tokens held by OAuth clients must not authorize other clients on behalf of the user
+	if _, ok := claims["client_id"]; ok {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "FORBIDDEN",
+			TimeStamp: time.Now().String(),
+			Message:   "forbidden",
+		}, http.StatusForbidden)
+		return
+	}
+
+	var req models.AuthorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.oauthErr(w, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "invalid request body"})
+		return
+	}
+
+	// the access token was issued at login, which is the authentication time of the user
+	authTime := time.Now()
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		authTime = iat.Time
+	}
+
+	redirectTo, err := h.oauthService.Authorize(r.Context(), claims["userID"].(string), authTime, req)
+	if err != nil {
+		h.oauthErr(w, err)
+		return
+	}
+
+	util.ResponseOK(w, models.AuthorizeResponse{RedirectTo: redirectTo}, http.StatusOK)
+}
+
+// Token godoc
+// @Summary OAuth2 token endpoint
+// @Description Exchange an authorization code, a refresh token or client credentials for tokens. Confidential clients authenticate with HTTP basic authentication or client_secret in the form.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI of the authorization request"
+// @Param code_verifier formData string false "PKCE verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Space separated scopes"
+// @Param client_id formData string false "Client ID"
+// @Param client_secret formData string false "Client secret"
+// @Success      200  {object}  models.TokenResponse
+// @Failure      400  {object}  service.OAuthError
+// @Failure      401  {object}  service.OAuthError
+// @Router       /oauth/token [post]
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		h.oauthErr(w, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "invalid form body"})
+		return
+	}
+
+	req := models.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		Scope:        r.PostForm.Get("scope"),
+	}
+	var basicAuth bool
+	req.ClientID, req.ClientSecret, basicAuth = clientCredentials(r)
+
+	res, err := h.oauthService.Token(r.Context(), req)
+	if err != nil {
+		h.clientErr(w, err, basicAuth)
+		return
+	}
+
+	util.ResponseOK(w, res, http.StatusOK)
+}
+
+// Introspect godoc
+// @Summary Introspect token
+// @Description RFC 7662 token introspection for access tokens, personal access tokens and refresh tokens. The caller authenticates with the credentials of a service account, through HTTP basic authentication or client_id and client_secret in the form.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success      200  {object}  models.IntrospectionResponse
+// @Failure      400  {object}  service.OAuthError
+// @Failure      401  {object}  service.OAuthError
+// @Router       /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, hint, basicAuth, ok := h.tokenRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := h.introspectionService.Introspect(r.Context(), token, hint)
+	if err != nil {
+		h.log.Error("[Handler][Introspect] failed to introspect token", zap.Error(err))
+		h.clientErr(w, err, basicAuth)
+		return
+	}
+
+	util.ResponseOK(w, res, http.StatusOK)
+}
+
+// Revoke godoc
+// @Summary Revoke token
+// @Description RFC 7009 token revocation. Access tokens are rejected until they expire, personal access tokens are revoked and refresh tokens sign their session out. Unknown tokens are accepted as well. The caller authenticates like for introspection.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success      200
+// @Failure      400  {object}  service.OAuthError
+// @Failure      401  {object}  service.OAuthError
+// @Router       /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	token, hint, basicAuth, ok := h.tokenRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.introspectionService.Revoke(r.Context(), token, hint); err != nil {
+		h.log.Error("[Handler][Revoke] failed to revoke token", zap.Error(err))
+		h.clientErr(w, err, basicAuth)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// tokenRequest authenticates the service account calling introspection or revocation and returns the token
+// and hint of the form. It writes the error response itself.
+func (h *OAuthHandler) tokenRequest(w http.ResponseWriter, r *http.Request) (token, hint string, basicAuth, ok bool) {
+	if err := r.ParseForm(); err != nil {
+		h.oauthErr(w, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "invalid form body"})
+		return "", "", false, false
+	}
+
+	clientID, secret, basicAuth := clientCredentials(r)
+	if _, err := h.oauthService.AuthenticateService(r.Context(), clientID, secret); err != nil {
+		h.clientErr(w, err, basicAuth)
+		return "", "", basicAuth, false
+	}
+
+	token = r.PostForm.Get("token")
+	if token == "" {
+		h.oauthErr(w, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "token is required"})
+		return "", "", basicAuth, false
+	}
+
+	return token, r.PostForm.Get("token_type_hint"), basicAuth, true
+}
+
+// clientCredentials reads the client credentials of the request, RFC 6749 section 2.3.1: basic authentication
+// takes precedence over the form and its credentials are form encoded
+func clientCredentials(r *http.Request) (clientID, secret string, basicAuth bool) {
+	id, pass, basicAuth := r.BasicAuth()
+	if basicAuth {
+		clientID, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(pass)
+		return clientID, secret, true
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
+
+// clientErr writes an OAuth error, a client failing basic authentication is challenged to retry
+func (h *OAuthHandler) clientErr(w http.ResponseWriter, err error, basicAuth bool) {
+	var oauthErr *service.OAuthError
+	if basicAuth && errors.As(err, &oauthErr) && oauthErr.Code == service.OAuthInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	h.oauthErr(w, err)
+}
+
+// UserInfo godoc
+// @Summary OpenID Connect userinfo
+// @Description Claims of the user the access token was issued to, the token needs the openid scope
+// @Tags oauth
+// @Produce json
+// @Security JWT
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  util.Response
+// @Failure      403  {object}  service.OAuthError
+// @Router       /userinfo [get]
+func (h *OAuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(jwt.MapClaims)
+	scope, _ := claims["scope"].(string)
+	userID, _ := claims["userID"].(string)
+
+	// service accounts have no user to describe
+	if userID == "" || !models.HasScope(scope, models.ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		util.ResponseOK(w, service.OAuthError{Code: "insufficient_scope", Description: "the openid scope is required"}, http.StatusForbidden)
+		return
+	}
+
+	info, err := h.oauthService.UserInfo(r.Context(), userID, scope)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			util.ResponseOK(w, service.OAuthError{Code: "invalid_token", Description: "user no longer exists"}, http.StatusUnauthorized)
+			return
+		}
+		h.log.Error("[Handler][UserInfo] failed to get user info", zap.Error(err))
+		h.oauthErr(w, err)
+		return
+	}
+
+	util.ResponseOK(w, info, http.StatusOK)
+}
+
+// CreateClient godoc
+// @Summary Register OAuth client
+// @Description Register an application with the OAuth2 provider. The client secret is only returned once.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param client body models.CreateOAuthClientInput true "Client"
+// @Success      201  {object}  models.CreateOAuthClientResponse
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/oauth/clients [post]
+func (h *OAuthHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var input models.CreateOAuthClientInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "client",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	client, secret, err := h.oauthService.CreateClient(r.Context(), input)
+	if err != nil {
+		h.log.Error("[Handler][CreateClient] failed to create client", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, models.CreateOAuthClientResponse{Client: client, ClientSecret: secret}, http.StatusCreated)
+}
+
+// ListClients godoc
+// @Summary List OAuth clients
+// @Description List the applications registered with the OAuth2 provider
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {array}   models.OAuthClient
+// @Failure      500  {object}  util.Response
+// @Router       /admin/oauth/clients [get]
+func (h *OAuthHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.oauthService.ListClients(r.Context())
+	if err != nil {
+		h.log.Error("[Handler][ListClients] failed to list clients", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, clients, http.StatusOK)
+}
+
+// DeleteClient godoc
+// @Summary Delete OAuth client
+// @Description Delete a client together with its authorization codes and refresh tokens. Access tokens of a service account are rejected right away.
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "Client ID"
+// @Success      200  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/oauth/clients/{id} [delete]
+// @Router       /admin/service-accounts/{id} [delete]
+func (h *OAuthHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	if err := h.oauthService.DeleteClient(r.Context(), mux.Vars(r)["id"]); err != nil {
+		if errors.Is(err, service.ErrClientNotFound) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   ErrNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][DeleteClient] failed to delete client", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageDeleteClientSuccess,
+	}, http.StatusOK)
+}
+
+// CreateServiceAccount godoc
+// @Summary Create service account
+// @Description Create a service account for an internal service. It gets tokens with the client credentials grant at /oauth/token, which carry sub_type=service and are authorized on their scopes. The client secret is only returned once.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param account body models.CreateServiceAccountInput true "Service account"
+// @Success      201  {object}  models.CreateOAuthClientResponse
+// @Failure      400  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/service-accounts [post]
+func (h *OAuthHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var input models.CreateServiceAccountInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "serviceAccount",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	client, secret, err := h.oauthService.CreateClient(r.Context(), models.CreateOAuthClientInput{
+		Name:       input.Name,
+		GrantTypes: []string{models.GrantClientCredentials},
+		Scopes:     input.Scopes,
+	})
+	if err != nil {
+		h.log.Error("[Handler][CreateServiceAccount] failed to create service account", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, models.CreateOAuthClientResponse{Client: client, ClientSecret: secret}, http.StatusCreated)
+}
+
+// ListServiceAccounts godoc
+// @Summary List service accounts
+// @Description List the OAuth clients that are service accounts, i.e. only use the client credentials grant
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {array}   models.OAuthClient
+// @Failure      500  {object}  util.Response
+// @Router       /admin/service-accounts [get]
+func (h *OAuthHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.oauthService.ListClients(r.Context())
+	if err != nil {
+		h.log.Error("[Handler][ListServiceAccounts] failed to list clients", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	accounts := make([]*models.OAuthClient, 0, len(clients))
+	for _, client := range clients {
+		if client.IsServiceAccount() {
+			accounts = append(accounts, client)
+		}
+	}
+
+	util.ResponseOK(w, accounts, http.StatusOK)
+}
+
+// oauthErr writes an RFC 6749 error response, unexpected errors become server_error
+func (h *OAuthHandler) oauthErr(w http.ResponseWriter, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		util.ResponseOK(w, service.OAuthError{Code: service.OAuthServerError}, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == service.OAuthInvalidClient {
+		status = http.StatusUnauthorized
+	}
+	util.ResponseOK(w, oauthErr, status)
+}
+
+func authorizeRequestFromQuery(query url.Values) models.AuthorizeRequest {
+	return models.AuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+}
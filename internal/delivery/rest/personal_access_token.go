@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// PersonalAccessTokenHandler lets users manage the tokens their scripts authenticate with. Tokens can only
+// be managed from an interactive login, a token cannot mint more tokens.
+type PersonalAccessTokenHandler struct {
+	tokenService   service.PersonalAccessTokenService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewPersonalAccessTokenHandler(tokenService service.PersonalAccessTokenService, authMiddleware *middleware.AuthMiddleware,
+	log *zap.Logger) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{tokenService: tokenService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *PersonalAccessTokenHandler) RegisterRoutes(r *mux.Router) {
+	tokens := r.PathPrefix("/users/{id}/tokens").Subrouter()
+	tokens.Use(h.authMiddleware.AuthMiddleware())
+	tokens.Use(h.authMiddleware.SelfOrAdminMiddleware())
+	tokens.Use(h.authMiddleware.RequireScope())
+	tokens.HandleFunc("", h.ListTokens).Methods(http.MethodGet)
+	tokens.HandleFunc("", h.CreateToken).Methods(http.MethodPost)
+	tokens.HandleFunc("/{tid}", h.RevokeToken).Methods(http.MethodDelete)
+}
+
+var (
+	MessageRevokeTokenSuccess  = "Đã thu hồi token"
+	MessageTokenNotFound       = "Không tìm thấy token"
+	MessageTokenScopeForbidden = "Tài khoản không được cấp quyền này cho token"
+	MessageTokenOwnAccountOnly = "Chỉ có thể tạo token cho tài khoản của chính mình"
+)
+
+// ListTokens godoc
+// @Summary List personal access tokens
+// @Description List the active personal access tokens of a user, the tokens themselves are never returned
+// @Tags users
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Success      200  {array}   models.PersonalAccessToken
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/tokens [get]
+func (h *PersonalAccessTokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.tokenService.List(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.log.Error("[Handler][ListTokens] failed to list tokens", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, tokens, http.StatusOK)
+}
+
+// CreateToken godoc
+// @Summary Create personal access token
+// @Description Create a long-lived token for scripts, sent as "Authorization: Bearer <token>". The token is only returned once. Scopes are users:read, users:write and, for admins, admin.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param token body models.CreatePersonalAccessTokenInput true "Token"
+// @Success      201  {object}  models.CreatePersonalAccessTokenResponse
+// @Failure      400  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/tokens [post]
+func (h *PersonalAccessTokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	// admins may see and revoke the tokens of others, but not act as them
+	if r.Context().Value("user").(jwt.MapClaims)["userID"] != userID {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "FORBIDDEN",
+			TimeStamp: time.Now().String(),
+			Message:   MessageTokenOwnAccountOnly,
+		}, http.StatusForbidden)
+		return
+	}
+
+	var input models.CreatePersonalAccessTokenInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "token",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.tokenService.Create(r.Context(), userID, input)
+	if err != nil {
+		if errors.Is(err, service.ErrScopeNotAllowed) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "FORBIDDEN",
+				TimeStamp: time.Now().String(),
+				Message:   MessageTokenScopeForbidden,
+			}, http.StatusForbidden)
+			return
+		}
+		h.log.Error("[Handler][CreateToken] failed to create token", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	util.ResponseOK(w, res, http.StatusCreated)
+}
+
+// RevokeToken godoc
+// @Summary Revoke personal access token
+// @Description Revoke a personal access token, requests with it are rejected immediately
+// @Tags users
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param tid path string true "Token ID"
+// @Success      200  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/tokens/{tid} [delete]
+func (h *PersonalAccessTokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.tokenService.Revoke(r.Context(), vars["id"], vars["tid"]); err != nil {
+		if errors.Is(err, service.ErrPersonalAccessTokenNotFound) {
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   MessageTokenNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][RevokeToken] failed to revoke token", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageRevokeTokenSuccess,
+	}, http.StatusOK)
+}
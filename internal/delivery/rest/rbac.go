@@ -0,0 +1,300 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+// RBACHandler serves the administration of roles, their permissions and the roles of users under /admin,
+// restricted to the roles:manage permission
+type RBACHandler struct {
+	rbacService    service.RBACService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewRBACHandler(rbacService service.RBACService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *RBACHandler {
+	return &RBACHandler{rbacService: rbacService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *RBACHandler) RegisterRoutes(r *mux.Router) {
+	r = r.PathPrefix("/admin").Subrouter()
+	r.Use(h.authMiddleware.AuthMiddleware())
+	r.Use(h.authMiddleware.RequireScope(models.ScopeAdmin))
+	r.Use(h.authMiddleware.RequirePermission(models.PermRolesManage))
+	r.HandleFunc("/roles", h.ListRoles).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{id}", h.GetRole).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{id}", h.SaveRole).Methods(http.MethodPut)
+	r.HandleFunc("/roles/{id}", h.DeleteRole).Methods(http.MethodDelete)
+	r.HandleFunc("/permissions", h.ListPermissions).Methods(http.MethodGet)
+	r.HandleFunc("/users/{id}/roles", h.GetUserRoles).Methods(http.MethodGet)
+	r.HandleFunc("/users/{id}/roles", h.AssignRole).Methods(http.MethodPost)
+	r.HandleFunc("/users/{id}/roles/{role}", h.UnassignRole).Methods(http.MethodDelete)
+}
+
+var (
+	MessageDeleteRoleSuccess = "Xóa vai trò thành công"
+	MessageRoleNotFound      = "Không tìm thấy vai trò"
+	MessageSystemRole        = "Không thể xóa vai trò hệ thống hoặc thay đổi vai trò admin"
+	MessageUserRoleNotFound  = "User không có vai trò này"
+	MessagePrimaryRole       = "Vai trò chính của user được thay đổi khi cập nhật user"
+)
+
+// ListRoles godoc
+// @Summary List roles
+// @Description List the roles with the permissions they grant, the admin role grants every permission
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {array}   models.Role
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/roles [get]
+func (h *RBACHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.rbacService.ListRoles(r.Context())
+	if err != nil {
+		h.rbacErr(w, "ListRoles", err)
+		return
+	}
+
+	util.ResponseOK(w, roles, http.StatusOK)
+}
+
+// GetRole godoc
+// @Summary Get role
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "Role ID"
+// @Success      200  {object}  models.Role
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/roles/{id} [get]
+func (h *RBACHandler) GetRole(w http.ResponseWriter, r *http.Request) {
+	role, err := h.rbacService.GetRole(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.rbacErr(w, "GetRole", err)
+		return
+	}
+
+	util.ResponseOK(w, role, http.StatusOK)
+}
+
+// SaveRole godoc
+// @Summary Create or replace role
+// @Description Create the role or replace its name, description and permissions. The id is a lowercase slug, the admin role cannot be changed.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param id path string true "Role ID"
+// @Param role body models.SaveRoleInput true "Role"
+// @Success      200  {object}  models.Role
+// @Failure      400  {object}  util.Response
+// @Failure      409  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/roles/{id} [put]
+func (h *RBACHandler) SaveRole(w http.ResponseWriter, r *http.Request) {
+	var input models.SaveRoleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.rbacService.SaveRole(r.Context(), mux.Vars(r)["id"], input)
+	if err != nil {
+		h.rbacErr(w, "SaveRole", err)
+		return
+	}
+
+	util.ResponseOK(w, role, http.StatusOK)
+}
+
+// DeleteRole godoc
+// @Summary Delete role
+// @Description Delete a role, users holding it lose it. System roles cannot be deleted.
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "Role ID"
+// @Success      200  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      409  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/roles/{id} [delete]
+func (h *RBACHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.rbacService.DeleteRole(r.Context(), mux.Vars(r)["id"]); err != nil {
+		h.rbacErr(w, "DeleteRole", err)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageDeleteRoleSuccess,
+	}, http.StatusOK)
+}
+
+// ListPermissions godoc
+// @Summary List permissions
+// @Description List the permissions roles can grant
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Success      200  {array}   models.Permission
+// @Failure      500  {object}  util.Response
+// @Router       /admin/permissions [get]
+func (h *RBACHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := h.rbacService.ListPermissions(r.Context())
+	if err != nil {
+		h.rbacErr(w, "ListPermissions", err)
+		return
+	}
+
+	util.ResponseOK(w, permissions, http.StatusOK)
+}
+
+// GetUserRoles godoc
+// @Summary Get roles of user
+// @Description Get the primary and additional roles of a user and the permissions they grant
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Success      200  {object}  models.UserRoles
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/users/{id}/roles [get]
+func (h *RBACHandler) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.rbacService.GetUserRoles(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.rbacErr(w, "GetUserRoles", err)
+		return
+	}
+
+	util.ResponseOK(w, roles, http.StatusOK)
+}
+
+// AssignRole godoc
+// @Summary Assign role
+// @Description Grant a user an additional role
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param role body models.AssignRoleInput true "Role"
+// @Success      200  {object}  models.UserRoles
+// @Failure      400  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/users/{id}/roles [post]
+func (h *RBACHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	var input models.AssignRoleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+		}, http.StatusBadRequest)
+		return
+	}
+
+	roles, err := h.rbacService.AssignRole(r.Context(), mux.Vars(r)["id"], input)
+	if err != nil {
+		h.rbacErr(w, "AssignRole", err)
+		return
+	}
+
+	util.ResponseOK(w, roles, http.StatusOK)
+}
+
+// UnassignRole godoc
+// @Summary Unassign role
+// @Description Take an additional role from a user, the primary role is changed by updating the user
+// @Tags admin
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param role path string true "Role ID"
+// @Success      200  {object}  models.UserRoles
+// @Failure      404  {object}  util.Response
+// @Failure      409  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /admin/users/{id}/roles/{role} [delete]
+func (h *RBACHandler) UnassignRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roles, err := h.rbacService.UnassignRole(r.Context(), vars["id"], vars["role"])
+	if err != nil {
+		h.rbacErr(w, "UnassignRole", err)
+		return
+	}
+
+	util.ResponseOK(w, roles, http.StatusOK)
+}
+
+// rbacErr writes the response for an error of the RBAC service
+func (h *RBACHandler) rbacErr(w http.ResponseWriter, handler string, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidRoleID), errors.Is(err, models.ErrRoleNameEmpty),
+		errors.Is(err, models.ErrUnknownPermission), errors.Is(err, models.ErrRoleIDEmpty):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    BAD_REQUEST,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInvalidRequest,
+			Errors: []util.ErrReason{
+				{
+					Field:   "role",
+					Message: err.Error(),
+				},
+			},
+		}, http.StatusBadRequest)
+	case errors.Is(err, service.ErrRoleNotFound):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "NOT_FOUND",
+			TimeStamp: time.Now().String(),
+			Message:   MessageRoleNotFound,
+		}, http.StatusNotFound)
+	case errors.Is(err, service.ErrUserNotFound):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "NOT_FOUND",
+			TimeStamp: time.Now().String(),
+			Message:   ErrNotFound,
+		}, http.StatusNotFound)
+	case errors.Is(err, service.ErrUserRoleNotFound):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "NOT_FOUND",
+			TimeStamp: time.Now().String(),
+			Message:   MessageUserRoleNotFound,
+		}, http.StatusNotFound)
+	case errors.Is(err, service.ErrSystemRole):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "CONFLICT",
+			TimeStamp: time.Now().String(),
+			Message:   MessageSystemRole,
+		}, http.StatusConflict)
+	case errors.Is(err, service.ErrPrimaryRole):
+		util.ResponseErr(w, util.ResponseError{
+			Status:    "CONFLICT",
+			TimeStamp: time.Now().String(),
+			Message:   MessagePrimaryRole,
+		}, http.StatusConflict)
+	default:
+		h.log.Error("[Handler]["+handler+"] failed", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"user_service/api/middleware"
+	"user_service/internal/models"
+	"user_service/internal/service"
+	"user_service/internal/util"
+)
+
+type SessionHandler struct {
+	authService    models.AuthService
+	authMiddleware *middleware.AuthMiddleware
+	log            *zap.Logger
+}
+
+func NewSessionHandler(authService models.AuthService, authMiddleware *middleware.AuthMiddleware, log *zap.Logger) *SessionHandler {
+	return &SessionHandler{authService: authService, authMiddleware: authMiddleware, log: log}
+}
+
+func (h *SessionHandler) RegisterRoutes(r *mux.Router) {
+	r.Handle("/auth/logout-all", h.authMiddleware.AuthMiddleware()(h.authMiddleware.RequireScope()(http.HandlerFunc(h.LogoutAll)))).Methods(http.MethodPost)
+
+	users := r.PathPrefix("/users/{id}/sessions").Subrouter()
+	users.Use(h.authMiddleware.AuthMiddleware())
+	users.Use(h.authMiddleware.SelfOrAdminMiddleware())
+	users.Use(h.authMiddleware.RequireScope())
+	users.HandleFunc("", h.ListSessions).Methods(http.MethodGet)
+	users.HandleFunc("/{sid}", h.RevokeSession).Methods(http.MethodDelete)
+}
+
+var (
+	MessageRevokeSessionSuccess = "Đã đăng xuất phiên đăng nhập"
+	MessageLogoutAllSuccess     = "Đã đăng xuất khỏi tất cả thiết bị"
+	MessageSessionNotFound      = "Không tìm thấy phiên đăng nhập"
+)
+
+// ListSessions godoc
+// @Summary List sessions
+// @Description List the active sessions (devices) of a user
+// @Tags sessions
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Success      200  {array}   models.Session
+// @Failure      403  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/sessions [get]
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	sessions, err := h.authService.ListSessions(r.Context(), userID)
+	if err != nil {
+		h.log.Error("[Handler][ListSessions] failed to list sessions", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, sessions, http.StatusOK)
+}
+
+// RevokeSession godoc
+// @Summary Revoke session
+// @Description Sign a device out by revoking its refresh tokens. Access tokens stay valid until they expire.
+// @Tags sessions
+// @Produce json
+// @Security JWT
+// @Param id path string true "User ID"
+// @Param sid path string true "Session ID"
+// @Success      200  {object}  util.Response
+// @Failure      403  {object}  util.Response
+// @Failure      404  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /users/{id}/sessions/{sid} [delete]
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := h.authService.RevokeSession(r.Context(), vars["id"], vars["sid"])
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			h.log.Info("[Handler][RevokeSession] session not found", zap.String("sessionID", vars["sid"]))
+			util.ResponseErr(w, util.ResponseError{
+				Status:    "NOT_FOUND",
+				TimeStamp: time.Now().String(),
+				Message:   MessageSessionNotFound,
+			}, http.StatusNotFound)
+			return
+		}
+		h.log.Error("[Handler][RevokeSession] failed to revoke session", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageRevokeSessionSuccess,
+	}, http.StatusOK)
+}
+
+// LogoutAll godoc
+// @Summary Logout everywhere
+// @Description Revoke every session of the current user
+// @Tags auth
+// @Produce json
+// @Security JWT
+// @Success      200  {object}  util.Response
+// @Failure      401  {object}  util.Response
+// @Failure      500  {object}  util.Response
+// @Router       /auth/logout-all [post]
+func (h *SessionHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user").(jwt.MapClaims)["userID"].(string)
+
+	if err := h.authService.LogoutAll(r.Context(), userID); err != nil {
+		h.log.Error("[Handler][LogoutAll] failed to logout", zap.Error(err))
+		util.ResponseErr(w, util.ResponseError{
+			Status:    INTERNAL_SERVER_ERROR,
+			TimeStamp: time.Now().String(),
+			Message:   ErrInternalServerError,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseOK(w, util.ResponseSuccess{
+		Message: MessageLogoutAllSuccess,
+	}, http.StatusOK)
+}
@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"slices"
+	"strings"
+	"user_service/internal/models"
+	"user_service/internal/util"
+)
+
+type WellKnownHandler struct {
+	keys   util.KeyStore
+	issuer string
+	log    *zap.Logger
+}
+
+// NewWellKnownHandler creates the discovery handler, issuer is the public base URL of the service
+func NewWellKnownHandler(keys util.KeyStore, issuer string, log *zap.Logger) *WellKnownHandler {
+	return &WellKnownHandler{keys: keys, issuer: strings.TrimSuffix(issuer, "/"), log: log}
+}
+
+func (h *WellKnownHandler) RegisterRoutes(r *mux.Router) {
+	r = r.PathPrefix("/.well-known").Subrouter()
+	r.HandleFunc("/jwks.json", h.JWKS).Methods(http.MethodGet)
+	r.HandleFunc("/openid-configuration", h.OpenIDConfiguration).Methods(http.MethodGet)
+}
+
+// JWKS godoc
+// @Summary JSON Web Key Set
+// @Description Public keys used to verify access tokens, including retired keys still accepted after a rotation
+// @Tags well-known
+// @Produce json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /.well-known/jwks.json [get]
+func (h *WellKnownHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := h.keys.PublicKeys()
+
+	jwks := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		jwks = append(jwks, key.JWK())
+	}
+
+	// verifiers cache the set, keep it short so a rotation propagates quickly
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	util.ResponseOK(w, map[string]interface{}{
+		"keys": jwks,
+	}, http.StatusOK)
+}
+
+// OpenIDConfiguration godoc
+// @Summary OpenID Connect discovery
+// @Description Provider metadata of the OAuth2 / OpenID Connect endpoints
+// @Tags well-known
+// @Produce json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /.well-known/openid-configuration [get]
+func (h *WellKnownHandler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	algorithms := []string{}
+	for _, key := range h.keys.PublicKeys() {
+		if !key.Retired && !slices.Contains(algorithms, key.Method.Alg()) {
+			algorithms = append(algorithms, key.Method.Alg())
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	util.ResponseOK(w, map[string]interface{}{
+		"issuer":                                h.issuer,
+		"authorization_endpoint":                h.issuer + "/oauth/authorize",
+		"token_endpoint":                        h.issuer + "/oauth/token",
+		"userinfo_endpoint":                     h.issuer + "/userinfo",
+		"jwks_uri":                              h.issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algorithms,
+		"scopes_supported":                      []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail, models.ScopePhone},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "azp",
+			"name", "picture", "updated_at", "email", "email_verified", "phone_number"},
+	}, http.StatusOK)
+}
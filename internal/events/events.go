@@ -0,0 +1,61 @@
+// Package events carries security relevant events (token reuse, ...) from the services to whoever needs
+// to react to them. Publishing never fails the operation that raised the event.
+package events
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	TypeRefreshTokenReuse  = "refresh_token_reuse"
+	TypeImpersonationStart = "impersonation_start"
+	TypeImpersonationEnd   = "impersonation_end"
+	TypeSuspiciousLogin    = "suspicious_login"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+type Event struct {
+	Type     string
+	Severity string
+	UserID   string
+	Time     time.Time
+	Metadata map[string]string
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event Event)
+}
+
+type logPublisher struct {
+	log *zap.Logger
+}
+
+// NewLogPublisher writes events to the application log
+func NewLogPublisher(log *zap.Logger) Publisher {
+	return &logPublisher{log: log}
+}
+
+func (p *logPublisher) Publish(ctx context.Context, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	fields := []zap.Field{
+		zap.String("event", event.Type),
+		zap.String("severity", event.Severity),
+		zap.String("userID", event.UserID),
+		zap.Time("time", event.Time),
+	}
+	for k, v := range event.Metadata {
+		fields = append(fields, zap.String(k, v))
+	}
+
+	p.log.Warn("[Events] security event", fields...)
+}
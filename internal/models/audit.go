@@ -0,0 +1,140 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Audit actions, named <target>.<verb>
+const (
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserClaim          = "user.claim_pending"
+	AuditPasswordChange     = "user.password_change"
+	AuditPasswordSet        = "user.password_set"
+	AuditRoleAssign         = "user.role_assign"
+	AuditRoleUnassign       = "user.role_unassign"
+	AuditLogin              = "auth.login"
+	AuditLogout             = "auth.logout"
+	AuditLogoutAll          = "auth.logout_all"
+	AuditSessionRevoke      = "auth.session_revoke"
+	AuditRefreshTokenReuse  = "auth.refresh_token_reuse"
+	AuditRoleSave           = "role.save"
+	AuditRoleDelete         = "role.delete"
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationEnd   = "impersonation.end"
+)
+
+const (
+	AuditTargetUser          = "user"
+	AuditTargetRole          = "role"
+	AuditTargetSession       = "session"
+	AuditTargetImpersonation = "impersonation"
+)
+
+// Audit actor types, anonymous events have no authenticated caller, e.g. a refresh token reuse
+const (
+	AuditActorUser      = "user"
+	AuditActorService   = "service"
+	AuditActorAnonymous = "anonymous"
+)
+
+// AuditEntry is what a service records, the audit service adds the actor and client of the request
+type AuditEntry struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	// ActorID names the actor of requests that are not authenticated yet, e.g. the user logging in
+	ActorID  string
+	Before   any
+	After    any
+	Metadata map[string]string
+}
+
+// AuditEvent is a stored entry. Hash covers every other field and PrevHash, the hash of the event before.
+type AuditEvent struct {
+	ID             int64           `json:"id" db:"id"`
+	OccurredAt     time.Time       `json:"occurredAt" db:"occurred_at"`
+	ActorType      string          `json:"actorType" db:"actor_type"`
+	ActorID        string          `json:"actorId,omitempty" db:"actor_id"`
+	ImpersonatorID string          `json:"impersonatorId,omitempty" db:"impersonator_id"`
+	Action         string          `json:"action" db:"action"`
+	TargetType     string          `json:"targetType,omitempty" db:"target_type"`
+	TargetID       string          `json:"targetId,omitempty" db:"target_id"`
+	IP             string          `json:"ip,omitempty" db:"ip"`
+	UserAgent      string          `json:"userAgent,omitempty" db:"user_agent"`
+	Before         json.RawMessage `json:"before" db:"before"`
+	After          json.RawMessage `json:"after" db:"after"`
+	Metadata       json.RawMessage `json:"metadata" db:"metadata"`
+	PrevHash       string          `json:"prevHash" db:"prev_hash"`
+	Hash           string          `json:"hash" db:"hash"`
+}
+
+// ComputeHash hashes the event chained to the previous hash. JSON values are hashed in a canonical form
+// since the database does not keep the formatting and key order they were written with.
+func (e *AuditEvent) ComputeHash(prevHash string) string {
+	content, _ := json.Marshal([]any{
+		prevHash,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.ActorType,
+		e.ActorID,
+		e.ImpersonatorID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		canonicalJSON(e.Before),
+		canonicalJSON(e.After),
+		canonicalJSON(e.Metadata),
+	})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON decodes the value so it is encoded again with sorted keys and without insignificant space
+func canonicalJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	return value
+}
+
+// AuditFilter narrows the listed events, empty fields match every event
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+// AuditVerification is the result of checking the hash chain, BrokenAt is the first event that does not
+// match its hash or is not chained to the event before
+type AuditVerification struct {
+	Valid    bool  `json:"valid"`
+	Checked  int64 `json:"checked"`
+	BrokenAt int64 `json:"brokenAt,omitempty"`
+}
+
+// AuditUser is the state of a user recorded in the audit log, without the password hash
+func AuditUser(user *User) map[string]any {
+	return map[string]any{
+		"id":     user.ID,
+		"email":  user.Email,
+		"name":   user.FullName,
+		"role":   user.Role,
+		"status": user.Status,
+		"phone":  user.Phone,
+		"avatar": user.Avatar,
+	}
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testAuditEvent() AuditEvent {
+	return AuditEvent{
+		ID:         7,
+		OccurredAt: time.Date(2024, 3, 1, 10, 30, 0, 123456000, time.UTC),
+		ActorType:  AuditActorUser,
+		ActorID:    "admin-1",
+		Action:     AuditUserUpdate,
+		TargetType: AuditTargetUser,
+		TargetID:   "user-1",
+		IP:         "203.0.113.7",
+		UserAgent:  "curl/8.0",
+		Before:     json.RawMessage(`{"role":"user","status":"active"}`),
+		After:      json.RawMessage(`{"role":"admin","status":"active"}`),
+		Metadata:   json.RawMessage(`{"reason":"promotion"}`),
+	}
+}
+
+func TestAuditEventComputeHashIgnoresJSONFormatting(t *testing.T) {
+	event := testAuditEvent()
+	want := event.ComputeHash("prev")
+
+	tests := []struct {
+		name   string
+		modify func(e *AuditEvent)
+	}{
+		{"key order", func(e *AuditEvent) { e.Before = json.RawMessage(`{"status":"active","role":"user"}`) }},
+		{"whitespace", func(e *AuditEvent) { e.After = json.RawMessage("{ \"role\": \"admin\",\n \"status\": \"active\" }") }},
+		{"time zone", func(e *AuditEvent) { e.OccurredAt = e.OccurredAt.In(time.FixedZone("ICT", 7*3600)) }},
+		// the database id is assigned after hashing
+		{"id", func(e *AuditEvent) { e.ID = 8 }},
+		{"stored hashes", func(e *AuditEvent) { e.PrevHash, e.Hash = "x", "y" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := testAuditEvent()
+			tt.modify(&changed)
+			if got := changed.ComputeHash("prev"); got != want {
+				t.Errorf("hash changed to %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAuditEventComputeHashCoversEveryField(t *testing.T) {
+	event := testAuditEvent()
+	original := event.ComputeHash("prev")
+
+	tests := []struct {
+		name     string
+		prevHash string
+		modify   func(e *AuditEvent)
+	}{
+		{"previous hash", "other", func(e *AuditEvent) {}},
+		{"occurred at", "prev", func(e *AuditEvent) { e.OccurredAt = e.OccurredAt.Add(time.Microsecond) }},
+		{"actor type", "prev", func(e *AuditEvent) { e.ActorType = AuditActorService }},
+		{"actor id", "prev", func(e *AuditEvent) { e.ActorID = "admin-2" }},
+		{"impersonator id", "prev", func(e *AuditEvent) { e.ImpersonatorID = "admin-2" }},
+		{"action", "prev", func(e *AuditEvent) { e.Action = AuditUserDelete }},
+		{"target type", "prev", func(e *AuditEvent) { e.TargetType = AuditTargetRole }},
+		{"target id", "prev", func(e *AuditEvent) { e.TargetID = "user-2" }},
+		{"ip", "prev", func(e *AuditEvent) { e.IP = "203.0.113.8" }},
+		{"user agent", "prev", func(e *AuditEvent) { e.UserAgent = "curl/8.1" }},
+		{"before", "prev", func(e *AuditEvent) { e.Before = json.RawMessage(`{"role":"guest","status":"active"}`) }},
+		{"after", "prev", func(e *AuditEvent) { e.After = nil }},
+		{"metadata", "prev", func(e *AuditEvent) { e.Metadata = json.RawMessage(`{"reason":"demotion"}`) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := testAuditEvent()
+			tt.modify(&changed)
+			if changed.ComputeHash(tt.prevHash) == original {
+				t.Error("hash did not change")
+			}
+		})
+	}
+}
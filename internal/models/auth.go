@@ -12,6 +12,11 @@ type AuthService interface {
 	RefreshToken(ctx context.Context, token string) (string, string, error)
 	SaveToken(ctx context.Context, token string, userID string) error
 	LogoutAll(ctx context.Context, userID string) error
+	RevokeAccessToken(ctx context.Context, accessToken string) error
+	// IssueTokens signs in a user authenticated with method, one of the LoginMethod constants
+	IssueTokens(ctx context.Context, user *User, method string) (*LoginResponse, error)
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	RevokeSession(ctx context.Context, userID string, sessionID string) error
 }
 
 // LoginRequest represents the login credentials
@@ -66,13 +71,40 @@ func (r RegisterRequest) Validate() error {
 	return nil
 }
 
+// RefreshTokenData is a stored refresh token. Only TokenHash is persisted, Token is the raw value when known.
+// Tokens created by rotating another token share its FamilyID, a family starts at login.
 type RefreshTokenData struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	Token     string    `json:"token" db:"token"`
-	ExpiresAt time.Time `json:"expires" db:"expires_at"`
-	IssuedAt  time.Time `json:"issued" db:"issued_at"`
-	IsRevoked bool      `json:"is_revoked" db:"is_revoked"`
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	Token     string     `json:"token" db:"-"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	ExpiresAt time.Time  `json:"expires" db:"expires_at"`
+	IssuedAt  time.Time  `json:"issued" db:"issued_at"`
+	IsRevoked bool       `json:"is_revoked" db:"is_revoked"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+
+	// session metadata, CreatedAt is the login time and is carried over on rotation
+	UserAgent   string    `json:"user_agent" db:"user_agent"`
+	IPAddress   string    `json:"ip_address" db:"ip_address"`
+	DeviceLabel string    `json:"device_label" db:"device_label"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at" db:"last_used_at"`
+
+	// set for tokens issued to an OAuth client, empty for first-party logins
+	ClientID string `json:"client_id,omitempty" db:"client_id"`
+	Scope    string `json:"scope,omitempty" db:"scope"`
+}
+
+// Session is a login on one device, i.e. a refresh token family. Its ID is the family ID.
+type Session struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"deviceLabel"`
+	UserAgent   string    `json:"userAgent"`
+	IPAddress   string    `json:"ipAddress"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastUsedAt  time.Time `json:"lastUsedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
 }
 
 type RefreshRequest struct {
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IdentityProvider is an external OpenID Connect provider users can sign in with. The ID is a short
+// slug used in the login URLs, e.g. "google".
+type IdentityProvider struct {
+	ID           string         `json:"id" db:"id"`
+	Name         string         `json:"name" db:"name"`
+	Issuer       string         `json:"issuer" db:"issuer"`
+	ClientID     string         `json:"clientId" db:"client_id"`
+	ClientSecret string         `json:"-" db:"client_secret"`
+	Scopes       pq.StringArray `json:"scopes" db:"scopes"`
+	Enabled      bool           `json:"enabled" db:"enabled"`
+	CreatedAt    time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+// IdentityProviderSummary is what the login page needs to offer a provider
+type IdentityProviderSummary struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type SaveIdentityProviderInput struct {
+	Name         string   `json:"name" validate:"required"`
+	Issuer       string   `json:"issuer" validate:"required,url"`
+	ClientID     string   `json:"clientId" validate:"required"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	Enabled      bool     `json:"enabled"`
+}
+
+var providerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+var (
+	ErrInvalidProviderID = errors.New("provider id must be a lowercase slug of at most 32 characters")
+	ErrProviderNameEmpty = errors.New("name is required")
+	ErrInvalidIssuer     = errors.New("issuer must be an absolute http(s) url")
+	ErrClientIDEmpty     = errors.New("client id is required")
+)
+
+// ValidateProviderID checks the slug identifying a provider
+func ValidateProviderID(id string) error {
+	if !providerIDPattern.MatchString(id) {
+		return ErrInvalidProviderID
+	}
+	return nil
+}
+
+func (i SaveIdentityProviderInput) Validate() error {
+	if strings.TrimSpace(i.Name) == "" {
+		return ErrProviderNameEmpty
+	}
+
+	u, err := url.Parse(i.Issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ErrInvalidIssuer
+	}
+
+	if i.ClientID == "" {
+		return ErrClientIDEmpty
+	}
+
+	return nil
+}
+
+// ExternalIdentity links a user to their account at an identity provider, identified by the provider's subject
+type ExternalIdentity struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"userId" db:"user_id"`
+	ProviderID  string    `json:"providerId" db:"provider_id"`
+	Subject     string    `json:"subject" db:"subject"`
+	Email       string    `json:"email" db:"email"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	LastLoginAt time.Time `json:"lastLoginAt" db:"last_login_at"`
+}
+
+// ExternalLoginState is a pending login at an identity provider, the state parameter identifies it
+type ExternalLoginState struct {
+	StateHash    string     `db:"state_hash"`
+	ProviderID   string     `db:"provider_id"`
+	Nonce        string     `db:"nonce"`
+	CodeVerifier string     `db:"code_verifier"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+	UsedAt       *time.Time `db:"used_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// ExternalLoginRequest is posted by the frontend page the provider redirected the browser to
+type ExternalLoginRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+var (
+	ErrCodeEmpty  = errors.New("code is required")
+	ErrStateEmpty = errors.New("state is required")
+)
+
+func (r ExternalLoginRequest) Validate() error {
+	if r.Code == "" {
+		return ErrCodeEmpty
+	}
+	if r.State == "" {
+		return ErrStateEmpty
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ImpersonationSession is an admin acting as another user with a short-lived access token. The token
+// carries the admin in its act claim (RFC 8693) and its jti is the id of the session.
+type ImpersonationSession struct {
+	ID        string                `json:"id" db:"id"`
+	ActorID   string                `json:"actorId" db:"actor_id"`
+	SubjectID string                `json:"subjectId" db:"subject_id"`
+	Reason    string                `json:"reason" db:"reason"`
+	IP        string                `json:"ip" db:"ip"`
+	StartedAt time.Time             `json:"startedAt" db:"started_at"`
+	ExpiresAt time.Time             `json:"expiresAt" db:"expires_at"`
+	EndedAt   *time.Time            `json:"endedAt,omitempty" db:"ended_at"`
+	Actions   []ImpersonationAction `json:"actions,omitempty" db:"-"`
+}
+
+// Active reports whether the token of the session may still be used
+func (s *ImpersonationSession) Active(now time.Time) bool {
+	return s.EndedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ImpersonationAction is a request made with an impersonation token
+type ImpersonationAction struct {
+	ID        int64     `json:"id" db:"id"`
+	SessionID string    `json:"sessionId" db:"session_id"`
+	Method    string    `json:"method" db:"method"`
+	Path      string    `json:"path" db:"path"`
+	Status    int       `json:"status" db:"status"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type ImpersonateInput struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type ImpersonateResponse struct {
+	AccessToken string                `json:"accessToken"`
+	TokenType   string                `json:"tokenType"`
+	ExpiresIn   int64                 `json:"expiresIn"`
+	Session     *ImpersonationSession `json:"session"`
+}
+
+// ImpersonationFilter narrows the listed sessions, empty fields match every session
+type ImpersonationFilter struct {
+	ActorID   string
+	SubjectID string
+	Limit     int
+}
+
+const maxImpersonationReasonLength = 500
+
+var (
+	ErrImpersonationReasonEmpty   = errors.New("reason is required")
+	ErrImpersonationReasonTooLong = errors.New("reason must be at most 500 characters")
+)
+
+func (i ImpersonateInput) Validate() error {
+	reason := strings.TrimSpace(i.Reason)
+	if reason == "" {
+		return ErrImpersonationReasonEmpty
+	}
+	if len(reason) > maxImpersonationReasonLength {
+		return ErrImpersonationReasonTooLong
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package models
+
+// Token kinds accepted as token_type_hint by the introspection and revocation endpoints
+const (
+	TokenUseAccess  = "access_token"
+	TokenUseRefresh = "refresh_token"
+)
+
+// IntrospectionResponse is the RFC 7662 section 2.2 response. An inactive token only has active set, the
+// claims of an active one are the standard members plus userID, role and sub_type as in the access tokens.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	TokenUse  string   `json:"token_use,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	UserID    string   `json:"userID,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	SubType   string   `json:"sub_type,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// LoginFailure counts the failed logins of one key, an account email or a client IP
+type LoginFailure struct {
+	Key           string     `json:"key" db:"key"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty" db:"locked_until"`
+}
+
+// LockoutPolicy configures the brute-force protection of the login endpoint. Failures older than Window are forgotten.
+type LockoutPolicy struct {
+	MaxAccountFailures int           // failures before an account is locked
+	MaxIPFailures      int           // failures before a client IP is locked, for every account
+	LockDuration       time.Duration // how long a lock lasts
+	Window             time.Duration
+	DelayAfter         int           // failures of an account before delays start
+	BaseDelay          time.Duration // first delay, doubled with every further failure
+	MaxDelay           time.Duration
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"github.com/lib/pq"
+	"time"
+)
+
+// Login methods, the first factor the user signed in with
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMagicLink = "magic_link"
+	LoginMethodExternal  = "external"
+	LoginMethodMFA       = "mfa"
+)
+
+// Reasons a login attempt failed
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureEmailNotVerified   = "email_not_verified"
+	LoginFailureLocked             = "locked"
+	LoginFailureInactive           = "inactive"
+	LoginFailureInvalidMFACode     = "invalid_mfa_code"
+	LoginFailureStepUpRequired     = "step_up_required" // a suspicious login waiting for the user's confirmation
+)
+
+// Reasons a login is suspicious, compared with the recent successful logins of the user
+const (
+	SuspiciousNewDevice        = "new_device"
+	SuspiciousNewSubnet        = "new_subnet"
+	SuspiciousImpossibleTravel = "impossible_travel" // the user would have traveled faster than a plane since the last login
+)
+
+// Actions taken on a suspicious login
+const (
+	SuspiciousActionNotify = "notify"  // tell the user and the security event consumers
+	SuspiciousActionStepUp = "step_up" // make the user confirm the login with a second factor
+	SuspiciousActionRevoke = "revoke"  // sign the user out of every other session
+)
+
+var SuspiciousActions = []string{SuspiciousActionNotify, SuspiciousActionStepUp, SuspiciousActionRevoke}
+
+// SuspiciousLoginPolicy configures the detection of suspicious logins
+type SuspiciousLoginPolicy struct {
+	Actions        []string
+	History        int     // recent successful logins a login is compared with
+	MaxTravelSpeed float64 // km/h, faster travel between two logins is impossible
+	MinTravel      float64 // km, shorter distances are within the accuracy of the GeoIP database
+}
+
+// LoginEvent is a login attempt. UserID is empty for attempts on an email no account has.
+type LoginEvent struct {
+	ID                string         `json:"id" db:"id"`
+	UserID            string         `json:"userId,omitempty" db:"user_id"`
+	Email             string         `json:"email,omitempty" db:"email"`
+	Success           bool           `json:"success" db:"success"`
+	Method            string         `json:"method" db:"method"`
+	FailureReason     string         `json:"failureReason,omitempty" db:"failure_reason"`
+	IPAddress         string         `json:"ipAddress" db:"ip_address"`
+	UserAgent         string         `json:"userAgent" db:"user_agent"`
+	DeviceLabel       string         `json:"deviceLabel" db:"device_label"`
+	DeviceFingerprint string         `json:"-" db:"device_fingerprint"`
+	IPSubnet          string         `json:"-" db:"ip_subnet"`
+	Suspicious        pq.StringArray `json:"suspicious,omitempty" db:"suspicious_reasons"`
+	CreatedAt         time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// LoginAssessment is what was found about a login and what has to be done about it
+type LoginAssessment struct {
+	Reasons []string
+	// StepUp refuses the login until the user confirmed it, the confirmation was sent
+	StepUp bool
+	// RevokeSessions signs the user out of every other session before the login completes
+	RevokeSessions bool
+}
+
+func (a *LoginAssessment) Suspicious() bool {
+	return len(a.Reasons) > 0
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// MFAConfig holds the TOTP enrollment of a user. Enabled is only set once the user confirmed a first code.
+type MFAConfig struct {
+	UserID       string     `json:"userId" db:"user_id"`
+	Secret       string     `json:"-" db:"secret"`
+	Enabled      bool       `json:"enabled" db:"enabled"`
+	ConfirmedAt  *time.Time `json:"confirmedAt,omitempty" db:"confirmed_at"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAChallengeResponse is returned by login instead of a LoginResponse when the account has MFA enabled
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfaRequired"`
+	MFAToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfaToken" validate:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+var (
+	ErrMFACodeEmpty = errors.New("code or recoveryCode is required")
+)
+
+func (r MFACodeRequest) Validate() error {
+	if r.Code == "" && r.RecoveryCode == "" {
+		return ErrMFACodeEmpty
+	}
+
+	return nil
+}
+
+func (r MFAVerifyRequest) Validate() error {
+	if r.MFAToken == "" {
+		return ErrTokenEmpty
+	}
+
+	if r.Code == "" && r.RecoveryCode == "" {
+		return ErrMFACodeEmpty
+	}
+
+	return nil
+}
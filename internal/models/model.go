@@ -13,24 +13,25 @@ const (
 
 // Status constants
 const (
-	StatusActive   = "active"
-	StatusInactive = "inactive"
+	StatusActive              = "active"
+	StatusInactive            = "inactive"
+	StatusPendingVerification = "pending_verification"
 )
 
 // User represents the user entity
 type User struct {
-	ID           string    `json:"id,omitempty" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	Password     string    `json:"-" db:"password"` // Not exposed in JSON
-	FullName     string    `json:"name" db:"name"`
-	Role         string    `json:"role" db:"role"` // user, admin
-	Avatar       string    `json:"avatar,omitempty" db:"avatar"`
-	Phone        string    `json:"phone" db:"phone"`
-	Status       string    `json:"status" db:"status"` // active, inactive
-	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
-	LastLogin    time.Time `json:"lastLogin,omitempty"`
-	LastActivity time.Time `json:"lastActivity,omitempty"`
+	ID           string     `json:"id,omitempty" db:"id"`
+	Email        string     `json:"email" db:"email"`
+	Password     string     `json:"-" db:"password"` // Not exposed in JSON
+	FullName     string     `json:"name" db:"name"`
+	Role         string     `json:"role" db:"role"` // user, admin
+	Avatar       string     `json:"avatar,omitempty" db:"avatar"`
+	Phone        string     `json:"phone" db:"phone"`
+	Status       string     `json:"status" db:"status"` // active, inactive, pending_verification
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updatedAt" db:"updated_at"`
+	LastLogin    *time.Time `json:"lastLogin,omitempty" db:"last_login_at"`
+	LastActivity *time.Time `json:"lastActivity,omitempty" db:"last_activity_at"`
 }
 
 // CreateUserInput represents the input for user creation
@@ -40,6 +41,7 @@ type CreateUserInput struct {
 	FullName string `json:"name" validate:"required"`
 	Phone    string `json:"phone" validate:"required"`
 	Role     string `json:"role" validate:"required,oneof=user admin"`
+	Status   string `json:"status,omitempty" validate:"omitempty,oneof=active inactive pending_verification"`
 }
 
 // UpdateUserInput represents the input for user update
@@ -47,7 +49,26 @@ type UpdateUserInput struct {
 	FullName string `json:"name,omitempty"`
 	Phone    string `json:"phone,omitempty"`
 	Avatar   string `json:"avatar,omitempty"`
-	Status   string `json:"status,omitempty" validate:"omitempty,oneof=active inactive"`
+	Status   string `json:"status,omitempty" validate:"omitempty,oneof=active inactive pending_verification"`
+}
+
+var ErrInvalidStatus = errors.New("status must be active, inactive or pending_verification")
+
+// ValidStatus reports whether status is one of the account statuses the service knows how to handle
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusInactive, StatusPendingVerification:
+		return true
+	}
+	return false
+}
+
+func (i UpdateUserInput) Validate() error {
+	if i.Status != "" && !ValidStatus(i.Status) {
+		return ErrInvalidStatus
+	}
+
+	return nil
 }
 
 // LoginInput represents the input for user login
@@ -64,6 +85,18 @@ type UserSummary struct {
 	Email string `json:"email,omitempty"`
 
 	Role string `json:"role,omitempty"`
+
+	EmailVerified bool `json:"emailVerified"`
+}
+
+func NewUserSummary(user *User) *UserSummary {
+	return &UserSummary{
+		Id:            user.ID,
+		Name:          user.FullName,
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.Status != StatusPendingVerification,
+	}
 }
 
 type UserPage struct {
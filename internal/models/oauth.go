@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuth2 grant types supported by the provider
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OpenID Connect scopes, clients may be granted any other scope as well
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+)
+
+// OAuthClient is an application allowed to obtain tokens from the provider. Public clients (SPAs, mobile apps)
+// cannot keep a secret and have to use PKCE.
+type OAuthClient struct {
+	ID           string         `json:"clientId" db:"id"`
+	SecretHash   string         `json:"-" db:"secret_hash"`
+	Name         string         `json:"name" db:"name"`
+	RedirectURIs pq.StringArray `json:"redirectUris" db:"redirect_uris"`
+	GrantTypes   pq.StringArray `json:"grantTypes" db:"grant_types"`
+	Scopes       pq.StringArray `json:"scopes" db:"scopes"`
+	Public       bool           `json:"public" db:"is_public"`
+	CreatedAt    time.Time      `json:"createdAt" db:"created_at"`
+}
+
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+type CreateOAuthClientInput struct {
+	Name         string   `json:"name" validate:"required"`
+	RedirectURIs []string `json:"redirectUris"`
+	GrantTypes   []string `json:"grantTypes" validate:"required"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// CreateOAuthClientResponse carries the client secret, it is only ever shown once
+type CreateOAuthClientResponse struct {
+	Client       *OAuthClient `json:"client"`
+	ClientSecret string       `json:"clientSecret,omitempty"`
+}
+
+var (
+	ErrClientNameEmpty     = errors.New("name is required")
+	ErrGrantTypesEmpty     = errors.New("at least one grant type is required")
+	ErrInvalidGrantType    = errors.New("unsupported grant type")
+	ErrRedirectURIsEmpty   = errors.New("authorization_code clients need at least one redirect uri")
+	ErrInvalidRedirectURI  = errors.New("redirect uris must be absolute urls without fragment")
+	ErrPublicClientGrant   = errors.New("public clients cannot use client_credentials")
+	ErrRefreshWithoutLogin = errors.New("refresh_token requires the authorization_code grant")
+)
+
+func (i CreateOAuthClientInput) Validate() error {
+	if strings.TrimSpace(i.Name) == "" {
+		return ErrClientNameEmpty
+	}
+
+	if len(i.GrantTypes) == 0 {
+		return ErrGrantTypesEmpty
+	}
+	for _, grant := range i.GrantTypes {
+		if grant != GrantAuthorizationCode && grant != GrantClientCredentials && grant != GrantRefreshToken {
+			return ErrInvalidGrantType
+		}
+	}
+	if i.Public && slices.Contains(i.GrantTypes, GrantClientCredentials) {
+		return ErrPublicClientGrant
+	}
+	if slices.Contains(i.GrantTypes, GrantRefreshToken) && !slices.Contains(i.GrantTypes, GrantAuthorizationCode) {
+		return ErrRefreshWithoutLogin
+	}
+
+	if slices.Contains(i.GrantTypes, GrantAuthorizationCode) && len(i.RedirectURIs) == 0 {
+		return ErrRedirectURIsEmpty
+	}
+	for _, uri := range i.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return ErrInvalidRedirectURI
+		}
+	}
+
+	return nil
+}
+
+// AuthorizationCode is issued by /oauth/authorize and exchanged once at /oauth/token. Only its hash is stored.
+type AuthorizationCode struct {
+	CodeHash            string     `db:"code_hash"`
+	ClientID            string     `db:"client_id"`
+	UserID              string     `db:"user_id"`
+	RedirectURI         string     `db:"redirect_uri"`
+	Scope               string     `db:"scope"`
+	Nonce               string     `db:"nonce"`
+	CodeChallenge       string     `db:"code_challenge"`
+	CodeChallengeMethod string     `db:"code_challenge_method"`
+	AuthTime            time.Time  `db:"auth_time"`
+	ExpiresAt           time.Time  `db:"expires_at"`
+	UsedAt              *time.Time `db:"used_at"`
+	CreatedAt           time.Time  `db:"created_at"`
+}
+
+// AuthorizeRequest holds the parameters of an authorization request (RFC 6749 section 4.1.1, RFC 7636)
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// TokenRequest holds the form parameters of /oauth/token, the client credentials come from either
+// HTTP basic authentication or the form
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+}
+
+// TokenResponse is the successful response of /oauth/token (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// AuthorizeResponse tells the frontend where to send the browser after the user approved an authorization request
+type AuthorizeResponse struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+// OAuthConfig configures the lifetime of the codes and refresh tokens issued to OAuth clients
+type OAuthConfig struct {
+	AuthorizationCodeTTL time.Duration
+	RefreshTokenTTL      time.Duration
+}
+
+// ParseScope splits a space separated scope parameter
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// HasScope reports whether the space separated scope contains s
+func HasScope(scope string, s string) bool {
+	return slices.Contains(strings.Fields(scope), s)
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, it tells them apart from JWTs and makes
+// leaked tokens easy to find with secret scanners
+const PersonalAccessTokenPrefix = "usp_"
+
+// Scopes a personal access token or service account can be granted, each one opens a group of API routes
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAdmin      = "admin"
+)
+
+var APIScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// MaxPersonalAccessTokenDays is the longest lifetime a token can be created with, besides no expiry at all
+const MaxPersonalAccessTokenDays = 365
+
+// PersonalAccessToken lets scripts call the API on behalf of a user without their password
+type PersonalAccessToken struct {
+	ID         string         `json:"id" db:"id"`
+	UserID     string         `json:"userId" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	TokenHash  string         `json:"-" db:"token_hash"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time     `json:"expiresAt" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"lastUsedAt" db:"last_used_at"`
+	RevokedAt  *time.Time     `json:"-" db:"revoked_at"`
+	CreatedAt  time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// Expired reports whether the token has an expiry in the past
+func (t *PersonalAccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type CreatePersonalAccessTokenInput struct {
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required"`
+	// ExpiresInDays of 0 creates a token that never expires
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+// CreatePersonalAccessTokenResponse carries the token, it is only ever shown once
+type CreatePersonalAccessTokenResponse struct {
+	Token       *PersonalAccessToken `json:"token"`
+	AccessToken string               `json:"accessToken"`
+}
+
+var (
+	ErrTokenNameEmpty    = errors.New("name is required")
+	ErrTokenNameTooLong  = errors.New("name must be at most 100 characters")
+	ErrTokenScopesEmpty  = errors.New("at least one scope is required")
+	ErrUnknownTokenScope = errors.New("unknown scope, allowed scopes are " + strings.Join(APIScopes, ", "))
+	ErrInvalidTokenTTL   = errors.New("expiresInDays must be between 0 and 365")
+)
+
+func (i CreatePersonalAccessTokenInput) Validate() error {
+	name := strings.TrimSpace(i.Name)
+	if name == "" {
+		return ErrTokenNameEmpty
+	}
+	if len(name) > 100 {
+		return ErrTokenNameTooLong
+	}
+
+	if len(i.Scopes) == 0 {
+		return ErrTokenScopesEmpty
+	}
+	for _, scope := range i.Scopes {
+		if !slices.Contains(APIScopes, scope) {
+			return ErrUnknownTokenScope
+		}
+	}
+
+	if i.ExpiresInDays < 0 || i.ExpiresInDays > MaxPersonalAccessTokenDays {
+		return ErrInvalidTokenTTL
+	}
+
+	return nil
+}
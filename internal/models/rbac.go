@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Permissions checked by RequirePermission. A new permission also needs a row in the permissions table
+// so roles can be granted it.
+const (
+	PermUsersCreate      = "users:create"
+	PermUsersDelete      = "users:delete"
+	PermUsersUnlock      = "users:unlock"
+	PermRolesManage      = "roles:manage"
+	PermUsersImpersonate = "users:impersonate"
+	PermAuditRead        = "audit:read"
+	PermUsersStatus      = "users:manage-status"
+)
+
+var Permissions = []string{PermUsersCreate, PermUsersDelete, PermUsersUnlock, PermRolesManage, PermUsersImpersonate, PermAuditRead,
+	PermUsersStatus}
+
+// Role groups permissions, users hold their primary role (User.Role) and any number of additional roles.
+// System roles cannot be deleted, the admin role holds every permission.
+type Role struct {
+	ID          string         `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	System      bool           `json:"system" db:"is_system"`
+	Permissions pq.StringArray `json:"permissions" db:"permissions"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+type Permission struct {
+	ID          string `json:"id" db:"id"`
+	Description string `json:"description" db:"description"`
+}
+
+type SaveRoleInput struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// AssignRoleInput grants a user an additional role
+type AssignRoleInput struct {
+	RoleID string `json:"roleId" validate:"required"`
+}
+
+// UserRoles are the effective roles and permissions of a user, Roles includes the primary role
+type UserRoles struct {
+	UserID      string   `json:"userId"`
+	PrimaryRole string   `json:"primaryRole"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+var roleIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var (
+	ErrInvalidRoleID     = errors.New("role id must be a lowercase slug of at most 32 characters")
+	ErrRoleNameEmpty     = errors.New("name is required")
+	ErrUnknownPermission = errors.New("unknown permission, allowed permissions are " + strings.Join(Permissions, ", "))
+	ErrRoleIDEmpty       = errors.New("roleId is required")
+)
+
+// ValidateRoleID checks the slug identifying a role
+func ValidateRoleID(id string) error {
+	if !roleIDPattern.MatchString(id) {
+		return ErrInvalidRoleID
+	}
+	return nil
+}
+
+func (i SaveRoleInput) Validate() error {
+	if strings.TrimSpace(i.Name) == "" {
+		return ErrRoleNameEmpty
+	}
+
+	for _, permission := range i.Permissions {
+		if !slices.Contains(Permissions, permission) {
+			return ErrUnknownPermission
+		}
+	}
+
+	return nil
+}
+
+func (i AssignRoleInput) Validate() error {
+	if i.RoleID == "" {
+		return ErrRoleIDEmpty
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// OneTimeToken is a single-use secret mailed to a user. Only the hash of the token is persisted.
+type OneTimeToken struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"userId" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" db:"used_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=8"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type MagicLinkConsumeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+var (
+	ErrTokenEmpty = errors.New("token is required")
+)
+
+func (r ForgotPasswordRequest) Validate() error {
+	if r.Email == "" {
+		return ErrEmailEmpty
+	}
+
+	return nil
+}
+
+func (r ResetPasswordRequest) Validate() error {
+	if r.Token == "" {
+		return ErrTokenEmpty
+	}
+
+	if r.NewPassword == "" {
+		return ErrPasswordEmpty
+	}
+
+	return nil
+}
+
+func (r VerifyEmailRequest) Validate() error {
+	if r.Token == "" {
+		return ErrTokenEmpty
+	}
+
+	return nil
+}
+
+func (r ResendVerificationRequest) Validate() error {
+	if r.Email == "" {
+		return ErrEmailEmpty
+	}
+
+	return nil
+}
+
+func (r MagicLinkRequest) Validate() error {
+	if r.Email == "" {
+		return ErrEmailEmpty
+	}
+
+	return nil
+}
+
+func (r MagicLinkConsumeRequest) Validate() error {
+	if r.Token == "" {
+		return ErrTokenEmpty
+	}
+
+	return nil
+}
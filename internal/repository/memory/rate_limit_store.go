@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user_service/internal/repository"
+)
+
+type rateLimitCounter struct {
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+// rateLimitStore counts requests in process memory, every instance of the service limits on its own
+type rateLimitStore struct {
+	mu       sync.Mutex
+	counters map[string]*rateLimitCounter
+	hits     int
+}
+
+func NewRateLimitStore() repository.RateLimitStore {
+	return &rateLimitStore{counters: make(map[string]*rateLimitCounter)}
+}
+
+func (s *rateLimitStore) Hit(_ context.Context, key string, window time.Duration) (int, int, time.Time, error) {
+	now := time.Now()
+	windowStart := now.Truncate(window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hits++
+	if s.hits%10000 == 0 {
+		s.sweep(now)
+	}
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &rateLimitCounter{windowStart: windowStart}
+		s.counters[key] = counter
+	}
+
+	switch {
+	case counter.windowStart.Equal(windowStart):
+	case counter.windowStart.Add(window).Equal(windowStart):
+		counter.previous, counter.current = counter.current, 0
+		counter.windowStart = windowStart
+	default:
+		counter.previous, counter.current = 0, 0
+		counter.windowStart = windowStart
+	}
+	counter.current++
+
+	return counter.current, counter.previous, windowStart, nil
+}
+
+// sweep drops counters that were not hit for a day so the map does not grow with every client ever seen
+func (s *rateLimitStore) sweep(now time.Time) {
+	for key, counter := range s.counters {
+		if now.Sub(counter.windowStart) > 24*time.Hour {
+			delete(s.counters, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user_service/internal/repository"
+)
+
+// revocationStore keeps revocations in process memory. It is only correct when a single instance of the
+// service is running and revocations are lost on restart, use the postgres store otherwise.
+type revocationStore struct {
+	mu         sync.RWMutex
+	jtis       map[string]time.Time
+	validAfter map[string]time.Time
+}
+
+func NewRevocationStore() repository.RevocationStore {
+	return &revocationStore{
+		jtis:       make(map[string]time.Time),
+		validAfter: make(map[string]time.Time),
+	}
+}
+
+func (s *revocationStore) RevokeJTI(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.jtis {
+		if exp.Before(now) {
+			delete(s.jtis, id)
+		}
+	}
+	s.jtis[jti] = expiresAt
+
+	return nil
+}
+
+func (s *revocationStore) IsRevoked(_ context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.jtis[jti]
+	return ok, nil
+}
+
+func (s *revocationStore) SetValidAfter(_ context.Context, userID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.After(s.validAfter[userID]) {
+		s.validAfter[userID] = t
+	}
+
+	return nil
+}
+
+func (s *revocationStore) ValidAfter(_ context.Context, userID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.validAfter[userID], nil
+}
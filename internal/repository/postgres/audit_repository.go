@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+// auditChainLock is the advisory lock serializing appends, the chain needs one writer at a time
+const auditChainLock = 7_411_202_300
+
+type auditRepository struct {
+	db *sqlx.DB
+}
+
+// NewAuditRepository creates a repository backed by the audit_events table
+func NewAuditRepository(db *sqlx.DB) repository.AuditRepository {
+	return &auditRepository{db: db}
+}
+
+const selectAuditEvents = `
+        SELECT id, occurred_at, actor_type, actor_id, impersonator_id, action, target_type, target_id, ip, user_agent,
+            COALESCE(before, 'null') AS before, COALESCE(after, 'null') AS after, COALESCE(metadata, 'null') AS metadata,
+            prev_hash, hash
+        FROM audit_events
+    `
+
+func (r *auditRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = tx.QueryRowxContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	event.PrevHash = prevHash
+	event.Hash = event.ComputeHash(prevHash)
+
+	query := `
+        INSERT INTO audit_events (occurred_at, actor_type, actor_id, impersonator_id, action, target_type, target_id, ip,
+            user_agent, before, after, metadata, prev_hash, hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+        RETURNING id
+    `
+	err = tx.QueryRowxContext(ctx, query, event.OccurredAt, event.ActorType, event.ActorID, event.ImpersonatorID, event.Action,
+		event.TargetType, event.TargetID, event.IP, event.UserAgent, jsonb(event.Before), jsonb(event.After), jsonb(event.Metadata),
+		event.PrevHash, event.Hash).Scan(&event.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// jsonb stores an absent value as NULL
+func jsonb(raw []byte) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func (r *auditRepository) List(ctx context.Context, filter models.AuditFilter, params util.PaginationParams) ([]*models.AuditEvent, int64, error) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.ActorID != "" {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if filter.From != nil {
+		add("occurred_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("occurred_at < $%d", *filter.To)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.QueryRowxContext(ctx, `SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := selectAuditEvents + where + fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	events := []*models.AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, query, append(args, params.Limit, params.Offset)...); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (r *auditRepository) Chain(ctx context.Context, afterID int64, limit int) ([]*models.AuditEvent, error) {
+	events := []*models.AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, selectAuditEvents+` WHERE id > $1 ORDER BY id LIMIT $2`, afterID, limit); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 	"user_service/internal/models"
 	"user_service/internal/repository"
+	"user_service/internal/util"
 )
 
 type authRepository struct {
@@ -16,26 +17,46 @@ type authRepository struct {
 }
 
 var (
-	ErrInvalidToken = errors.New("invalid token")
-	ErrExpiredToken = errors.New("expired token")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrExpiredToken    = errors.New("expired token")
+	ErrSessionNotFound = errors.New("session not found")
 )
 
 func (a authRepository) Create(ctx context.Context, token *models.RefreshTokenData) error {
-	sql := `INSERT INTO refresh_tokens (id, user_id, token, expires_at, issued_at, is_revoked) VALUES ($1, $2, $3, $4, $5, $6)`
+	sql := `
+        INSERT INTO refresh_tokens (id, user_id, token_hash, family_id, expires_at, issued_at, is_revoked,
+                                    user_agent, ip_address, device_label, created_at, last_used_at, client_id, scope)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14)
+    `
 
 	if token.ID == "" {
 		token.ID = uuid.New().String()
 	}
+	if token.FamilyID == "" {
+		token.FamilyID = uuid.New().String()
+	}
+	token.TokenHash = util.HashToken(token.Token)
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = token.IssuedAt
+	}
+	if token.LastUsedAt.IsZero() {
+		token.LastUsedAt = token.IssuedAt
+	}
 
-	_, err := a.db.ExecContext(ctx, sql, token.ID, token.UserID, token.Token, token.ExpiresAt, token.IssuedAt, token.IsRevoked)
+	_, err := a.db.ExecContext(ctx, sql, token.ID, token.UserID, token.TokenHash, token.FamilyID, token.ExpiresAt, token.IssuedAt, token.IsRevoked,
+		token.UserAgent, token.IPAddress, token.DeviceLabel, token.CreatedAt, token.LastUsedAt, token.ClientID, token.Scope)
 
 	return err
 }
 
 func (a authRepository) GetByToken(ctx context.Context, token string) (*models.RefreshTokenData, error) {
 	var refreshToken models.RefreshTokenData
-	query := `SELECT id, user_id, token, expires_at, issued_at, is_revoked FROM refresh_tokens WHERE token = $1`
-	err := a.db.QueryRowx(query, token).StructScan(&refreshToken)
+	query := `
+        SELECT id, user_id, token_hash, family_id, expires_at, issued_at, is_revoked, rotated_at,
+               user_agent, ip_address, device_label, created_at, last_used_at, COALESCE(client_id, '') AS client_id, scope
+        FROM refresh_tokens WHERE token_hash = $1
+    `
+	err := a.db.QueryRowxContext(ctx, query, util.HashToken(token)).StructScan(&refreshToken)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -48,13 +69,40 @@ func (a authRepository) GetByToken(ctx context.Context, token string) (*models.R
 	if time.Now().After(refreshToken.ExpiresAt) {
 		return nil, ErrExpiredToken
 	}
+	refreshToken.Token = token
 
 	return &refreshToken, nil
 }
 
 func (a authRepository) RevokeToken(ctx context.Context, token string) error {
-	query := `UPDATE refresh_tokens SET is_revoked = true WHERE token = $1`
-	_, err := a.db.ExecContext(ctx, query, token)
+	query := `UPDATE refresh_tokens SET is_revoked = true WHERE token_hash = $1`
+	_, err := a.db.ExecContext(ctx, query, util.HashToken(token))
+
+	return err
+}
+
+func (a authRepository) RotateToken(ctx context.Context, token string) error {
+	query := `UPDATE refresh_tokens SET is_revoked = true, rotated_at = $1 WHERE token_hash = $2 AND is_revoked = false`
+	result, err := a.db.ExecContext(ctx, query, time.Now(), util.HashToken(token))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
+
+func (a authRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET is_revoked = true WHERE family_id = $1`
+	_, err := a.db.ExecContext(ctx, query, familyID)
 
 	return err
 }
@@ -66,6 +114,42 @@ func (a authRepository) RevokeAllTokens(ctx context.Context, userID string) erro
 	return err
 }
 
+func (a authRepository) ListActive(ctx context.Context, userID string) ([]*models.RefreshTokenData, error) {
+	query := `
+        SELECT id, user_id, token_hash, family_id, expires_at, issued_at, is_revoked, rotated_at,
+               user_agent, ip_address, device_label, created_at, last_used_at, COALESCE(client_id, '') AS client_id, scope
+        FROM refresh_tokens
+        WHERE user_id = $1 AND is_revoked = false AND expires_at > $2
+        ORDER BY last_used_at DESC
+    `
+
+	var tokens []*models.RefreshTokenData
+	if err := a.db.SelectContext(ctx, &tokens, query, userID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (a authRepository) RevokeSession(ctx context.Context, userID string, familyID string) error {
+	query := `UPDATE refresh_tokens SET is_revoked = true WHERE user_id = $1 AND family_id = $2 AND is_revoked = false`
+	result, err := a.db.ExecContext(ctx, query, userID, familyID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
 func (a authRepository) DeleteExpiredTokens(ctx context.Context) error {
 	query := `DELETE FROM refresh_tokens WHERE expires_at < $1`
 	_, err := a.db.ExecContext(ctx, query, time.Now())
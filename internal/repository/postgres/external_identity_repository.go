@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+var (
+	ErrProviderNotFound = errors.New("identity provider not found")
+	ErrIdentityNotFound = errors.New("external identity not found")
+)
+
+type identityProviderRepository struct {
+	db *sqlx.DB
+}
+
+// NewIdentityProviderRepository creates a repository backed by the identity_providers table
+func NewIdentityProviderRepository(db *sqlx.DB) repository.IdentityProviderRepository {
+	return &identityProviderRepository{db: db}
+}
+
+func (r *identityProviderRepository) Upsert(ctx context.Context, provider *models.IdentityProvider) error {
+	query := `
+        INSERT INTO identity_providers (id, name, issuer, client_id, client_secret, scopes, enabled, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+        ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id,
+            client_secret = EXCLUDED.client_secret, scopes = EXCLUDED.scopes, enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at
+        RETURNING created_at, updated_at
+    `
+
+	return r.db.QueryRowxContext(ctx, query, provider.ID, provider.Name, provider.Issuer, provider.ClientID, provider.ClientSecret,
+		provider.Scopes, provider.Enabled, time.Now()).Scan(&provider.CreatedAt, &provider.UpdatedAt)
+}
+
+func (r *identityProviderRepository) GetByID(ctx context.Context, id string) (*models.IdentityProvider, error) {
+	query := `
+        SELECT id, name, issuer, client_id, client_secret, scopes, enabled, created_at, updated_at
+        FROM identity_providers WHERE id = $1
+    `
+
+	var provider models.IdentityProvider
+	if err := r.db.QueryRowxContext(ctx, query, id).StructScan(&provider); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProviderNotFound
+		}
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+func (r *identityProviderRepository) List(ctx context.Context) ([]*models.IdentityProvider, error) {
+	query := `
+        SELECT id, name, issuer, client_id, client_secret, scopes, enabled, created_at, updated_at
+        FROM identity_providers ORDER BY name
+    `
+
+	providers := []*models.IdentityProvider{}
+	if err := r.db.SelectContext(ctx, &providers, query); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+func (r *identityProviderRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM identity_providers WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrProviderNotFound
+	}
+
+	return nil
+}
+
+type externalIdentityRepository struct {
+	db *sqlx.DB
+}
+
+// NewExternalIdentityRepository creates a repository backed by the external_identities table
+func NewExternalIdentityRepository(db *sqlx.DB) repository.ExternalIdentityRepository {
+	return &externalIdentityRepository{db: db}
+}
+
+func (r *externalIdentityRepository) Create(ctx context.Context, identity *models.ExternalIdentity) error {
+	query := `
+        INSERT INTO external_identities (id, user_id, provider_id, subject, email, created_at, last_login_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+
+	if identity.ID == "" {
+		identity.ID = uuid.New().String()
+	}
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	if identity.LastLoginAt.IsZero() {
+		identity.LastLoginAt = identity.CreatedAt
+	}
+
+	_, err := r.db.ExecContext(ctx, query, identity.ID, identity.UserID, identity.ProviderID, identity.Subject, identity.Email,
+		identity.CreatedAt, identity.LastLoginAt)
+
+	return err
+}
+
+func (r *externalIdentityRepository) GetBySubject(ctx context.Context, providerID string, subject string) (*models.ExternalIdentity, error) {
+	query := `
+        SELECT id, user_id, provider_id, subject, email, created_at, last_login_at
+        FROM external_identities WHERE provider_id = $1 AND subject = $2
+    `
+
+	var identity models.ExternalIdentity
+	if err := r.db.QueryRowxContext(ctx, query, providerID, subject).StructScan(&identity); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (r *externalIdentityRepository) ListByUser(ctx context.Context, userID string) ([]*models.ExternalIdentity, error) {
+	query := `
+        SELECT id, user_id, provider_id, subject, email, created_at, last_login_at
+        FROM external_identities WHERE user_id = $1 ORDER BY created_at
+    `
+
+	identities := []*models.ExternalIdentity{}
+	if err := r.db.SelectContext(ctx, &identities, query, userID); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (r *externalIdentityRepository) TouchLogin(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE external_identities SET last_login_at = $1 WHERE id = $2`, time.Now(), id)
+
+	return err
+}
+
+func (r *externalIdentityRepository) Delete(ctx context.Context, userID string, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM external_identities WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
+
+type externalLoginStateRepository struct {
+	db *sqlx.DB
+}
+
+// NewExternalLoginStateRepository creates a repository backed by the external_login_states table
+func NewExternalLoginStateRepository(db *sqlx.DB) repository.ExternalLoginStateRepository {
+	return &externalLoginStateRepository{db: db}
+}
+
+func (r *externalLoginStateRepository) Create(ctx context.Context, state *models.ExternalLoginState) error {
+	query := `
+        INSERT INTO external_login_states (state_hash, provider_id, nonce, code_verifier, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+
+	if state.CreatedAt.IsZero() {
+		state.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, state.StateHash, state.ProviderID, state.Nonce, state.CodeVerifier, state.ExpiresAt, state.CreatedAt)
+
+	return err
+}
+
+func (r *externalLoginStateRepository) Consume(ctx context.Context, stateHash string) (*models.ExternalLoginState, error) {
+	query := `
+        UPDATE external_login_states SET used_at = $1
+        WHERE state_hash = $2 AND used_at IS NULL AND expires_at > $1
+        RETURNING state_hash, provider_id, nonce, code_verifier, expires_at, used_at, created_at
+    `
+
+	var state models.ExternalLoginState
+	if err := r.db.QueryRowxContext(ctx, query, time.Now(), stateHash).StructScan(&state); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &state, nil
+}
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+var ErrImpersonationNotFound = errors.New("impersonation session not found")
+
+type impersonationRepository struct {
+	db *sqlx.DB
+}
+
+// NewImpersonationRepository creates a repository backed by the impersonation_sessions and impersonation_actions tables
+func NewImpersonationRepository(db *sqlx.DB) repository.ImpersonationRepository {
+	return &impersonationRepository{db: db}
+}
+
+func (r *impersonationRepository) Create(ctx context.Context, session *models.ImpersonationSession) error {
+	query := `
+        INSERT INTO impersonation_sessions (id, actor_id, subject_id, reason, ip, started_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+	if session.StartedAt.IsZero() {
+		session.StartedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, session.ID, session.ActorID, session.SubjectID, session.Reason, session.IP,
+		session.StartedAt, session.ExpiresAt)
+
+	return err
+}
+
+func (r *impersonationRepository) GetByID(ctx context.Context, id string) (*models.ImpersonationSession, error) {
+	query := `
+        SELECT id, actor_id, subject_id, reason, ip, started_at, expires_at, ended_at
+        FROM impersonation_sessions WHERE id = $1
+    `
+
+	var session models.ImpersonationSession
+	if err := r.db.QueryRowxContext(ctx, query, id).StructScan(&session); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrImpersonationNotFound
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *impersonationRepository) List(ctx context.Context, filter models.ImpersonationFilter) ([]*models.ImpersonationSession, error) {
+	query := `
+        SELECT id, actor_id, subject_id, reason, ip, started_at, expires_at, ended_at
+        FROM impersonation_sessions
+        WHERE ($1 = '' OR actor_id::text = $1) AND ($2 = '' OR subject_id::text = $2)
+        ORDER BY started_at DESC
+        LIMIT $3
+    `
+
+	sessions := []*models.ImpersonationSession{}
+	if err := r.db.SelectContext(ctx, &sessions, query, filter.ActorID, filter.SubjectID, filter.Limit); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *impersonationRepository) End(ctx context.Context, id string, endedAt time.Time) error {
+	query := `UPDATE impersonation_sessions SET ended_at = $2 WHERE id = $1 AND ended_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, id, endedAt)
+
+	return err
+}
+
+func (r *impersonationRepository) RecordAction(ctx context.Context, action *models.ImpersonationAction) error {
+	query := `
+        INSERT INTO impersonation_actions (session_id, method, path, status, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `
+
+	if action.CreatedAt.IsZero() {
+		action.CreatedAt = time.Now()
+	}
+
+	return r.db.QueryRowxContext(ctx, query, action.SessionID, action.Method, action.Path, action.Status, action.CreatedAt).
+		Scan(&action.ID)
+}
+
+func (r *impersonationRepository) ListActions(ctx context.Context, sessionID string) ([]models.ImpersonationAction, error) {
+	query := `
+        SELECT id, session_id, method, path, status, created_at
+        FROM impersonation_actions WHERE session_id = $1 ORDER BY id
+    `
+
+	actions := []models.ImpersonationAction{}
+	if err := r.db.SelectContext(ctx, &actions, query, sessionID); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
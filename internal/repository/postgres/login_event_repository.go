@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+type loginEventRepository struct {
+	db *sqlx.DB
+}
+
+// NewLoginEventRepository creates a repository backed by the login_events table
+func NewLoginEventRepository(db *sqlx.DB) repository.LoginEventRepository {
+	return &loginEventRepository{db: db}
+}
+
+const selectLoginEvents = `
+        SELECT id, COALESCE(user_id::text, '') AS user_id, email, success, method, failure_reason, ip_address, user_agent,
+            device_label, device_fingerprint, ip_subnet, suspicious_reasons, created_at
+        FROM login_events
+    `
+
+func (r *loginEventRepository) Create(ctx context.Context, event *models.LoginEvent) error {
+	query := `
+        INSERT INTO login_events (id, user_id, email, success, method, failure_reason, ip_address, user_agent, device_label,
+            device_fingerprint, ip_subnet, suspicious_reasons, created_at)
+        VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+    `
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	if event.Suspicious == nil {
+		event.Suspicious = pq.StringArray{}
+	}
+
+	_, err := r.db.ExecContext(ctx, query, event.ID, event.UserID, event.Email, event.Success, event.Method, event.FailureReason,
+		event.IPAddress, event.UserAgent, event.DeviceLabel, event.DeviceFingerprint, event.IPSubnet, event.Suspicious, event.CreatedAt)
+
+	return err
+}
+
+func (r *loginEventRepository) ListByUser(ctx context.Context, userID string, params util.PaginationParams) ([]*models.LoginEvent, int64, error) {
+	var total int64
+	if err := r.db.QueryRowxContext(ctx, `SELECT COUNT(*) FROM login_events WHERE user_id = $1`, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := selectLoginEvents + `
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3
+    `
+
+	events := []*models.LoginEvent{}
+	if err := r.db.SelectContext(ctx, &events, query, userID, params.Limit, params.Offset); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (r *loginEventRepository) RecentSuccesses(ctx context.Context, userID string, limit int) ([]*models.LoginEvent, error) {
+	query := selectLoginEvents + `
+        WHERE user_id = $1 AND success
+        ORDER BY created_at DESC
+        LIMIT $2
+    `
+
+	events := []*models.LoginEvent{}
+	if err := r.db.SelectContext(ctx, &events, query, userID, limit); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
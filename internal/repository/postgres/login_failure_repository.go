@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+type loginFailureRepository struct {
+	db *sqlx.DB
+}
+
+// NewLoginFailureRepository creates a new PostgreSQL login failure repository
+func NewLoginFailureRepository(db *sqlx.DB) repository.LoginFailureRepository {
+	return &loginFailureRepository{db: db}
+}
+
+func (r *loginFailureRepository) Get(ctx context.Context, key string) (*models.LoginFailure, error) {
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_failures WHERE key = $1`
+
+	var failure models.LoginFailure
+	err := r.db.QueryRowxContext(ctx, query, key).StructScan(&failure)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+func (r *loginFailureRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	query := `
+        INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, $2)
+        ON CONFLICT (key) DO UPDATE
+        SET failures        = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+            last_failure_at = EXCLUDED.last_failure_at
+        RETURNING failures
+    `
+
+	now := time.Now()
+	var failures int
+	if err := r.db.GetContext(ctx, &failures, query, key, now, now.Add(-window)); err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (r *loginFailureRepository) SetLockedUntil(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE login_failures SET locked_until = $2 WHERE key = $1`, key, until)
+	return err
+}
+
+func (r *loginFailureRepository) Reset(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+// mfaRepository stores the TOTP secret sealed with the user id as associated data
+type mfaRepository struct {
+	db      *sqlx.DB
+	secrets *util.SecretCipher
+}
+
+var (
+	ErrMFANotFound         = errors.New("mfa not configured")
+	ErrMFACodeReplayed     = errors.New("mfa code already used")
+	ErrRecoveryCodeInvalid = errors.New("invalid recovery code")
+)
+
+// NewMFARepository creates a new PostgreSQL MFA repository
+func NewMFARepository(db *sqlx.DB, secrets *util.SecretCipher) repository.MFARepository {
+	return &mfaRepository{db: db, secrets: secrets}
+}
+
+func (r *mfaRepository) Get(ctx context.Context, userID string) (*models.MFAConfig, error) {
+	query := `SELECT user_id, secret, enabled, confirmed_at, last_used_step, created_at, updated_at FROM user_mfa WHERE user_id = $1`
+
+	var config models.MFAConfig
+	err := r.db.QueryRowxContext(ctx, query, userID).StructScan(&config)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotFound
+		}
+		return nil, err
+	}
+
+	// secrets stored before they were encrypted are sealed on first read
+	if !util.IsSealed(config.Secret) {
+		sealed, err := r.secrets.Seal(config.Secret, config.UserID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.db.ExecContext(ctx, `UPDATE user_mfa SET secret = $1 WHERE user_id = $2 AND secret = $3`,
+			sealed, config.UserID, config.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return &config, nil
+	}
+
+	config.Secret, err = r.secrets.Open(config.Secret, config.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Upsert stores a new pending enrollment, replacing any previous unconfirmed secret
+func (r *mfaRepository) Upsert(ctx context.Context, config *models.MFAConfig) error {
+	query := `
+        INSERT INTO user_mfa (user_id, secret, enabled, last_used_step, created_at, updated_at)
+        VALUES ($1, $2, false, 0, $3, $3)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, enabled = false, confirmed_at = NULL, last_used_step = 0, updated_at = EXCLUDED.updated_at
+    `
+
+	sealed, err := r.secrets.Seal(config.Secret, config.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, config.UserID, sealed, time.Now())
+
+	return err
+}
+
+func (r *mfaRepository) Enable(ctx context.Context, userID string) error {
+	query := `UPDATE user_mfa SET enabled = true, confirmed_at = $1, updated_at = $1 WHERE user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrMFANotFound
+	}
+
+	return nil
+}
+
+func (r *mfaRepository) Delete(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *mfaRepository) UseStep(ctx context.Context, userID string, step int64) error {
+	query := `UPDATE user_mfa SET last_used_step = $1, updated_at = $2 WHERE user_id = $3 AND last_used_step < $1`
+
+	result, err := r.db.ExecContext(ctx, query, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrMFACodeReplayed
+	}
+
+	return nil
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, hash := range codeHashes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, $4)`,
+			uuid.New().String(), userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	query := `UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+
+	return nil
+}
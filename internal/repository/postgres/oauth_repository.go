@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+var (
+	ErrClientNotFound = errors.New("oauth client not found")
+)
+
+type oauthClientRepository struct {
+	db *sqlx.DB
+}
+
+// NewOAuthClientRepository creates a repository backed by the oauth_clients table
+func NewOAuthClientRepository(db *sqlx.DB) repository.OAuthClientRepository {
+	return &oauthClientRepository{db: db}
+}
+
+func (r *oauthClientRepository) Create(ctx context.Context, client *models.OAuthClient) error {
+	query := `
+        INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, grant_types, scopes, is_public, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, client.ID, client.SecretHash, client.Name, client.RedirectURIs, client.GrantTypes,
+		client.Scopes, client.Public, client.CreatedAt)
+
+	return err
+}
+
+func (r *oauthClientRepository) GetByID(ctx context.Context, id string) (*models.OAuthClient, error) {
+	query := `
+        SELECT id, secret_hash, name, redirect_uris, grant_types, scopes, is_public, created_at
+        FROM oauth_clients WHERE id = $1
+    `
+
+	var client models.OAuthClient
+	if err := r.db.QueryRowxContext(ctx, query, id).StructScan(&client); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrClientNotFound
+		}
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (r *oauthClientRepository) List(ctx context.Context) ([]*models.OAuthClient, error) {
+	query := `
+        SELECT id, secret_hash, name, redirect_uris, grant_types, scopes, is_public, created_at
+        FROM oauth_clients ORDER BY created_at
+    `
+
+	clients := []*models.OAuthClient{}
+	if err := r.db.SelectContext(ctx, &clients, query); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (r *oauthClientRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrClientNotFound
+	}
+
+	return nil
+}
+
+type authorizationCodeRepository struct {
+	db *sqlx.DB
+}
+
+// NewAuthorizationCodeRepository creates a repository backed by the oauth_authorization_codes table
+func NewAuthorizationCodeRepository(db *sqlx.DB) repository.AuthorizationCodeRepository {
+	return &authorizationCodeRepository{db: db}
+}
+
+func (r *authorizationCodeRepository) Create(ctx context.Context, code *models.AuthorizationCode) error {
+	query := `
+        INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce,
+                                               code_challenge, code_challenge_method, auth_time, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    `
+
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.CodeChallengeMethod, code.AuthTime, code.ExpiresAt, code.CreatedAt)
+
+	return err
+}
+
+func (r *authorizationCodeRepository) Consume(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	// a single statement so two concurrent exchanges of the same code cannot both succeed
+	query := `
+        UPDATE oauth_authorization_codes SET used_at = $1
+        WHERE code_hash = $2 AND used_at IS NULL AND expires_at > $1
+        RETURNING code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method,
+                  auth_time, expires_at, used_at, created_at
+    `
+
+	var code models.AuthorizationCode
+	if err := r.db.QueryRowxContext(ctx, query, time.Now(), codeHash).StructScan(&code); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &code, nil
+}
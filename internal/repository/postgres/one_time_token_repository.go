@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+// oneTimeTokenRepository stores hashed single-use tokens. Every kind of token
+// (password reset, email verification, MFA challenge, ...) lives in its own table sharing the same layout.
+type oneTimeTokenRepository struct {
+	db    *sqlx.DB
+	table string
+}
+
+var (
+	ErrTokenUsed = errors.New("token already used")
+)
+
+// NewPasswordResetRepository creates a repository backed by the password_reset_tokens table
+func NewPasswordResetRepository(db *sqlx.DB) repository.OneTimeTokenRepository {
+	return &oneTimeTokenRepository{db: db, table: "password_reset_tokens"}
+}
+
+// NewEmailVerificationRepository creates a repository backed by the email_verification_tokens table
+func NewEmailVerificationRepository(db *sqlx.DB) repository.OneTimeTokenRepository {
+	return &oneTimeTokenRepository{db: db, table: "email_verification_tokens"}
+}
+
+// mfaChallengeRepository also counts the wrong codes entered for a challenge
+type mfaChallengeRepository struct {
+	*oneTimeTokenRepository
+}
+
+// NewMFAChallengeRepository creates a repository backed by the mfa_challenge_tokens table
+func NewMFAChallengeRepository(db *sqlx.DB) repository.MFAChallengeRepository {
+	return &mfaChallengeRepository{&oneTimeTokenRepository{db: db, table: "mfa_challenge_tokens"}}
+}
+
+// NewMagicLinkRepository creates a repository backed by the magic_link_tokens table
+func NewMagicLinkRepository(db *sqlx.DB) repository.OneTimeTokenRepository {
+	return &oneTimeTokenRepository{db: db, table: "magic_link_tokens"}
+}
+
+func (r *oneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	query := fmt.Sprintf(`INSERT INTO %s (id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`, r.table)
+
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt, token.CreatedAt)
+
+	return err
+}
+
+func (r *oneTimeTokenRepository) GetByHash(ctx context.Context, hash string) (*models.OneTimeToken, error) {
+	query := fmt.Sprintf(`SELECT id, user_id, token_hash, expires_at, used_at, created_at FROM %s WHERE token_hash = $1`, r.table)
+
+	var token models.OneTimeToken
+	err := r.db.QueryRowxContext(ctx, query, hash).StructScan(&token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if token.UsedAt != nil {
+		return nil, ErrTokenUsed
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	return &token, nil
+}
+
+// MarkUsed consumes the token. It fails with ErrTokenUsed when another request consumed it first.
+func (r *oneTimeTokenRepository) MarkUsed(ctx context.Context, id string) error {
+	query := fmt.Sprintf(`UPDATE %s SET used_at = $1 WHERE id = $2 AND used_at IS NULL`, r.table)
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
+
+func (r *oneTimeTokenRepository) InvalidateByUserID(ctx context.Context, userID string) error {
+	query := fmt.Sprintf(`UPDATE %s SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`, r.table)
+	_, err := r.db.ExecContext(ctx, query, time.Now(), userID)
+
+	return err
+}
+
+// RecordFailedAttempt fails with ErrTokenUsed when the challenge was already consumed
+func (r *mfaChallengeRepository) RecordFailedAttempt(ctx context.Context, id string, maxAttempts int) error {
+	query := `
+        UPDATE mfa_challenge_tokens
+        SET failed_attempts = failed_attempts + 1,
+            used_at = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE used_at END
+        WHERE id = $1 AND used_at IS NULL
+    `
+
+	result, err := r.db.ExecContext(ctx, query, id, maxAttempts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+
+type personalAccessTokenRepository struct {
+	db *sqlx.DB
+}
+
+// NewPersonalAccessTokenRepository creates a repository backed by the personal_access_tokens table
+func NewPersonalAccessTokenRepository(db *sqlx.DB) repository.PersonalAccessTokenRepository {
+	return &personalAccessTokenRepository{db: db}
+}
+
+func (r *personalAccessTokenRepository) Create(ctx context.Context, token *models.PersonalAccessToken) error {
+	query := `
+        INSERT INTO personal_access_tokens (id, user_id, name, token_hash, prefix, scopes, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, query, token.ID, token.UserID, token.Name, token.TokenHash, token.Prefix, token.Scopes,
+		token.ExpiresAt, token.CreatedAt)
+
+	return err
+}
+
+func (r *personalAccessTokenRepository) GetByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error) {
+	query := `
+        SELECT id, user_id, name, token_hash, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+        FROM personal_access_tokens WHERE token_hash = $1 AND revoked_at IS NULL
+    `
+
+	var token models.PersonalAccessToken
+	if err := r.db.QueryRowxContext(ctx, query, hash).StructScan(&token); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPersonalAccessTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *personalAccessTokenRepository) ListByUser(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error) {
+	query := `
+        SELECT id, user_id, name, token_hash, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+        FROM personal_access_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC
+    `
+
+	tokens := []*models.PersonalAccessToken{}
+	if err := r.db.SelectContext(ctx, &tokens, query, userID); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *personalAccessTokenRepository) Revoke(ctx context.Context, userID string, id string) error {
+	query := `UPDATE personal_access_tokens SET revoked_at = $1 WHERE user_id = $2 AND id = $3 AND revoked_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), userID, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	return nil
+}
+
+func (r *personalAccessTokenRepository) TouchLastUsed(ctx context.Context, id string, interval time.Duration) error {
+	query := `
+        UPDATE personal_access_tokens SET last_used_at = $1
+        WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)
+    `
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query, now, id, now.Add(-interval))
+
+	return err
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"math/rand/v2"
+	"time"
+	"user_service/internal/repository"
+)
+
+type rateLimitStore struct {
+	db *sqlx.DB
+}
+
+// NewRateLimitStore creates a rate limit store shared by every instance of the service
+func NewRateLimitStore(db *sqlx.DB) repository.RateLimitStore {
+	return &rateLimitStore{db: db}
+}
+
+func (r *rateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, int, time.Time, error) {
+	now := time.Now()
+	windowStart := now.Truncate(window)
+
+	query := `
+        INSERT INTO rate_limit_counters (key, window_start, count) VALUES ($1, $2, 1)
+        ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_counters.count + 1
+        RETURNING count
+    `
+	var current int
+	if err := r.db.GetContext(ctx, &current, query, key, windowStart); err != nil {
+		return 0, 0, windowStart, err
+	}
+
+	var previous int
+	err := r.db.GetContext(ctx, &previous, `SELECT COALESCE(SUM(count), 0) FROM rate_limit_counters WHERE key = $1 AND window_start = $2`,
+		key, windowStart.Add(-window))
+	if err != nil {
+		return 0, 0, windowStart, err
+	}
+
+	// windows are only needed until the next one ended, clean up now and then instead of on every request
+	if rand.IntN(100) == 0 {
+		if _, err := r.db.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE window_start < $1`, now.Add(-24*time.Hour)); err != nil {
+			return 0, 0, windowStart, err
+		}
+	}
+
+	return current, previous, windowStart, nil
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user_service/internal/repository"
+)
+
+type revocationStore struct {
+	db *sqlx.DB
+}
+
+// NewRevocationStore creates a revocation store shared by every instance of the service
+func NewRevocationStore(db *sqlx.DB) repository.RevocationStore {
+	return &revocationStore{db: db}
+}
+
+func (r *revocationStore) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_access_tokens (jti, expires_at, revoked_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, query, jti, expiresAt, time.Now()); err != nil {
+		return err
+	}
+
+	// expired tokens are rejected by their exp claim anyway
+	_, err := r.db.ExecContext(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < $1`, time.Now())
+	return err
+}
+
+func (r *revocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := r.db.GetContext(ctx, &revoked, `SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)`, jti)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (r *revocationStore) SetValidAfter(ctx context.Context, userID string, t time.Time) error {
+	query := `
+        INSERT INTO user_token_validity (user_id, valid_after) VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET valid_after = GREATEST(user_token_validity.valid_after, EXCLUDED.valid_after)
+    `
+	_, err := r.db.ExecContext(ctx, query, userID, t)
+	return err
+}
+
+func (r *revocationStore) ValidAfter(ctx context.Context, userID string) (time.Time, error) {
+	var validAfter time.Time
+	err := r.db.GetContext(ctx, &validAfter, `SELECT valid_after FROM user_token_validity WHERE user_id = $1`, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return validAfter, nil
+}
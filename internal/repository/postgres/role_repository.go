@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+var (
+	ErrRoleNotFound     = errors.New("role not found")
+	ErrUserRoleNotFound = errors.New("user does not have the role")
+)
+
+type roleRepository struct {
+	db *sqlx.DB
+}
+
+// NewRoleRepository creates a repository backed by the roles, role_permissions and user_roles tables
+func NewRoleRepository(db *sqlx.DB) repository.RoleRepository {
+	return &roleRepository{db: db}
+}
+
+const selectRoles = `
+        SELECT r.id, r.name, r.description, r.is_system, r.created_at, r.updated_at,
+            COALESCE(array_agg(rp.permission_id ORDER BY rp.permission_id) FILTER (WHERE rp.permission_id IS NOT NULL), '{}') AS permissions
+        FROM roles r LEFT JOIN role_permissions rp ON rp.role_id = r.id
+    `
+
+func (r *roleRepository) Upsert(ctx context.Context, role *models.Role) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO roles (id, name, description, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $4)
+        ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description, updated_at = EXCLUDED.updated_at
+        RETURNING is_system, created_at, updated_at
+    `
+	err = tx.QueryRowxContext(ctx, query, role.ID, role.Name, role.Description, time.Now()).
+		Scan(&role.System, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, role.ID); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO role_permissions (role_id, permission_id) SELECT $1, unnest($2::text[])`
+	if _, err := tx.ExecContext(ctx, query, role.ID, pq.Array(role.Permissions)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *roleRepository) GetByID(ctx context.Context, id string) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.QueryRowxContext(ctx, selectRoles+` WHERE r.id = $1 GROUP BY r.id`, id).StructScan(&role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (r *roleRepository) List(ctx context.Context) ([]*models.Role, error) {
+	roles := []*models.Role{}
+	if err := r.db.SelectContext(ctx, &roles, selectRoles+` GROUP BY r.id ORDER BY r.id`); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r *roleRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM roles WHERE id = $1 AND NOT is_system`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+func (r *roleRepository) ListPermissions(ctx context.Context) ([]*models.Permission, error) {
+	permissions := []*models.Permission{}
+	if err := r.db.SelectContext(ctx, &permissions, `SELECT id, description FROM permissions ORDER BY id`); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (r *roleRepository) PermissionsOf(ctx context.Context, roleIDs []string) ([]string, error) {
+	query := `SELECT DISTINCT permission_id FROM role_permissions WHERE role_id = ANY($1) ORDER BY permission_id`
+
+	permissions := []string{}
+	if err := r.db.SelectContext(ctx, &permissions, query, pq.Array(roleIDs)); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (r *roleRepository) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	roles := []string{}
+	if err := r.db.SelectContext(ctx, &roles, `SELECT role_id FROM user_roles WHERE user_id = $1 ORDER BY role_id`, userID); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r *roleRepository) AddUserRole(ctx context.Context, userID string, roleID string) error {
+	query := `INSERT INTO user_roles (user_id, role_id, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, userID, roleID, time.Now())
+
+	return err
+}
+
+func (r *roleRepository) RemoveUserRole(ctx context.Context, userID string, roleID string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2`, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrUserRoleNotFound
+	}
+
+	return nil
+}
@@ -42,7 +42,7 @@ func (r *userRepository) Create(ctx context.Context, user *models.User) error {
 
 func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	query := `
-        SELECT id, email, password, name, role, avatar, phone, status, created_at, updated_at
+        SELECT id, email, password, name, role, avatar, phone, status, created_at, updated_at, last_login_at, last_activity_at
         FROM users WHERE id = $1
     `
 
@@ -63,7 +63,7 @@ func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User,
 
 func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-        SELECT id, email, password, name, role, avatar, phone, status, created_at, updated_at
+        SELECT id, email, password, name, role, avatar, phone, status, created_at, updated_at, last_login_at, last_activity_at
         FROM users WHERE email = $1
     `
 
@@ -176,3 +176,15 @@ func (r *userRepository) CountUser(ctx context.Context) (int, error) {
 
 	return count, nil
 }
+
+func (r *userRepository) UpdateLastLogin(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE users SET last_login_at = $2, last_activity_at = $2 WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id, at)
+	return err
+}
+
+func (r *userRepository) UpdateLastActivity(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE users SET last_activity_at = $2 WHERE id = $1 AND (last_activity_at IS NULL OR last_activity_at < $2)`
+	_, err := r.db.ExecContext(ctx, query, id, at)
+	return err
+}
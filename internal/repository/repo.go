@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 	"user_service/internal/models"
 	"user_service/internal/util"
 )
@@ -14,12 +15,163 @@ type UserRepository interface {
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context, params util.PaginationParams) ([]*models.User, int64, error)
 	CountUser(ctx context.Context) (int, error)
+	// UpdateLastLogin sets the last login and activity of the user, other columns are left alone
+	UpdateLastLogin(ctx context.Context, id string, at time.Time) error
+	UpdateLastActivity(ctx context.Context, id string, at time.Time) error
 }
 
 type AuthRepository interface {
 	Create(ctx context.Context, token *models.RefreshTokenData) error
 	GetByToken(ctx context.Context, token string) (*models.RefreshTokenData, error)
 	RevokeToken(ctx context.Context, token string) error
+	// RotateToken revokes a token that is being exchanged for a new one, it fails when the token was already revoked
+	RotateToken(ctx context.Context, token string) error
+	RevokeFamily(ctx context.Context, familyID string) error
 	RevokeAllTokens(ctx context.Context, userID string) error
+	// ListActive returns the unrevoked, unexpired token of every session of the user
+	ListActive(ctx context.Context, userID string) ([]*models.RefreshTokenData, error)
+	RevokeSession(ctx context.Context, userID string, familyID string) error
 	DeleteExpiredTokens(ctx context.Context) error
 }
+
+type OneTimeTokenRepository interface {
+	Create(ctx context.Context, token *models.OneTimeToken) error
+	GetByHash(ctx context.Context, hash string) (*models.OneTimeToken, error)
+	MarkUsed(ctx context.Context, id string) error
+	InvalidateByUserID(ctx context.Context, userID string) error
+}
+
+// MFAChallengeRepository stores the logins waiting for their second factor
+type MFAChallengeRepository interface {
+	OneTimeTokenRepository
+	// RecordFailedAttempt counts a wrong code against the challenge and consumes it on the maxAttempts-th
+	RecordFailedAttempt(ctx context.Context, id string, maxAttempts int) error
+}
+
+// MFARepository keeps the TOTP secret encrypted at rest, Get and Upsert take and return it in plaintext
+type MFARepository interface {
+	Get(ctx context.Context, userID string) (*models.MFAConfig, error)
+	Upsert(ctx context.Context, config *models.MFAConfig) error
+	Enable(ctx context.Context, userID string) error
+	Delete(ctx context.Context, userID string) error
+	// UseStep records the TOTP step of an accepted code, it fails when the step was already used
+	UseStep(ctx context.Context, userID string, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) error
+}
+
+// RevocationStore rejects access tokens before they expire, either one token by its jti or every token
+// of a user issued before a cutoff.
+type RevocationStore interface {
+	RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// SetValidAfter invalidates every access token of the user, or service account, issued before t
+	SetValidAfter(ctx context.Context, userID string, t time.Time) error
+	// ValidAfter returns the zero time when the user never had their tokens invalidated
+	ValidAfter(ctx context.Context, userID string) (time.Time, error)
+}
+
+type LoginFailureRepository interface {
+	// Get returns nil when the key has no failures recorded
+	Get(ctx context.Context, key string) (*models.LoginFailure, error)
+	// RecordFailure increments the counter of the key, restarting from one when the previous failure is older than window
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	SetLockedUntil(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+// RateLimitStore counts requests in fixed windows, the rate limiter weights the previous window to get a sliding window
+type RateLimitStore interface {
+	// Hit counts a request for key and returns the count of the current window including it and the count of the previous one
+	Hit(ctx context.Context, key string, window time.Duration) (current int, previous int, windowStart time.Time, err error)
+}
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *models.OAuthClient) error
+	GetByID(ctx context.Context, id string) (*models.OAuthClient, error)
+	List(ctx context.Context) ([]*models.OAuthClient, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type AuthorizationCodeRepository interface {
+	Create(ctx context.Context, code *models.AuthorizationCode) error
+	// Consume marks an unused, unexpired code as used and returns it, a code can only be consumed once
+	Consume(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+}
+
+type IdentityProviderRepository interface {
+	// Upsert creates the provider or replaces its configuration
+	Upsert(ctx context.Context, provider *models.IdentityProvider) error
+	GetByID(ctx context.Context, id string) (*models.IdentityProvider, error)
+	List(ctx context.Context) ([]*models.IdentityProvider, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type ExternalIdentityRepository interface {
+	Create(ctx context.Context, identity *models.ExternalIdentity) error
+	GetBySubject(ctx context.Context, providerID string, subject string) (*models.ExternalIdentity, error)
+	ListByUser(ctx context.Context, userID string) ([]*models.ExternalIdentity, error)
+	TouchLogin(ctx context.Context, id string) error
+	Delete(ctx context.Context, userID string, id string) error
+}
+
+type ExternalLoginStateRepository interface {
+	Create(ctx context.Context, state *models.ExternalLoginState) error
+	// Consume marks an unused, unexpired state as used and returns it
+	Consume(ctx context.Context, stateHash string) (*models.ExternalLoginState, error)
+}
+
+type PersonalAccessTokenRepository interface {
+	Create(ctx context.Context, token *models.PersonalAccessToken) error
+	// GetByHash returns unrevoked tokens only, expired ones are returned for the caller to reject
+	GetByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error)
+	ListByUser(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error)
+	Revoke(ctx context.Context, userID string, id string) error
+	// TouchLastUsed records a use of the token, at most once per interval to spare the database a write per request
+	TouchLastUsed(ctx context.Context, id string, interval time.Duration) error
+}
+
+type RoleRepository interface {
+	// Upsert creates the role or replaces its name, description and permissions
+	Upsert(ctx context.Context, role *models.Role) error
+	GetByID(ctx context.Context, id string) (*models.Role, error)
+	List(ctx context.Context) ([]*models.Role, error)
+	Delete(ctx context.Context, id string) error
+	ListPermissions(ctx context.Context) ([]*models.Permission, error)
+	// PermissionsOf returns the permissions granted to any of the roles
+	PermissionsOf(ctx context.Context, roleIDs []string) ([]string, error)
+	// UserRoles returns the additional roles of a user, the primary role is stored with the user
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	AddUserRole(ctx context.Context, userID string, roleID string) error
+	RemoveUserRole(ctx context.Context, userID string, roleID string) error
+}
+
+type ImpersonationRepository interface {
+	Create(ctx context.Context, session *models.ImpersonationSession) error
+	GetByID(ctx context.Context, id string) (*models.ImpersonationSession, error)
+	// List returns the most recent sessions first, without their actions
+	List(ctx context.Context, filter models.ImpersonationFilter) ([]*models.ImpersonationSession, error)
+	// End marks a session ended, sessions that already ended are left alone
+	End(ctx context.Context, id string, endedAt time.Time) error
+	RecordAction(ctx context.Context, action *models.ImpersonationAction) error
+	ListActions(ctx context.Context, sessionID string) ([]models.ImpersonationAction, error)
+}
+
+// AuditRepository stores the audit log, events can only be appended
+type AuditRepository interface {
+	// Append chains the event to the last one and stores it, appends are serialized across instances
+	Append(ctx context.Context, event *models.AuditEvent) error
+	// List returns the most recent events first
+	List(ctx context.Context, filter models.AuditFilter, params util.PaginationParams) ([]*models.AuditEvent, int64, error)
+	// Chain returns up to limit events after the id in the order they were appended
+	Chain(ctx context.Context, afterID int64, limit int) ([]*models.AuditEvent, error)
+}
+
+// LoginEventRepository stores login attempts
+type LoginEventRepository interface {
+	Create(ctx context.Context, event *models.LoginEvent) error
+	// ListByUser returns the attempts on the user's account, most recent first
+	ListByUser(ctx context.Context, userID string, params util.PaginationParams) ([]*models.LoginEvent, int64, error)
+	// RecentSuccesses returns the last successful logins of the user, most recent first
+	RecentSuccesses(ctx context.Context, userID string, limit int) ([]*models.LoginEvent, error)
+}
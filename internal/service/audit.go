@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"reflect"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+// AuditService keeps the tamper evident record of who did what. The actor is taken from the claims the
+// auth middleware put on the request context, the client from its client info.
+type AuditService interface {
+	// Record never fails, the operation already happened, a failure to record it is logged
+	Record(ctx context.Context, entry models.AuditEntry)
+	List(ctx context.Context, filter models.AuditFilter, params util.PaginationParams) ([]*models.AuditEvent, int64, error)
+	// Verify recomputes the hash chain from the first event
+	Verify(ctx context.Context) (*models.AuditVerification, error)
+}
+
+// auditVerifyBatch is how many events Verify reads at a time
+const auditVerifyBatch = 1000
+
+type auditService struct {
+	repo repository.AuditRepository
+	log  *zap.Logger
+}
+
+func NewAuditService(repo repository.AuditRepository, log *zap.Logger) AuditService {
+	return &auditService{repo: repo, log: log}
+}
+
+func (s *auditService) Record(ctx context.Context, entry models.AuditEntry) {
+	client := util.ClientInfoFromContext(ctx)
+	event := &models.AuditEvent{
+		// the database keeps microseconds, the hash has to match what is read back
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond),
+		ActorType:  models.AuditActorAnonymous,
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		IP:         client.IPAddress,
+		UserAgent:  client.UserAgent,
+		Before:     s.marshal(entry.Before),
+		After:      s.marshal(entry.After),
+	}
+	if len(entry.Metadata) > 0 {
+		event.Metadata = s.marshal(entry.Metadata)
+	}
+
+	if claims, ok := ctx.Value("user").(jwt.MapClaims); ok {
+		if claims["sub_type"] == models.SubTypeService {
+			event.ActorType = models.AuditActorService
+			event.ActorID, _ = claims["sub"].(string)
+		} else {
+			event.ActorType = models.AuditActorUser
+			event.ActorID, _ = claims["userID"].(string)
+		}
+		if act, ok := claims["act"].(map[string]any); ok {
+			event.ImpersonatorID, _ = act["sub"].(string)
+		}
+	} else if entry.ActorID != "" {
+		event.ActorType = models.AuditActorUser
+	}
+
+	if err := s.repo.Append(ctx, event); err != nil {
+		s.log.Error("[Service][Audit][Record] failed to record event", zap.String("action", entry.Action),
+			zap.String("actorID", event.ActorID), zap.String("targetID", entry.TargetID), zap.Error(err))
+	}
+}
+
+func (s *auditService) marshal(value any) json.RawMessage {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Map && reflect.ValueOf(value).Len() == 0) {
+		return nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		s.log.Error("[Service][Audit] failed to encode value", zap.Error(err))
+		return nil
+	}
+	return raw
+}
+
+func (s *auditService) List(ctx context.Context, filter models.AuditFilter, params util.PaginationParams) ([]*models.AuditEvent, int64, error) {
+	events, total, err := s.repo.List(ctx, filter, params)
+	if err != nil {
+		s.log.Error("[Service][Audit][List] failed to list events", zap.Error(err))
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (s *auditService) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	res := &models.AuditVerification{Valid: true}
+
+	var lastID int64
+	prevHash := ""
+	for {
+		events, err := s.repo.Chain(ctx, lastID, auditVerifyBatch)
+		if err != nil {
+			s.log.Error("[Service][Audit][Verify] failed to read events", zap.Error(err))
+			return nil, err
+		}
+
+		for _, event := range events {
+			if event.PrevHash != prevHash || event.ComputeHash(prevHash) != event.Hash {
+				s.log.Warn("[Service][Audit][Verify] hash chain broken", zap.Int64("eventID", event.ID))
+				res.Valid = false
+				res.BrokenAt = event.ID
+				return res, nil
+			}
+			res.Checked++
+			prevHash = event.Hash
+			lastID = event.ID
+		}
+
+		if len(events) < auditVerifyBatch {
+			return res, nil
+		}
+	}
+}
+
+// auditDiff keeps the fields that changed between two states of a resource
+func auditDiff(before, after map[string]any) (map[string]any, map[string]any) {
+	changedBefore := make(map[string]any)
+	changedAfter := make(map[string]any)
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changedBefore[key] = before[key]
+			changedAfter[key] = value
+		}
+	}
+	return changedBefore, changedAfter
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+// memoryAuditRepository chains events the way the postgres repository does
+type memoryAuditRepository struct {
+	repository.AuditRepository
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+func (r *memoryAuditRepository) Append(_ context.Context, event *models.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prevHash := ""
+	if len(r.events) > 0 {
+		prevHash = r.events[len(r.events)-1].Hash
+	}
+	event.PrevHash = prevHash
+	event.Hash = event.ComputeHash(prevHash)
+	event.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *memoryAuditRepository) Chain(_ context.Context, afterID int64, limit int) ([]*models.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []*models.AuditEvent
+	for _, event := range r.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func TestAuditVerify(t *testing.T) {
+	tests := []struct {
+		name         string
+		events       int
+		tamper       func(events []*models.AuditEvent) []*models.AuditEvent
+		wantChecked  int64
+		wantBrokenAt int64
+	}{
+		{
+			name:        "empty log",
+			wantChecked: 0,
+		},
+		{
+			name:        "intact chain",
+			events:      5,
+			wantChecked: 5,
+		},
+		{
+			name:        "intact chain over several batches",
+			events:      auditVerifyBatch*2 + 3,
+			wantChecked: auditVerifyBatch*2 + 3,
+		},
+		{
+			name:   "edited field",
+			events: 5,
+			tamper: func(events []*models.AuditEvent) []*models.AuditEvent {
+				events[2].TargetID = "someone-else"
+				return events
+			},
+			wantChecked:  2,
+			wantBrokenAt: 3,
+		},
+		{
+			name:   "edited field with a recomputed hash",
+			events: 5,
+			tamper: func(events []*models.AuditEvent) []*models.AuditEvent {
+				events[2].Metadata = json.RawMessage(`{"reason":"covered up"}`)
+				events[2].Hash = events[2].ComputeHash(events[2].PrevHash)
+				return events
+			},
+			wantChecked:  3,
+			wantBrokenAt: 4,
+		},
+		{
+			name:   "removed event",
+			events: 5,
+			tamper: func(events []*models.AuditEvent) []*models.AuditEvent {
+				return append(events[:1], events[2:]...)
+			},
+			wantChecked:  1,
+			wantBrokenAt: 3,
+		},
+		{
+			name:   "removed first event",
+			events: 3,
+			tamper: func(events []*models.AuditEvent) []*models.AuditEvent {
+				return events[1:]
+			},
+			wantChecked:  0,
+			wantBrokenAt: 2,
+		},
+		{
+			name:   "edited event in a later batch",
+			events: auditVerifyBatch + 10,
+			tamper: func(events []*models.AuditEvent) []*models.AuditEvent {
+				events[auditVerifyBatch+4].Action = models.AuditLogin
+				return events
+			},
+			wantChecked:  auditVerifyBatch + 4,
+			wantBrokenAt: auditVerifyBatch + 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryAuditRepository{}
+			audit := NewAuditService(repo, zap.NewNop())
+			for i := 0; i < tt.events; i++ {
+				audit.Record(context.Background(), models.AuditEntry{
+					Action:     models.AuditUserUpdate,
+					TargetType: models.AuditTargetUser,
+					TargetID:   "user-1",
+					ActorID:    "admin-1",
+					Before:     map[string]any{"status": "active"},
+					After:      map[string]any{"status": "inactive"},
+					Metadata:   map[string]string{"reason": "test"},
+				})
+			}
+			if tt.tamper != nil {
+				repo.events = tt.tamper(repo.events)
+			}
+
+			res, err := audit.Verify(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Valid != (tt.wantBrokenAt == 0) || res.Checked != tt.wantChecked || res.BrokenAt != tt.wantBrokenAt {
+				t.Errorf("Verify = %+v, want valid %v, checked %d, broken at %d", *res, tt.wantBrokenAt == 0,
+					tt.wantChecked, tt.wantBrokenAt)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"time"
+	"user_service/internal/events"
 	"user_service/internal/models"
 	"user_service/internal/repository"
 	"user_service/internal/repository/postgres"
@@ -13,18 +14,31 @@ import (
 )
 
 type authService struct {
-	userRepo   repository.UserRepository
-	authRepo   repository.AuthRepository
-	jwtService *util.JwtImpl
-	log        *zap.Logger
+	userRepo         repository.UserRepository
+	authRepo         repository.AuthRepository
+	jwtService       *util.JwtImpl
+	log              *zap.Logger
+	events           events.Publisher
+	revocations      repository.RevocationStore
+	audit            AuditService
+	loginHistory     LoginHistoryService
+	suspiciousLogins SuspiciousLoginService
 }
 
-func NewAuthService(userRepo repository.UserRepository, jwtService *util.JwtImpl, log *zap.Logger, authRepo repository.AuthRepository) *authService {
-	return &authService{userRepo: userRepo, jwtService: jwtService, log: log, authRepo: authRepo}
+func NewAuthService(userRepo repository.UserRepository, jwtService *util.JwtImpl, log *zap.Logger, authRepo repository.AuthRepository,
+	events events.Publisher, revocations repository.RevocationStore, audit AuditService, loginHistory LoginHistoryService,
+	suspiciousLogins SuspiciousLoginService) *authService {
+	return &authService{userRepo: userRepo, jwtService: jwtService, log: log, authRepo: authRepo, events: events, revocations: revocations,
+		audit: audit, loginHistory: loginHistory, suspiciousLogins: suspiciousLogins}
 }
 
 var (
 	ErrExpiredToken = errors.New("expired token")
+	ErrTokenReused  = errors.New("refresh token reused")
+	// ErrSessionNotFound is also returned for sessions of other users so session ids cannot be probed
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrStepUpRequired refuses a suspicious login until the user confirmed it through the link mailed to them
+	ErrStepUpRequired = errors.New("login confirmation required")
 )
 
 func (s *authService) Login(ctx context.Context, req models.LoginRequest) (*models.LoginResponse, error) {
@@ -41,31 +55,40 @@ func (s *authService) Login(ctx context.Context, req models.LoginRequest) (*mode
 
 	// Compare password
 
+	return s.IssueTokens(ctx, user, models.LoginMethodPassword)
+}
+
+// IssueTokens creates an access/refresh token pair for an already authenticated user and stores the refresh token.
+// A suspicious login may have to be confirmed first or sign the user out everywhere else, see SuspiciousLoginService.
+func (s *authService) IssueTokens(ctx context.Context, user *models.User, method string) (*models.LoginResponse, error) {
+	assessment := s.suspiciousLogins.Check(ctx, user, method)
+	if assessment.StepUp {
+		s.loginHistory.RecordFailure(ctx, user.ID, user.Email, method, models.LoginFailureStepUpRequired)
+		return nil, ErrStepUpRequired
+	}
+	if assessment.RevokeSessions {
+		if err := s.LogoutAll(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Generate tokens
 	accessToken, err := s.jwtService.GenerateAccessToken(user.ID, user.Role)
 	if err != nil {
-		s.log.Error("[AuthService][Login] failed to generate access token", zap.Error(err))
+		s.log.Error("[AuthService][IssueTokens] failed to generate access token", zap.Error(err))
 		return nil, err
 	}
 
 	// Generate refresh token
 	refreshToken, err := s.jwtService.GenerateRefreshToken(user.ID, user.Role)
 	if err != nil {
-		s.log.Error("[AuthService][Login] failed to generate refresh token", zap.Error(err))
+		s.log.Error("[AuthService][IssueTokens] failed to generate refresh token", zap.Error(err))
 		return nil, err
 	}
 
 	// Store refresh token
-	err = s.authRepo.Create(ctx, &models.RefreshTokenData{
-		ID:        uuid.New().String(),
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now(),
-		IssuedAt:  time.Now().Add(24 * time.Hour),
-		IsRevoked: false,
-	})
-	if err != nil {
-		s.log.Error("[AuthService][Login] failed to store refresh token", zap.Error(err))
+	if err := s.SaveToken(ctx, refreshToken, user.ID); err != nil {
+		s.log.Error("[AuthService][IssueTokens] failed to store refresh token", zap.Error(err))
 		return nil, err
 	}
 
@@ -73,52 +96,92 @@ func (s *authService) Login(ctx context.Context, req models.LoginRequest) (*mode
 	loginResponse := &models.LoginResponse{
 		RefreshToken: refreshToken,
 		Token:        accessToken,
-		User: &models.UserSummary{
-			Id:    user.ID,
-			Name:  user.FullName,
-			Email: user.Email,
-			Role:  user.Role,
-		},
+		User:         models.NewUserSummary(user),
 	}
 
+	s.loginHistory.RecordSuccess(ctx, user, method, assessment.Reasons)
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditLogin, TargetType: models.AuditTargetUser, TargetID: user.ID, ActorID: user.ID,
+		Metadata: map[string]string{"method": method}})
 	return loginResponse, nil
 }
 
 func (s *authService) RefreshToken(ctx context.Context, token string) (string, string, error) {
-	claims, err := s.jwtService.ValidateRefreshToken(token)
-	if err != nil {
+	if _, err := s.jwtService.ValidateRefreshToken(token); err != nil {
 		s.log.Error("[AuthService][RefreshToken] failed to validate refresh token", zap.Error(err))
-		return "", "", err
+		return "", "", ErrExpiredToken
 	}
 
 	storedToken, err := s.authRepo.GetByToken(ctx, token)
 	if err != nil {
 		s.log.Error("[AuthService][RefreshToken] failed to get refresh token", zap.Error(err))
+		if errors.Is(err, postgres.ErrInvalidToken) || errors.Is(err, postgres.ErrExpiredToken) {
+			return "", "", ErrExpiredToken
+		}
 		return "", "", err
 	}
 
+	// a token that was already exchanged is presented again: either the client or an attacker holds a stolen copy
+	if storedToken.RotatedAt != nil {
+		return "", "", s.handleTokenReuse(ctx, storedToken)
+	}
+
 	if storedToken.IsRevoked {
 		return "", "", ErrExpiredToken
 	}
 
-	if err := s.authRepo.RevokeToken(ctx, token); err != nil {
+	// the account may have been deactivated, deleted or had its credentials changed since the token was issued,
+	// and its role may have changed
+	user, err := s.userRepo.GetByID(ctx, storedToken.UserID)
+	if err != nil {
+		s.log.Error("[AuthService][RefreshToken] failed to get user", zap.Error(err))
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return "", "", ErrExpiredToken
+		}
+		return "", "", err
+	}
+	if user.Status == models.StatusInactive {
+		s.log.Info("[AuthService][RefreshToken] refresh refused, user inactive", zap.String("userID", user.ID))
+		return "", "", ErrExpiredToken
+	}
+	validAfter, err := s.revocations.ValidAfter(ctx, user.ID)
+	if err != nil {
+		s.log.Error("[AuthService][RefreshToken] failed to get token validity", zap.Error(err))
+		return "", "", err
+	}
+	if storedToken.IssuedAt.Before(validAfter) {
+		return "", "", ErrExpiredToken
+	}
+
+	if err := s.authRepo.RotateToken(ctx, token); err != nil {
+		if errors.Is(err, postgres.ErrTokenUsed) {
+			// lost a race against another refresh with the same token
+			return "", "", s.handleTokenReuse(ctx, storedToken)
+		}
 		s.log.Error("[AuthService][RefreshToken] failed to revoke token", zap.Error(err))
 		return "", "", err
 	}
 
-	refreshToken, err := s.jwtService.GenerateRefreshToken(claims["userID"].(string), claims["role"].(string))
+	refreshToken, err := s.jwtService.GenerateRefreshToken(user.ID, user.Role)
 	if err != nil {
 		s.log.Error("[AuthService][RefreshToken] failed to generate refresh token", zap.Error(err))
 		return "", "", err
 	}
 
+	// the session keeps its device and start time, the IP is the one the client refreshes from
+	client := util.ClientInfoFromContext(ctx)
 	err = s.authRepo.Create(ctx, &models.RefreshTokenData{
-		ID:        uuid.New().String(),
-		UserID:    claims["userID"].(string),
-		Token:     refreshToken,
-		ExpiresAt: time.Now(),
-		IssuedAt:  time.Now().Add(24 * time.Hour),
-		IsRevoked: false,
+		ID:          uuid.New().String(),
+		UserID:      storedToken.UserID,
+		Token:       refreshToken,
+		FamilyID:    storedToken.FamilyID,
+		ExpiresAt:   time.Now().Add(24 * time.Hour),
+		IssuedAt:    time.Now(),
+		IsRevoked:   false,
+		UserAgent:   storedToken.UserAgent,
+		IPAddress:   client.IPAddress,
+		DeviceLabel: storedToken.DeviceLabel,
+		CreatedAt:   storedToken.CreatedAt,
+		LastUsedAt:  time.Now(),
 	})
 
 	if err != nil {
@@ -127,7 +190,7 @@ func (s *authService) RefreshToken(ctx context.Context, token string) (string, s
 	}
 
 	// Generate new access token
-	accessToken, err := s.jwtService.GenerateAccessToken(claims["userID"].(string), claims["role"].(string))
+	accessToken, err := s.jwtService.GenerateAccessToken(user.ID, user.Role)
 	if err != nil {
 		s.log.Error("[AuthService][RefreshToken] failed to generate access token", zap.Error(err))
 		return "", "", err
@@ -136,6 +199,31 @@ func (s *authService) RefreshToken(ctx context.Context, token string) (string, s
 	return accessToken, refreshToken, nil
 }
 
+// handleTokenReuse revokes every token of the family so neither the legitimate client nor the attacker can keep refreshing
+func (s *authService) handleTokenReuse(ctx context.Context, token *models.RefreshTokenData) error {
+	s.log.Warn("[AuthService][RefreshToken] refresh token reuse detected",
+		zap.String("userID", token.UserID), zap.String("familyID", token.FamilyID))
+
+	if err := s.authRepo.RevokeFamily(ctx, token.FamilyID); err != nil {
+		s.log.Error("[AuthService][RefreshToken] failed to revoke token family", zap.Error(err))
+		return err
+	}
+
+	s.events.Publish(ctx, events.Event{
+		Type:     events.TypeRefreshTokenReuse,
+		Severity: events.SeverityCritical,
+		UserID:   token.UserID,
+		Metadata: map[string]string{
+			"familyID": token.FamilyID,
+			"tokenID":  token.ID,
+		},
+	})
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditRefreshTokenReuse, TargetType: models.AuditTargetSession,
+		TargetID: token.FamilyID, Metadata: map[string]string{"userID": token.UserID, "tokenID": token.ID}})
+
+	return ErrTokenReused
+}
+
 func (s *authService) Logout(ctx context.Context, token string) error {
 	err := s.authRepo.RevokeToken(ctx, token)
 	if err != nil {
@@ -143,17 +231,32 @@ func (s *authService) Logout(ctx context.Context, token string) error {
 		return err
 	}
 
+	// logout is not authenticated, the refresh token names the user
+	entry := models.AuditEntry{Action: models.AuditLogout, TargetType: models.AuditTargetUser}
+	if claims, err := s.jwtService.ValidateRefreshToken(token); err == nil {
+		entry.TargetID, _ = claims["userID"].(string)
+		entry.ActorID = entry.TargetID
+	}
+	s.audit.Record(ctx, entry)
 	return nil
 }
 
 func (s *authService) SaveToken(ctx context.Context, token string, userID string) error {
+	client := util.ClientInfoFromContext(ctx)
+	now := time.Now()
 	err := s.authRepo.Create(ctx, &models.RefreshTokenData{
-		ID:        uuid.New().String(),
-		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
-		IssuedAt:  time.Now(),
-		UserID:    userID,
-		IsRevoked: false,
+		ID:          uuid.New().String(),
+		Token:       token,
+		FamilyID:    uuid.New().String(), // every login starts a new token family
+		ExpiresAt:   now.Add(24 * time.Hour),
+		IssuedAt:    now,
+		UserID:      userID,
+		IsRevoked:   false,
+		UserAgent:   client.UserAgent,
+		IPAddress:   client.IPAddress,
+		DeviceLabel: client.DeviceLabel,
+		CreatedAt:   now,
+		LastUsedAt:  now,
 	})
 
 	if err != nil {
@@ -170,5 +273,77 @@ func (s *authService) LogoutAll(ctx context.Context, userID string) error {
 		s.log.Error("[AuthService][LogoutAll] failed to revoke all tokens", zap.Error(err))
 		return err
 	}
+
+	if err := s.revocations.SetValidAfter(ctx, userID, time.Now()); err != nil {
+		s.log.Error("[AuthService][LogoutAll] failed to revoke access tokens", zap.Error(err))
+		return err
+	}
+
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditLogoutAll, TargetType: models.AuditTargetUser, TargetID: userID})
+	return nil
+}
+
+// RevokeAccessToken denylists an access token until it expires. Invalid or expired tokens are ignored,
+// they are rejected by AuthMiddleware anyway.
+func (s *authService) RevokeAccessToken(ctx context.Context, accessToken string) error {
+	claims, err := s.jwtService.ValidateAccessToken(accessToken)
+	if err != nil {
+		return nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if jti == "" || err != nil || expiresAt == nil {
+		return nil
+	}
+
+	if err := s.revocations.RevokeJTI(ctx, jti, expiresAt.Time); err != nil {
+		s.log.Error("[AuthService][RevokeAccessToken] failed to revoke access token", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first. A session is a refresh token family.
+func (s *authService) ListSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	tokens, err := s.authRepo.ListActive(ctx, userID)
+	if err != nil {
+		s.log.Error("[AuthService][ListSessions] failed to list refresh tokens", zap.Error(err))
+		return nil, err
+	}
+
+	sessions := make([]*models.Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, &models.Session{
+			ID:          token.FamilyID,
+			DeviceLabel: token.DeviceLabel,
+			UserAgent:   token.UserAgent,
+			IPAddress:   token.IPAddress,
+			CreatedAt:   token.CreatedAt,
+			LastUsedAt:  token.LastUsedAt,
+			ExpiresAt:   token.ExpiresAt,
+		})
+	}
+
+	return sessions, nil
+}
+
+func (s *authService) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	err := s.authRepo.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrSessionNotFound) {
+			return ErrSessionNotFound
+		}
+		s.log.Error("[AuthService][RevokeSession] failed to revoke session", zap.Error(err))
+		return err
+	}
+
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditSessionRevoke, TargetType: models.AuditTargetSession, TargetID: sessionID,
+		Metadata: map[string]string{"userID": userID}})
 	return nil
 }
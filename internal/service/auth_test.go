@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"user_service/internal/events"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/memory"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+)
+
+// memoryAuthRepository keeps refresh tokens by digest and follows the semantics of the postgres repository
+type memoryAuthRepository struct {
+	repository.AuthRepository
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshTokenData
+	// beforeRotate runs before a token is rotated, to let another request win the race
+	beforeRotate func(token string)
+}
+
+func (r *memoryAuthRepository) Create(_ context.Context, token *models.RefreshTokenData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *token
+	stored.TokenHash = util.HashToken(token.Token)
+	stored.Token = ""
+	r.tokens[stored.TokenHash] = &stored
+	return nil
+}
+
+func (r *memoryAuthRepository) GetByToken(_ context.Context, token string) (*models.RefreshTokenData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.tokens[util.HashToken(token)]
+	if !ok {
+		return nil, postgres.ErrInvalidToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, postgres.ErrExpiredToken
+	}
+	found := *stored
+	found.Token = token
+	return &found, nil
+}
+
+func (r *memoryAuthRepository) RotateToken(_ context.Context, token string) error {
+	if r.beforeRotate != nil {
+		r.beforeRotate(token)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.tokens[util.HashToken(token)]
+	if !ok || stored.IsRevoked {
+		return postgres.ErrTokenUsed
+	}
+	now := time.Now()
+	stored.IsRevoked, stored.RotatedAt = true, &now
+	return nil
+}
+
+func (r *memoryAuthRepository) RevokeFamily(_ context.Context, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stored := range r.tokens {
+		if stored.FamilyID == familyID {
+			stored.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryAuthRepository) RevokeAllTokens(_ context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stored := range r.tokens {
+		if stored.UserID == userID {
+			stored.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryAuthRepository) get(token string) *models.RefreshTokenData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[util.HashToken(token)]
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	users map[string]*models.User
+}
+
+func (r *fakeUserRepo) GetByID(_ context.Context, id string) (*models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, postgres.ErrUserNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event events.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *recordingPublisher) types() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	types := make([]string, 0, len(p.events))
+	for _, event := range p.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// recordingAudit keeps the actions of the recorded entries
+type recordingAudit struct {
+	AuditService
+	mu      sync.Mutex
+	actions []string
+}
+
+func (a *recordingAudit) Record(_ context.Context, entry models.AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.actions = append(a.actions, entry.Action)
+}
+
+type nopLoginHistory struct{ LoginHistoryService }
+
+func (nopLoginHistory) RecordSuccess(context.Context, *models.User, string, []string) {}
+
+type trustingSuspiciousLogins struct{}
+
+func (trustingSuspiciousLogins) Check(context.Context, *models.User, string) *models.LoginAssessment {
+	return &models.LoginAssessment{}
+}
+
+type refreshTestEnv struct {
+	svc         *authService
+	jwt         *util.JwtImpl
+	tokens      *memoryAuthRepository
+	users       *fakeUserRepo
+	revocations repository.RevocationStore
+	events      *recordingPublisher
+	audit       *recordingAudit
+}
+
+func newRefreshTestEnv(t *testing.T) *refreshTestEnv {
+	t.Helper()
+	t.Setenv("REFRESH_SECRET_KEY", "refresh-test-secret")
+
+	keys, err := util.NewEphemeralKeyStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &refreshTestEnv{
+		jwt:    util.NewJwtImpl(keys, "https://auth.example.test"),
+		tokens: &memoryAuthRepository{tokens: make(map[string]*models.RefreshTokenData)},
+		users: &fakeUserRepo{users: map[string]*models.User{
+			"user-1": {ID: "user-1", Email: "user@example.test", Role: models.RoleUser, Status: models.StatusActive},
+		}},
+		revocations: memory.NewRevocationStore(),
+		events:      &recordingPublisher{},
+		audit:       &recordingAudit{},
+	}
+	env.svc = NewAuthService(env.users, env.jwt, zap.NewNop(), env.tokens, env.events, env.revocations,
+		env.audit, nopLoginHistory{}, trustingSuspiciousLogins{})
+	return env
+}
+
+// login issues the first token of a new family
+func (env *refreshTestEnv) login(t *testing.T) string {
+	t.Helper()
+	res, err := env.svc.IssueTokens(context.Background(), env.users.users["user-1"], models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.RefreshToken
+}
+
+func (env *refreshTestEnv) refresh(t *testing.T, token string) string {
+	t.Helper()
+	_, next, err := env.svc.RefreshToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	return next
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	env := newRefreshTestEnv(t)
+	first := env.login(t)
+	family := env.tokens.get(first).FamilyID
+
+	// the role is read from the database on every refresh, not copied from the token
+	env.users.users["user-1"].Role = models.RoleAdmin
+
+	current := first
+	seen := map[string]bool{first: true}
+	for i := 0; i < 3; i++ {
+		access, next, err := env.svc.RefreshToken(context.Background(), current)
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+		if seen[next] {
+			t.Fatalf("refresh %d returned a token that was issued before", i)
+		}
+		seen[next] = true
+
+		claims, err := env.jwt.ValidateAccessToken(access)
+		if err != nil {
+			t.Fatalf("refresh %d: access token: %v", i, err)
+		}
+		if claims["role"] != models.RoleAdmin {
+			t.Errorf("refresh %d: access token role = %v, want %s", i, claims["role"], models.RoleAdmin)
+		}
+
+		old, stored := env.tokens.get(current), env.tokens.get(next)
+		if !old.IsRevoked || old.RotatedAt == nil {
+			t.Errorf("refresh %d: exchanged token is not marked rotated", i)
+		}
+		if stored == nil || stored.IsRevoked || stored.FamilyID != family {
+			t.Errorf("refresh %d: new token %+v is not an active token of family %s", i, stored, family)
+		}
+		current = next
+	}
+
+	if types := env.events.types(); len(types) != 0 {
+		t.Errorf("rotation published %v", types)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup returns the token to present
+		setup   func(t *testing.T, env *refreshTestEnv) string
+		wantErr error
+		// wantReuse expects the family revoked, a critical event and an audit entry
+		wantReuse bool
+	}{
+		{
+			name:  "fresh token",
+			setup: func(t *testing.T, env *refreshTestEnv) string { return env.login(t) },
+		},
+		{
+			name: "exchanged token presented again",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				first := env.login(t)
+				env.refresh(t, first)
+				return first
+			},
+			wantErr:   ErrTokenReused,
+			wantReuse: true,
+		},
+		{
+			name: "older token of the family presented again",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				first := env.login(t)
+				env.refresh(t, env.refresh(t, first))
+				return first
+			},
+			wantErr:   ErrTokenReused,
+			wantReuse: true,
+		},
+		{
+			name: "latest token after the family was revoked for reuse",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				first := env.login(t)
+				next := env.refresh(t, first)
+				if _, _, err := env.svc.RefreshToken(context.Background(), first); !errors.Is(err, ErrTokenReused) {
+					t.Fatalf("reuse: err = %v, want ErrTokenReused", err)
+				}
+				env.events.events, env.audit.actions = nil, nil
+				return next
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "concurrent refresh lost the race",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				env.tokens.beforeRotate = func(token string) {
+					env.tokens.beforeRotate = nil
+					if err := env.tokens.RotateToken(context.Background(), token); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return token
+			},
+			wantErr:   ErrTokenReused,
+			wantReuse: true,
+		},
+		{
+			name: "token of another session is unaffected by reuse",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				other := env.login(t)
+				first := env.login(t)
+				env.refresh(t, first)
+				if _, _, err := env.svc.RefreshToken(context.Background(), first); !errors.Is(err, ErrTokenReused) {
+					t.Fatalf("reuse: err = %v, want ErrTokenReused", err)
+				}
+				env.events.events, env.audit.actions = nil, nil
+				return other
+			},
+		},
+		{
+			name: "signed out everywhere",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				if err := env.svc.LogoutAll(context.Background(), "user-1"); err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "issued before the tokens of the user were invalidated",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				if err := env.revocations.SetValidAfter(context.Background(), "user-1", time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "user deactivated",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				env.users.users["user-1"].Status = models.StatusInactive
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "user deleted",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				delete(env.users.users, "user-1")
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "stored token expired",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token := env.login(t)
+				env.tokens.get(token).ExpiresAt = time.Now().Add(-time.Minute)
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "validly signed but never stored",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				token, err := env.jwt.GenerateRefreshToken("user-1", models.RoleAdmin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "signed with another secret",
+			setup: func(t *testing.T, env *refreshTestEnv) string {
+				env.login(t)
+				t.Setenv("REFRESH_SECRET_KEY", "another-secret")
+				token, err := env.jwt.GenerateRefreshToken("user-1", models.RoleAdmin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("REFRESH_SECRET_KEY", "refresh-test-secret")
+				return token
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:    "not a token",
+			setup:   func(t *testing.T, env *refreshTestEnv) string { return "not-a-token" },
+			wantErr: ErrExpiredToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newRefreshTestEnv(t)
+			token := tt.setup(t, env)
+			var family string
+			if stored := env.tokens.get(token); stored != nil {
+				family = stored.FamilyID
+			}
+
+			access, next, err := env.svc.RefreshToken(context.Background(), token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (access == "" || next == "" || next == token) {
+				t.Errorf("refresh returned access %q and refresh %q", access, next)
+			}
+			if err != nil && (access != "" || next != "") {
+				t.Errorf("failed refresh returned tokens")
+			}
+
+			familyActive := false
+			for _, stored := range env.tokens.tokens {
+				if stored.FamilyID == family && !stored.IsRevoked {
+					familyActive = true
+				}
+			}
+			if tt.wantReuse && familyActive {
+				t.Error("token family is still active after reuse")
+			}
+
+			reuseEvent := slices.Contains(env.events.types(), events.TypeRefreshTokenReuse)
+			reuseAudit := slices.Contains(env.audit.actions, models.AuditRefreshTokenReuse)
+			if reuseEvent != tt.wantReuse || reuseAudit != tt.wantReuse {
+				t.Errorf("reuse event %v, audit %v, want %v", reuseEvent, reuseAudit, tt.wantReuse)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+	"user_service/pkg/mail"
+)
+
+type EmailVerificationService interface {
+	// Enabled reports whether new registrations have to verify their email
+	Enabled() bool
+	SendVerification(ctx context.Context, user *models.User) error
+	Verify(ctx context.Context, token string) (*models.User, error)
+	Resend(ctx context.Context, email string) error
+}
+
+type emailVerificationService struct {
+	userService UserService
+	tokenRepo   repository.OneTimeTokenRepository
+	mailer      mail.Sender
+	log         *zap.Logger
+	mode        string
+	verifyURL   string
+	tokenTTL    time.Duration
+}
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+)
+
+// NewEmailVerificationService creates the email verification flow. mode is one of the EmailVerification* constants
+// and verifyURL is the frontend page receiving the token as a query parameter.
+func NewEmailVerificationService(userService UserService, tokenRepo repository.OneTimeTokenRepository, mailer mail.Sender,
+	log *zap.Logger, mode string, verifyURL string, tokenTTL time.Duration) EmailVerificationService {
+	return &emailVerificationService{
+		userService: userService,
+		tokenRepo:   tokenRepo,
+		mailer:      mailer,
+		log:         log,
+		mode:        mode,
+		verifyURL:   verifyURL,
+		tokenTTL:    tokenTTL,
+	}
+}
+
+func (s *emailVerificationService) Enabled() bool {
+	return s.mode == EmailVerificationFlag || s.mode == EmailVerificationEnforce
+}
+
+func (s *emailVerificationService) SendVerification(ctx context.Context, user *models.User) error {
+	// only the most recent link stays valid
+	if err := s.tokenRepo.InvalidateByUserID(ctx, user.ID); err != nil {
+		s.log.Error("[Service][SendVerification] failed to invalidate previous tokens", zap.Error(err))
+		return err
+	}
+
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][SendVerification] failed to generate token", zap.Error(err))
+		return err
+	}
+
+	err = s.tokenRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    user.ID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(s.tokenTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][SendVerification] failed to store token", zap.Error(err))
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Xác thực địa chỉ email",
+		Body: fmt.Sprintf("Xin chào %s,\n\nVui lòng truy cập liên kết sau để xác thực email (hết hạn sau %s):\n%s?token=%s\n\nNếu bạn không đăng ký tài khoản, hãy bỏ qua email này.",
+			user.FullName, s.tokenTTL, s.verifyURL, token),
+	})
+	if err != nil {
+		s.log.Error("[Service][SendVerification] failed to send mail", zap.Error(err))
+		return ErrorSendingMail
+	}
+
+	return nil
+}
+
+func (s *emailVerificationService) Verify(ctx context.Context, token string) (*models.User, error) {
+	stored, err := s.tokenRepo.GetByHash(ctx, util.HashToken(token))
+	if err != nil {
+		s.log.Error("[Service][VerifyEmail] invalid verification token", zap.Error(err))
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if err := s.tokenRepo.MarkUsed(ctx, stored.ID); err != nil {
+		s.log.Error("[Service][VerifyEmail] failed to consume verification token", zap.Error(err))
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := s.userService.GetByID(ctx, stored.UserID)
+	if err != nil {
+		s.log.Error("[Service][VerifyEmail] failed to get user", zap.Error(err))
+		return nil, err
+	}
+
+	// an admin may have deactivated the account in the meantime, only pending accounts are activated
+	if user.Status != models.StatusPendingVerification {
+		return user, nil
+	}
+
+	user, err = s.userService.Update(ctx, user.ID, models.UpdateUserInput{Status: models.StatusActive})
+	if err != nil {
+		s.log.Error("[Service][VerifyEmail] failed to activate user", zap.Error(err))
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Resend mails a new verification link. Unknown or already verified emails are ignored so the endpoint
+// does not reveal which accounts exist.
+func (s *emailVerificationService) Resend(ctx context.Context, email string) error {
+	user, err := s.userService.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			s.log.Info("[Service][ResendVerification] resend requested for unknown email")
+			return nil
+		}
+		s.log.Error("[Service][ResendVerification] failed to get user", zap.Error(err))
+		return ErrorGetUser
+	}
+
+	if user.Status != models.StatusPendingVerification {
+		s.log.Info("[Service][ResendVerification] account is not pending verification", zap.String("userID", user.ID))
+		return nil
+	}
+
+	return s.SendVerification(ctx, user)
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+	"user_service/pkg/oidc"
+)
+
+// FederationService signs users in through external OpenID Connect providers. A provider identity is linked
+// to the user with the same verified email, or a new user is created for it.
+type FederationService interface {
+	// ListProviders returns every configured provider, or only the enabled ones
+	ListProviders(ctx context.Context, enabledOnly bool) ([]*models.IdentityProvider, error)
+	SaveProvider(ctx context.Context, id string, input models.SaveIdentityProviderInput) (*models.IdentityProvider, error)
+	DeleteProvider(ctx context.Context, id string) error
+	// StartLogin returns the URL of the provider's authorization endpoint to send the browser to
+	StartLogin(ctx context.Context, providerID string) (string, error)
+	// CompleteLogin redeems the code the provider redirected back with and returns the signed in user
+	CompleteLogin(ctx context.Context, providerID string, req models.ExternalLoginRequest) (*models.User, error)
+	ListIdentities(ctx context.Context, userID string) ([]*models.ExternalIdentity, error)
+	Unlink(ctx context.Context, userID string, identityID string) error
+}
+
+var (
+	ErrProviderNotFound         = errors.New("identity provider not found")
+	ErrIdentityNotFound         = errors.New("external identity not found")
+	ErrInvalidExternalLogin     = errors.New("invalid or expired external login")
+	ErrExternalEmailNotVerified = errors.New("identity provider did not return a verified email")
+	ErrExternalProviderFailed   = errors.New("identity provider request failed")
+)
+
+var defaultProviderScopes = []string{models.ScopeOpenID, models.ScopeEmail, models.ScopeProfile}
+
+type federationService struct {
+	providerRepo repository.IdentityProviderRepository
+	identityRepo repository.ExternalIdentityRepository
+	stateRepo    repository.ExternalLoginStateRepository
+	userService  UserService
+	oidc         *oidc.Client
+	log          *zap.Logger
+	callbackURL  string
+	stateTTL     time.Duration
+}
+
+// NewFederationService creates the external login flow. callbackURL is the redirect uri registered at every
+// provider, the frontend page there posts code and state to /auth/external/{provider}/callback. It may
+// contain {provider}, which is replaced by the provider id.
+func NewFederationService(providerRepo repository.IdentityProviderRepository, identityRepo repository.ExternalIdentityRepository,
+	stateRepo repository.ExternalLoginStateRepository, userService UserService, oidcClient *oidc.Client, log *zap.Logger,
+	callbackURL string, stateTTL time.Duration) FederationService {
+	return &federationService{
+		providerRepo: providerRepo,
+		identityRepo: identityRepo,
+		stateRepo:    stateRepo,
+		userService:  userService,
+		oidc:         oidcClient,
+		log:          log,
+		callbackURL:  callbackURL,
+		stateTTL:     stateTTL,
+	}
+}
+
+func (s *federationService) ListProviders(ctx context.Context, enabledOnly bool) ([]*models.IdentityProvider, error) {
+	providers, err := s.providerRepo.List(ctx)
+	if err != nil {
+		s.log.Error("[Service][Federation][ListProviders] failed to list providers", zap.Error(err))
+		return nil, err
+	}
+
+	if !enabledOnly {
+		return providers, nil
+	}
+
+	enabled := make([]*models.IdentityProvider, 0, len(providers))
+	for _, provider := range providers {
+		if provider.Enabled {
+			enabled = append(enabled, provider)
+		}
+	}
+	return enabled, nil
+}
+
+// SaveProvider creates or replaces a provider, an empty client secret keeps the stored one
+func (s *federationService) SaveProvider(ctx context.Context, id string, input models.SaveIdentityProviderInput) (*models.IdentityProvider, error) {
+	if err := models.ValidateProviderID(id); err != nil {
+		return nil, err
+	}
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	scopes := input.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultProviderScopes
+	}
+
+	provider := &models.IdentityProvider{
+		ID:           id,
+		Name:         strings.TrimSpace(input.Name),
+		Issuer:       input.Issuer,
+		ClientID:     input.ClientID,
+		ClientSecret: input.ClientSecret,
+		Scopes:       scopes,
+		Enabled:      input.Enabled,
+	}
+
+	if provider.ClientSecret == "" {
+		existing, err := s.providerRepo.GetByID(ctx, id)
+		if err != nil && !errors.Is(err, postgres.ErrProviderNotFound) {
+			s.log.Error("[Service][Federation][SaveProvider] failed to get provider", zap.Error(err))
+			return nil, err
+		}
+		if existing != nil {
+			provider.ClientSecret = existing.ClientSecret
+		}
+	}
+
+	if err := s.providerRepo.Upsert(ctx, provider); err != nil {
+		s.log.Error("[Service][Federation][SaveProvider] failed to save provider", zap.Error(err))
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+func (s *federationService) DeleteProvider(ctx context.Context, id string) error {
+	if err := s.providerRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, postgres.ErrProviderNotFound) {
+			return ErrProviderNotFound
+		}
+		s.log.Error("[Service][Federation][DeleteProvider] failed to delete provider", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *federationService) StartLogin(ctx context.Context, providerID string) (string, error) {
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := s.oidc.Discover(ctx, provider.Issuer)
+	if err != nil {
+		s.log.Error("[Service][Federation][StartLogin] provider discovery failed", zap.String("provider", providerID), zap.Error(err))
+		return "", ErrExternalProviderFailed
+	}
+
+	state, err := util.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := util.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := util.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.stateRepo.Create(ctx, &models.ExternalLoginState{
+		StateHash:    util.HashToken(state),
+		ProviderID:   provider.ID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(s.stateTTL),
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][Federation][StartLogin] failed to store login state", zap.Error(err))
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return metadata.AuthCodeURL(provider.ClientID, s.redirectURI(provider.ID), strings.Join(provider.Scopes, " "),
+		state, nonce, challenge), nil
+}
+
+func (s *federationService) CompleteLogin(ctx context.Context, providerID string, req models.ExternalLoginRequest) (*models.User, error) {
+	provider, err := s.enabledProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.stateRepo.Consume(ctx, util.HashToken(req.State))
+	if err != nil {
+		if errors.Is(err, postgres.ErrInvalidToken) {
+			return nil, ErrInvalidExternalLogin
+		}
+		s.log.Error("[Service][Federation][CompleteLogin] failed to consume login state", zap.Error(err))
+		return nil, err
+	}
+	// a state started for another provider must not be redeemed here
+	if state.ProviderID != provider.ID {
+		return nil, ErrInvalidExternalLogin
+	}
+
+	metadata, err := s.oidc.Discover(ctx, provider.Issuer)
+	if err != nil {
+		s.log.Error("[Service][Federation][CompleteLogin] provider discovery failed", zap.String("provider", providerID), zap.Error(err))
+		return nil, ErrExternalProviderFailed
+	}
+
+	idToken, err := s.oidc.Exchange(ctx, metadata, provider.ClientID, provider.ClientSecret, req.Code, s.redirectURI(provider.ID), state.CodeVerifier)
+	if err != nil {
+		s.log.Error("[Service][Federation][CompleteLogin] code exchange failed", zap.String("provider", providerID), zap.Error(err))
+		return nil, ErrInvalidExternalLogin
+	}
+
+	claims, err := s.oidc.VerifyIDToken(ctx, provider.Issuer, idToken, provider.ClientID, state.Nonce)
+	if err != nil {
+		s.log.Error("[Service][Federation][CompleteLogin] invalid id token", zap.String("provider", providerID), zap.Error(err))
+		return nil, ErrInvalidExternalLogin
+	}
+
+	user, err := s.resolveUser(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == models.StatusInactive {
+		return nil, ErrAccountInactive
+	}
+
+	return user, nil
+}
+
+// resolveUser returns the user linked to the provider identity. Unknown identities are linked to the user with
+// the same email, or get a new user, but only when the provider verified the email.
+func (s *federationService) resolveUser(ctx context.Context, provider *models.IdentityProvider, claims *oidc.Claims) (*models.User, error) {
+	identity, err := s.identityRepo.GetBySubject(ctx, provider.ID, claims.Subject)
+	if err == nil {
+		if err := s.identityRepo.TouchLogin(ctx, identity.ID); err != nil {
+			s.log.Error("[Service][Federation][CompleteLogin] failed to update last login", zap.Error(err))
+		}
+		return s.userService.GetByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, postgres.ErrIdentityNotFound) {
+		s.log.Error("[Service][Federation][CompleteLogin] failed to get identity", zap.Error(err))
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrExternalEmailNotVerified
+	}
+
+	user, err := s.userService.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if user, err = s.userService.ClaimPendingAccount(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		s.log.Info("[Service][Federation] identity linked to existing user", zap.String("provider", provider.ID), zap.String("userID", user.ID))
+	case errors.Is(err, ErrUserNotFound):
+		if user, err = s.userService.CreateExternal(ctx, claims.Email, claims.Name); err != nil {
+			return nil, err
+		}
+		s.log.Info("[Service][Federation] user created for identity", zap.String("provider", provider.ID), zap.String("userID", user.ID))
+	default:
+		return nil, err
+	}
+
+	err = s.identityRepo.Create(ctx, &models.ExternalIdentity{
+		UserID:     user.ID,
+		ProviderID: provider.ID,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+	})
+	if err != nil {
+		s.log.Error("[Service][Federation][CompleteLogin] failed to link identity", zap.Error(err))
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *federationService) ListIdentities(ctx context.Context, userID string) ([]*models.ExternalIdentity, error) {
+	identities, err := s.identityRepo.ListByUser(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][Federation][ListIdentities] failed to list identities", zap.Error(err))
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Unlink removes the link, the user can still sign in with a password or set one through the password reset
+func (s *federationService) Unlink(ctx context.Context, userID string, identityID string) error {
+	if _, err := uuid.Parse(identityID); err != nil {
+		return ErrIdentityNotFound
+	}
+
+	if err := s.identityRepo.Delete(ctx, userID, identityID); err != nil {
+		if errors.Is(err, postgres.ErrIdentityNotFound) {
+			return ErrIdentityNotFound
+		}
+		s.log.Error("[Service][Federation][Unlink] failed to unlink identity", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *federationService) enabledProvider(ctx context.Context, id string) (*models.IdentityProvider, error) {
+	provider, err := s.providerRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, postgres.ErrProviderNotFound) {
+			return nil, ErrProviderNotFound
+		}
+		s.log.Error("[Service][Federation] failed to get provider", zap.Error(err))
+		return nil, err
+	}
+
+	if !provider.Enabled {
+		return nil, ErrProviderNotFound
+	}
+	return provider, nil
+}
+
+func (s *federationService) redirectURI(providerID string) string {
+	return strings.ReplaceAll(s.callbackURL, "{provider}", providerID)
+}
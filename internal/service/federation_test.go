@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/pkg/oidc"
+)
+
+const (
+	stubClientID     = "user-service"
+	stubClientSecret = "secret"
+)
+
+// stubProvider is an OpenID Connect provider serving discovery, JWKS and the token endpoint. The tests
+// play the browser: they read nonce and PKCE challenge from the authorization URL and register a code.
+type stubProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	// discoveryIssuer and tokenIssuer default to the server URL, tokenNonce to the nonce of the request
+	discoveryIssuer string
+	tokenIssuer     string
+	tokenNonce      string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &stubProvider{t: t, key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *stubProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.URL
+	if p.discoveryIssuer != "" {
+		issuer = p.discoveryIssuer
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *stubProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// authorize plays the provider's login page and returns the code it would redirect back with
+func (p *stubProvider) authorize(authURL string) (code string, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	params := u.Query()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code = "code-" + params.Get("state")[:8]
+	p.codes[code] = params
+	return code, params.Get("state")
+}
+
+func (p *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	p.mu.Lock()
+	params, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || clientID != stubClientID || clientSecret != stubClientSecret ||
+		r.PostFormValue("redirect_uri") != params.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != params.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	issuer, nonce := p.URL, params.Get("nonce")
+	if p.tokenIssuer != "" {
+		issuer = p.tokenIssuer
+	}
+	if p.tokenNonce != "" {
+		nonce = p.tokenNonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            issuer,
+		"aud":            stubClientID,
+		"sub":            "subject-1",
+		"email":          "student@example.com",
+		"email_verified": true,
+		"name":           "Student",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+type fakeProviderRepo struct {
+	repository.IdentityProviderRepository
+	provider *models.IdentityProvider
+}
+
+func (r *fakeProviderRepo) GetByID(ctx context.Context, id string) (*models.IdentityProvider, error) {
+	if id != r.provider.ID {
+		return nil, postgres.ErrProviderNotFound
+	}
+	return r.provider, nil
+}
+
+type fakeLoginStateRepo struct {
+	repository.ExternalLoginStateRepository
+	states map[string]*models.ExternalLoginState
+}
+
+func (r *fakeLoginStateRepo) Create(ctx context.Context, state *models.ExternalLoginState) error {
+	r.states[state.StateHash] = state
+	return nil
+}
+
+func (r *fakeLoginStateRepo) Consume(ctx context.Context, stateHash string) (*models.ExternalLoginState, error) {
+	state, ok := r.states[stateHash]
+	if !ok {
+		return nil, postgres.ErrInvalidToken
+	}
+	delete(r.states, stateHash)
+	return state, nil
+}
+
+type fakeIdentityRepo struct {
+	repository.ExternalIdentityRepository
+	identities []*models.ExternalIdentity
+}
+
+func (r *fakeIdentityRepo) GetBySubject(ctx context.Context, providerID string, subject string) (*models.ExternalIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.ProviderID == providerID && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, postgres.ErrIdentityNotFound
+}
+
+func (r *fakeIdentityRepo) Create(ctx context.Context, identity *models.ExternalIdentity) error {
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+type fakeFederationUsers struct {
+	UserService
+	created []*models.User
+}
+
+func (s *fakeFederationUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return nil, ErrUserNotFound
+}
+
+func (s *fakeFederationUsers) CreateExternal(ctx context.Context, email, fullName string) (*models.User, error) {
+	user := &models.User{ID: "user-1", Email: email, FullName: fullName, Status: models.StatusActive}
+	s.created = append(s.created, user)
+	return user, nil
+}
+
+func TestFederationLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(p *stubProvider)
+		wantErr error
+	}{
+		{name: "valid login"},
+		{name: "wrong nonce", setup: func(p *stubProvider) { p.tokenNonce = "another-nonce" }, wantErr: ErrInvalidExternalLogin},
+		{name: "wrong id token issuer", setup: func(p *stubProvider) { p.tokenIssuer = "https://evil.example.com" }, wantErr: ErrInvalidExternalLogin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newStubProvider(t)
+			if tt.setup != nil {
+				tt.setup(p)
+			}
+			s, users, identities := newTestFederationService(p)
+
+			authURL, err := s.StartLogin(context.Background(), "stub")
+			if err != nil {
+				t.Fatalf("StartLogin: %v", err)
+			}
+			code, state := p.authorize(authURL)
+
+			user, err := s.CompleteLogin(context.Background(), "stub", models.ExternalLoginRequest{Code: code, State: state})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CompleteLogin error = %v, want %v", err, tt.wantErr)
+				}
+				if len(users.created) != 0 || len(identities.identities) != 0 {
+					t.Fatal("a rejected login created a user or linked an identity")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompleteLogin: %v", err)
+			}
+			if user.Email != "student@example.com" || len(identities.identities) != 1 || identities.identities[0].Subject != "subject-1" {
+				t.Fatalf("unexpected login result: user %+v, identities %+v", user, identities.identities)
+			}
+
+			// the state is single use
+			_, err = s.CompleteLogin(context.Background(), "stub", models.ExternalLoginRequest{Code: code, State: state})
+			if !errors.Is(err, ErrInvalidExternalLogin) {
+				t.Fatalf("replayed state: error = %v, want %v", err, ErrInvalidExternalLogin)
+			}
+		})
+	}
+}
+
+func TestFederationLoginRejectsUnknownState(t *testing.T) {
+	p := newStubProvider(t)
+	s, _, _ := newTestFederationService(p)
+
+	if _, err := s.StartLogin(context.Background(), "stub"); err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	_, err := s.CompleteLogin(context.Background(), "stub", models.ExternalLoginRequest{Code: "code", State: "forged"})
+	if !errors.Is(err, ErrInvalidExternalLogin) {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidExternalLogin)
+	}
+}
+
+func TestFederationLoginRejectsDiscoveryIssuerMismatch(t *testing.T) {
+	p := newStubProvider(t)
+	p.discoveryIssuer = "https://evil.example.com"
+	s, _, _ := newTestFederationService(p)
+
+	if _, err := s.StartLogin(context.Background(), "stub"); !errors.Is(err, ErrExternalProviderFailed) {
+		t.Fatalf("error = %v, want %v", err, ErrExternalProviderFailed)
+	}
+}
+
+func newTestFederationService(p *stubProvider) (FederationService, *fakeFederationUsers, *fakeIdentityRepo) {
+	providers := &fakeProviderRepo{provider: &models.IdentityProvider{
+		ID:           "stub",
+		Issuer:       p.URL,
+		ClientID:     stubClientID,
+		ClientSecret: stubClientSecret,
+		Scopes:       defaultProviderScopes,
+		Enabled:      true,
+	}}
+	users := &fakeFederationUsers{}
+	identities := &fakeIdentityRepo{}
+	states := &fakeLoginStateRepo{states: make(map[string]*models.ExternalLoginState)}
+
+	s := NewFederationService(providers, identities, states, users, oidc.NewClient(p.Client(), time.Hour), zap.NewNop(),
+		"http://localhost:3000/login/{provider}/callback", 10*time.Minute)
+	return s, users, identities
+}
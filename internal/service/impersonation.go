@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+	"user_service/internal/events"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+)
+
+// ImpersonationService lets support staff act as a user to see what the user sees. Every session and every
+// request made during it is recorded.
+type ImpersonationService interface {
+	// Start issues an access token for the subject carrying the actor in its act claim
+	Start(ctx context.Context, actorID string, subjectID string, input models.ImpersonateInput) (*models.ImpersonateResponse, error)
+	// End revokes the token of the session, ending a session twice is not an error
+	End(ctx context.Context, sessionID string) error
+	// RecordAction never fails, a request is not undone because it could not be recorded
+	RecordAction(ctx context.Context, sessionID string, method string, path string, status int)
+	List(ctx context.Context, filter models.ImpersonationFilter) ([]*models.ImpersonationSession, error)
+	// Get returns the session with its actions
+	Get(ctx context.Context, id string) (*models.ImpersonationSession, error)
+}
+
+var (
+	ErrImpersonateSelf       = errors.New("cannot impersonate yourself")
+	ErrImpersonateAdmin      = errors.New("admins cannot be impersonated")
+	ErrImpersonationNotFound = errors.New("impersonation session not found")
+)
+
+// Impersonation limits
+const (
+	defaultImpersonationListLimit = 50
+	maxImpersonationListLimit     = 200
+)
+
+type impersonationService struct {
+	repo        repository.ImpersonationRepository
+	userRepo    repository.UserRepository
+	rbac        RBACService
+	revocations repository.RevocationStore
+	jwtService  *util.JwtImpl
+	events      events.Publisher
+	audit       AuditService
+	log         *zap.Logger
+	ttl         time.Duration
+}
+
+func NewImpersonationService(repo repository.ImpersonationRepository, userRepo repository.UserRepository, rbac RBACService,
+	revocations repository.RevocationStore, jwtService *util.JwtImpl, events events.Publisher, audit AuditService, log *zap.Logger,
+	ttl time.Duration) ImpersonationService {
+	return &impersonationService{repo: repo, userRepo: userRepo, rbac: rbac, revocations: revocations, jwtService: jwtService,
+		events: events, audit: audit, log: log, ttl: ttl}
+}
+
+func (s *impersonationService) Start(ctx context.Context, actorID string, subjectID string, input models.ImpersonateInput) (*models.ImpersonateResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	if actorID == subjectID {
+		return nil, ErrImpersonateSelf
+	}
+
+	subject, err := s.userRepo.GetByID(ctx, subjectID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.Error("[Service][Impersonation][Start] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+	if subject.Status != models.StatusActive {
+		return nil, ErrAccountInactive
+	}
+
+	// acting as an admin would hand out every permission
+	roles, err := s.rbac.UserRoles(ctx, subject.ID)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(roles, models.RoleAdmin) {
+		return nil, ErrImpersonateAdmin
+	}
+
+	now := time.Now()
+	session := &models.ImpersonationSession{
+		ID:        uuid.New().String(),
+		ActorID:   actorID,
+		SubjectID: subject.ID,
+		Reason:    strings.TrimSpace(input.Reason),
+		IP:        util.ClientInfoFromContext(ctx).IPAddress,
+		StartedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if err := s.repo.Create(ctx, session); err != nil {
+		s.log.Error("[Service][Impersonation][Start] failed to store session", zap.Error(err))
+		return nil, err
+	}
+
+	accessToken, err := s.jwtService.SignAccessToken(jwt.MapClaims{
+		"userID": subject.ID,
+		"role":   subject.Role,
+		"sub":    subject.ID,
+		"jti":    session.ID,
+		"act":    map[string]any{"sub": actorID},
+		"iat":    now.Unix(),
+		"exp":    session.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		s.log.Error("[Service][Impersonation][Start] failed to sign token", zap.Error(err))
+		return nil, err
+	}
+
+	s.events.Publish(ctx, events.Event{
+		Type:     events.TypeImpersonationStart,
+		Severity: events.SeverityWarning,
+		UserID:   subject.ID,
+		Time:     now,
+		Metadata: map[string]string{"actorID": actorID, "sessionID": session.ID, "reason": session.Reason},
+	})
+	s.log.Info("[Service][Impersonation] session started", zap.String("actorID", actorID), zap.String("subjectID", subject.ID),
+		zap.String("sessionID", session.ID))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditImpersonationStart, TargetType: models.AuditTargetUser, TargetID: subject.ID,
+		Metadata: map[string]string{"sessionID": session.ID, "reason": session.Reason}})
+
+	return &models.ImpersonateResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenTypeBearer,
+		ExpiresIn:   int64(s.ttl.Seconds()),
+		Session:     session,
+	}, nil
+}
+
+func (s *impersonationService) End(ctx context.Context, sessionID string) error {
+	session, err := s.get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !session.Active(now) {
+		return nil
+	}
+
+	if err := s.revocations.RevokeJTI(ctx, session.ID, session.ExpiresAt); err != nil {
+		s.log.Error("[Service][Impersonation][End] failed to revoke token", zap.Error(err))
+		return err
+	}
+	if err := s.repo.End(ctx, session.ID, now); err != nil {
+		s.log.Error("[Service][Impersonation][End] failed to end session", zap.Error(err))
+		return err
+	}
+
+	s.events.Publish(ctx, events.Event{
+		Type:     events.TypeImpersonationEnd,
+		Severity: events.SeverityInfo,
+		UserID:   session.SubjectID,
+		Time:     now,
+		Metadata: map[string]string{"actorID": session.ActorID, "sessionID": session.ID},
+	})
+	s.log.Info("[Service][Impersonation] session ended", zap.String("actorID", session.ActorID),
+		zap.String("subjectID", session.SubjectID), zap.String("sessionID", session.ID))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditImpersonationEnd, TargetType: models.AuditTargetImpersonation,
+		TargetID: session.ID, Metadata: map[string]string{"subjectID": session.SubjectID}})
+	return nil
+}
+
+func (s *impersonationService) RecordAction(ctx context.Context, sessionID string, method string, path string, status int) {
+	err := s.repo.RecordAction(ctx, &models.ImpersonationAction{SessionID: sessionID, Method: method, Path: path, Status: status})
+	if err != nil {
+		s.log.Error("[Service][Impersonation][RecordAction] failed to record action", zap.String("sessionID", sessionID),
+			zap.String("method", method), zap.String("path", path), zap.Int("status", status), zap.Error(err))
+	}
+}
+
+func (s *impersonationService) List(ctx context.Context, filter models.ImpersonationFilter) ([]*models.ImpersonationSession, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultImpersonationListLimit
+	}
+	filter.Limit = min(filter.Limit, maxImpersonationListLimit)
+
+	sessions, err := s.repo.List(ctx, filter)
+	if err != nil {
+		s.log.Error("[Service][Impersonation][List] failed to list sessions", zap.Error(err))
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *impersonationService) Get(ctx context.Context, id string) (*models.ImpersonationSession, error) {
+	session, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Actions, err = s.repo.ListActions(ctx, session.ID); err != nil {
+		s.log.Error("[Service][Impersonation][Get] failed to list actions", zap.Error(err))
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *impersonationService) get(ctx context.Context, id string) (*models.ImpersonationSession, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrImpersonationNotFound
+	}
+
+	session, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, postgres.ErrImpersonationNotFound) {
+			return nil, ErrImpersonationNotFound
+		}
+		s.log.Error("[Service][Impersonation] failed to get session", zap.Error(err))
+		return nil, err
+	}
+	return session, nil
+}
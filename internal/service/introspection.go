@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+)
+
+// IntrospectionService tells other services whether a token is still valid (RFC 7662) and revokes
+// tokens (RFC 7009). It understands access tokens, personal access tokens and refresh tokens, first-party
+// ones as well as those issued to OAuth clients.
+type IntrospectionService interface {
+	// Introspect never fails for an unknown or invalid token, it is reported inactive
+	Introspect(ctx context.Context, token string, hint string) (*models.IntrospectionResponse, error)
+	// Revoke succeeds for unknown or invalid tokens too, there is nothing left to revoke
+	Revoke(ctx context.Context, token string, hint string) error
+}
+
+type introspectionService struct {
+	jwtService  *util.JwtImpl
+	authRepo    repository.AuthRepository
+	revocations repository.RevocationStore
+	tokens      PersonalAccessTokenService
+	log         *zap.Logger
+}
+
+func NewIntrospectionService(jwtService *util.JwtImpl, authRepo repository.AuthRepository, revocations repository.RevocationStore,
+	tokens PersonalAccessTokenService, log *zap.Logger) IntrospectionService {
+	return &introspectionService{jwtService: jwtService, authRepo: authRepo, revocations: revocations, tokens: tokens, log: log}
+}
+
+var inactive = &models.IntrospectionResponse{Active: false}
+
+func (s *introspectionService) Introspect(ctx context.Context, token string, hint string) (*models.IntrospectionResponse, error) {
+	if strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+		return s.introspectPersonalAccessToken(ctx, token)
+	}
+
+	// the hint only decides which kind is looked up first (RFC 7662 section 2.1)
+	if hint == models.TokenUseRefresh {
+		if res, err := s.introspectRefreshToken(ctx, token); err != nil || res.Active {
+			return res, err
+		}
+		return s.introspectAccessToken(ctx, token)
+	}
+
+	if res, err := s.introspectAccessToken(ctx, token); err != nil || res.Active {
+		return res, err
+	}
+	return s.introspectRefreshToken(ctx, token)
+}
+
+func (s *introspectionService) introspectAccessToken(ctx context.Context, token string) (*models.IntrospectionResponse, error) {
+	claims, err := s.jwtService.ValidateAccessToken(token)
+	if err != nil {
+		return inactive, nil
+	}
+
+	revoked, err := AccessTokenRevoked(ctx, s.revocations, claims)
+	if err != nil {
+		s.log.Error("[Service][Introspection] failed to check revocation", zap.Error(err))
+		return nil, err
+	}
+	if revoked {
+		return inactive, nil
+	}
+
+	res := claimsResponse(claims)
+	res.TokenUse = models.TokenUseAccess
+	return res, nil
+}
+
+func (s *introspectionService) introspectPersonalAccessToken(ctx context.Context, token string) (*models.IntrospectionResponse, error) {
+	claims, err := s.tokens.Authenticate(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPersonalAccessToken) || errors.Is(err, ErrAccountInactive) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	res := claimsResponse(claims)
+	res.TokenUse = models.TokenUseAccess
+	return res, nil
+}
+
+func (s *introspectionService) introspectRefreshToken(ctx context.Context, token string) (*models.IntrospectionResponse, error) {
+	stored, err := s.authRepo.GetByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, postgres.ErrInvalidToken) || errors.Is(err, postgres.ErrExpiredToken) {
+			return inactive, nil
+		}
+		s.log.Error("[Service][Introspection] failed to get refresh token", zap.Error(err))
+		return nil, err
+	}
+	if stored.IsRevoked || stored.RotatedAt != nil {
+		return inactive, nil
+	}
+
+	return &models.IntrospectionResponse{
+		Active:   true,
+		Scope:    stored.Scope,
+		ClientID: stored.ClientID,
+		TokenUse: models.TokenUseRefresh,
+		Exp:      stored.ExpiresAt.Unix(),
+		Iat:      stored.IssuedAt.Unix(),
+		Sub:      stored.UserID,
+		Iss:      s.jwtService.Issuer(),
+		UserID:   stored.UserID,
+	}, nil
+}
+
+// Revoke denylists an access token until it expires, revokes a personal access token, or signs out the
+// session of a refresh token
+func (s *introspectionService) Revoke(ctx context.Context, token string, hint string) error {
+	if strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+		return s.tokens.RevokeToken(ctx, token)
+	}
+
+	if claims, err := s.jwtService.ValidateAccessToken(token); err == nil && hint != models.TokenUseRefresh {
+		jti, _ := claims["jti"].(string)
+		expiresAt, err := claims.GetExpirationTime()
+		if jti == "" || err != nil || expiresAt == nil {
+			return nil
+		}
+		if err := s.revocations.RevokeJTI(ctx, jti, expiresAt.Time); err != nil {
+			s.log.Error("[Service][Introspection][Revoke] failed to revoke access token", zap.Error(err))
+			return err
+		}
+		s.log.Info("[Service][Introspection] access token revoked", zap.String("jti", jti))
+		return nil
+	}
+
+	stored, err := s.authRepo.GetByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, postgres.ErrInvalidToken) || errors.Is(err, postgres.ErrExpiredToken) {
+			return nil
+		}
+		s.log.Error("[Service][Introspection][Revoke] failed to get refresh token", zap.Error(err))
+		return err
+	}
+
+	// the whole family goes, a rotated copy of the token must not keep the session alive
+	if err := s.authRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+		s.log.Error("[Service][Introspection][Revoke] failed to revoke refresh token", zap.Error(err))
+		return err
+	}
+	s.log.Info("[Service][Introspection] refresh token revoked", zap.String("userID", stored.UserID), zap.String("familyID", stored.FamilyID))
+	return nil
+}
+
+// AccessTokenRevoked reports whether the token was revoked by its jti or issued before the tokens of its
+// subject were invalidated, e.g. by a password change or logout from all devices. Tokens of service
+// accounts are invalidated the same way by their client id. iat has a one second resolution so the cutoff is too.
+func AccessTokenRevoked(ctx context.Context, revocations repository.RevocationStore, claims jwt.MapClaims) (bool, error) {
+	jti, _ := claims["jti"].(string)
+	subject, _ := claims["userID"].(string)
+	if claims["sub_type"] == models.SubTypeService {
+		subject, _ = claims["sub"].(string)
+	}
+	issuedAt, err := claims.GetIssuedAt()
+	if jti == "" || subject == "" || err != nil || issuedAt == nil {
+		return true, nil
+	}
+
+	revoked, err := revocations.IsRevoked(ctx, jti)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	validAfter, err := revocations.ValidAfter(ctx, subject)
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt.Before(validAfter.Truncate(time.Second)), nil
+}
+
+// claimsResponse describes an active access token by its claims
+func claimsResponse(claims jwt.MapClaims) *models.IntrospectionResponse {
+	res := &models.IntrospectionResponse{Active: true, TokenType: tokenTypeBearer}
+	res.Scope, _ = claims["scope"].(string)
+	res.ClientID, _ = claims["client_id"].(string)
+	res.Sub, _ = claims["sub"].(string)
+	res.Iss, _ = claims["iss"].(string)
+	res.Jti, _ = claims["jti"].(string)
+	res.UserID, _ = claims["userID"].(string)
+	res.Role, _ = claims["role"].(string)
+	res.SubType, _ = claims["sub_type"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		res.Exp = exp.Unix()
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		res.Iat = iat.Unix()
+	}
+	if aud, err := claims.GetAudience(); err == nil {
+		res.Aud = aud
+	}
+	return res
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+// LockoutService protects the login against password guessing. Failures are counted per account email,
+// known or not so responses do not reveal which accounts exist, and per client IP.
+type LockoutService interface {
+	// Check returns a *LockoutError when the account or the IP may not attempt a login right now
+	Check(ctx context.Context, email, ip string) error
+	// RegisterFailure records a failed login, it returns a *LockoutError when the failure triggered a delay or a lock
+	RegisterFailure(ctx context.Context, email, ip string) error
+	RegisterSuccess(ctx context.Context, email string) error
+	Unlock(ctx context.Context, email string) error
+}
+
+type lockoutService struct {
+	repo   repository.LoginFailureRepository
+	policy models.LockoutPolicy
+	log    *zap.Logger
+}
+
+var (
+	ErrAccountLocked     = errors.New("account temporarily locked")
+	ErrTooManyAttempts   = errors.New("too many login attempts")
+	ErrorCheckingLockout = errors.New("failed to check login lockout")
+)
+
+// LockoutError tells the client how long to wait. It wraps ErrAccountLocked or ErrTooManyAttempts.
+type LockoutError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockoutError) Unwrap() error {
+	return e.Err
+}
+
+func NewLockoutService(repo repository.LoginFailureRepository, policy models.LockoutPolicy, log *zap.Logger) LockoutService {
+	return &lockoutService{repo: repo, policy: policy, log: log}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (s *lockoutService) Check(ctx context.Context, email, ip string) error {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+
+	for _, key := range keys {
+		failure, err := s.repo.Get(ctx, key)
+		if err != nil {
+			s.log.Error("[Service][Lockout][Check] failed to get login failures", zap.Error(err))
+			return ErrorCheckingLockout
+		}
+		if failure == nil || failure.LockedUntil == nil {
+			continue
+		}
+
+		if retryAfter := time.Until(*failure.LockedUntil); retryAfter > 0 {
+			return s.lockoutError(key, failure.Failures, retryAfter)
+		}
+	}
+
+	return nil
+}
+
+func (s *lockoutService) RegisterFailure(ctx context.Context, email, ip string) error {
+	var lockErr error
+
+	failures, err := s.repo.RecordFailure(ctx, accountKey(email), s.policy.Window)
+	if err != nil {
+		s.log.Error("[Service][Lockout][RegisterFailure] failed to record account failure", zap.Error(err))
+		return ErrorCheckingLockout
+	}
+	if wait := s.accountWait(failures); wait > 0 {
+		if err := s.repo.SetLockedUntil(ctx, accountKey(email), time.Now().Add(wait)); err != nil {
+			s.log.Error("[Service][Lockout][RegisterFailure] failed to lock account", zap.Error(err))
+			return ErrorCheckingLockout
+		}
+		lockErr = s.lockoutError(accountKey(email), failures, wait)
+	}
+
+	if ip == "" {
+		return lockErr
+	}
+
+	// the IP is only locked, a shared NAT would otherwise slow down every user behind it
+	failures, err = s.repo.RecordFailure(ctx, ipKey(ip), s.policy.Window)
+	if err != nil {
+		s.log.Error("[Service][Lockout][RegisterFailure] failed to record ip failure", zap.Error(err))
+		return ErrorCheckingLockout
+	}
+	if failures >= s.policy.MaxIPFailures {
+		if err := s.repo.SetLockedUntil(ctx, ipKey(ip), time.Now().Add(s.policy.LockDuration)); err != nil {
+			s.log.Error("[Service][Lockout][RegisterFailure] failed to lock ip", zap.Error(err))
+			return ErrorCheckingLockout
+		}
+		s.log.Warn("[Service][Lockout] client ip locked", zap.String("ip", ip), zap.Int("failures", failures))
+		lockErr = &LockoutError{Err: ErrAccountLocked, RetryAfter: s.policy.LockDuration}
+	}
+
+	return lockErr
+}
+
+// RegisterSuccess clears the failures of the account. IP failures are kept, an attacker owning one account
+// could otherwise reset the counter of the IP between guesses.
+func (s *lockoutService) RegisterSuccess(ctx context.Context, email string) error {
+	if err := s.repo.Reset(ctx, accountKey(email)); err != nil {
+		s.log.Error("[Service][Lockout][RegisterSuccess] failed to reset login failures", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *lockoutService) Unlock(ctx context.Context, email string) error {
+	if err := s.repo.Reset(ctx, accountKey(email)); err != nil {
+		s.log.Error("[Service][Lockout][Unlock] failed to unlock account", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// accountWait returns how long the account has to wait after its nth failure: nothing for the first
+// DelayAfter failures, then a doubling delay and finally the lock.
+func (s *lockoutService) accountWait(failures int) time.Duration {
+	if failures >= s.policy.MaxAccountFailures {
+		return s.policy.LockDuration
+	}
+	if failures < s.policy.DelayAfter {
+		return 0
+	}
+
+	delay := s.policy.BaseDelay
+	for i := s.policy.DelayAfter; i < failures && delay < s.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.policy.MaxDelay)
+}
+
+func (s *lockoutService) lockoutError(key string, failures int, retryAfter time.Duration) error {
+	if (strings.HasPrefix(key, "ip:") && failures >= s.policy.MaxIPFailures) ||
+		(strings.HasPrefix(key, "account:") && failures >= s.policy.MaxAccountFailures) {
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: retryAfter}
+	}
+	return &LockoutError{Err: ErrTooManyAttempts, RetryAfter: retryAfter}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+)
+
+// LoginHistoryService records login attempts and when users were last seen
+type LoginHistoryService interface {
+	// RecordSuccess stores a successful login and sets the last login of the user, suspicious lists why the
+	// login was flagged
+	RecordSuccess(ctx context.Context, user *models.User, method string, suspicious []string)
+	// RecordFailure stores a failed login. userID may be empty, the account is then looked up by email.
+	RecordFailure(ctx context.Context, userID string, email string, method string, reason string)
+	// TouchActivity sets the last activity of the user, at most once per activity interval
+	TouchActivity(ctx context.Context, userID string)
+	List(ctx context.Context, userID string, params util.PaginationParams) ([]*models.LoginEvent, int64, error)
+}
+
+type loginHistoryService struct {
+	repo             repository.LoginEventRepository
+	userRepo         repository.UserRepository
+	log              *zap.Logger
+	activityInterval time.Duration
+
+	mu           sync.Mutex
+	lastActivity map[string]time.Time
+	lastPrune    time.Time
+}
+
+// NewLoginHistoryService creates the service, activityInterval throttles the last activity writes, a user
+// making many requests only updates it once per interval
+func NewLoginHistoryService(repo repository.LoginEventRepository, userRepo repository.UserRepository, log *zap.Logger,
+	activityInterval time.Duration) LoginHistoryService {
+	return &loginHistoryService{repo: repo, userRepo: userRepo, log: log, activityInterval: activityInterval,
+		lastActivity: make(map[string]time.Time)}
+}
+
+func (s *loginHistoryService) RecordSuccess(ctx context.Context, user *models.User, method string, suspicious []string) {
+	now := time.Now()
+	s.record(ctx, &models.LoginEvent{UserID: user.ID, Email: user.Email, Success: true, Method: method, Suspicious: suspicious,
+		CreatedAt: now})
+
+	if err := s.userRepo.UpdateLastLogin(ctx, user.ID, now); err != nil {
+		s.log.Error("[Service][LoginHistory][RecordSuccess] failed to update last login", zap.String("userID", user.ID), zap.Error(err))
+		return
+	}
+	user.LastLogin = &now
+	user.LastActivity = &now
+
+	s.mu.Lock()
+	s.lastActivity[user.ID] = now
+	s.mu.Unlock()
+}
+
+func (s *loginHistoryService) RecordFailure(ctx context.Context, userID string, email string, method string, reason string) {
+	if userID == "" && email != "" {
+		if user, err := s.userRepo.GetByEmail(ctx, email); err == nil {
+			userID = user.ID
+		}
+	}
+
+	s.record(ctx, &models.LoginEvent{UserID: userID, Email: email, Method: method, FailureReason: reason})
+}
+
+func (s *loginHistoryService) record(ctx context.Context, event *models.LoginEvent) {
+	client := util.ClientInfoFromContext(ctx)
+	event.IPAddress = client.IPAddress
+	event.UserAgent = client.UserAgent
+	event.DeviceLabel = client.DeviceLabel
+	event.DeviceFingerprint = client.DeviceFingerprint()
+	event.IPSubnet = util.IPSubnet(client.IPAddress)
+
+	if err := s.repo.Create(ctx, event); err != nil {
+		s.log.Error("[Service][LoginHistory] failed to record login", zap.String("userID", event.UserID),
+			zap.Bool("success", event.Success), zap.Error(err))
+	}
+}
+
+func (s *loginHistoryService) TouchActivity(ctx context.Context, userID string) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if now.Sub(s.lastActivity[userID]) < s.activityInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastActivity[userID] = now
+	s.prune(now)
+	s.mu.Unlock()
+
+	if err := s.userRepo.UpdateLastActivity(ctx, userID, now); err != nil {
+		s.log.Error("[Service][LoginHistory][TouchActivity] failed to update last activity", zap.String("userID", userID), zap.Error(err))
+	}
+}
+
+// prune forgets users whose interval is over, they would be written on their next request anyway.
+// Called with mu held.
+func (s *loginHistoryService) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < s.activityInterval {
+		return
+	}
+	s.lastPrune = now
+
+	for userID, at := range s.lastActivity {
+		if now.Sub(at) >= s.activityInterval {
+			delete(s.lastActivity, userID)
+		}
+	}
+}
+
+func (s *loginHistoryService) List(ctx context.Context, userID string, params util.PaginationParams) ([]*models.LoginEvent, int64, error) {
+	events, total, err := s.repo.ListByUser(ctx, userID, params)
+	if err != nil {
+		s.log.Error("[Service][LoginHistory][List] failed to list logins", zap.String("userID", userID), zap.Error(err))
+		return nil, 0, err
+	}
+	return events, total, nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+	"user_service/pkg/mail"
+)
+
+type MagicLinkService interface {
+	SendLink(ctx context.Context, email string) error
+	// Consume exchanges a link for the user it was sent to, the caller completes the login
+	Consume(ctx context.Context, token string) (*models.User, error)
+}
+
+type magicLinkService struct {
+	userService UserService
+	tokenRepo   repository.OneTimeTokenRepository
+	mailer      mail.Sender
+	log         *zap.Logger
+	loginURL    string
+	tokenTTL    time.Duration
+}
+
+var (
+	ErrInvalidMagicLink = errors.New("invalid or expired magic link")
+	ErrAccountInactive  = errors.New("account is inactive")
+)
+
+// NewMagicLinkService creates the passwordless login flow. loginURL is the frontend page receiving
+// the token as a query parameter and posting it to /auth/magic-link/consume.
+func NewMagicLinkService(userService UserService, tokenRepo repository.OneTimeTokenRepository, mailer mail.Sender,
+	log *zap.Logger, loginURL string, tokenTTL time.Duration) MagicLinkService {
+	return &magicLinkService{
+		userService: userService,
+		tokenRepo:   tokenRepo,
+		mailer:      mailer,
+		log:         log,
+		loginURL:    loginURL,
+		tokenTTL:    tokenTTL,
+	}
+}
+
+// SendLink mails a login link. Unknown or inactive accounts are ignored so the endpoint does not reveal which accounts exist.
+func (s *magicLinkService) SendLink(ctx context.Context, email string) error {
+	user, err := s.userService.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			s.log.Info("[Service][MagicLink] link requested for unknown email")
+			return nil
+		}
+		s.log.Error("[Service][MagicLink] failed to get user", zap.Error(err))
+		return ErrorGetUser
+	}
+
+	if user.Status == models.StatusInactive {
+		s.log.Info("[Service][MagicLink] link requested for inactive account", zap.String("userID", user.ID))
+		return nil
+	}
+
+	// only the most recent link stays valid
+	if err := s.tokenRepo.InvalidateByUserID(ctx, user.ID); err != nil {
+		s.log.Error("[Service][MagicLink] failed to invalidate previous links", zap.Error(err))
+		return err
+	}
+
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][MagicLink] failed to generate token", zap.Error(err))
+		return err
+	}
+
+	err = s.tokenRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    user.ID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(s.tokenTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][MagicLink] failed to store token", zap.Error(err))
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Liên kết đăng nhập",
+		Body: fmt.Sprintf("Xin chào %s,\n\nTruy cập liên kết sau để đăng nhập mà không cần mật khẩu (chỉ dùng được một lần, hết hạn sau %s):\n%s?token=%s\n\nNếu bạn không yêu cầu, hãy bỏ qua email này.",
+			user.FullName, s.tokenTTL, s.loginURL, token),
+	})
+	if err != nil {
+		s.log.Error("[Service][MagicLink] failed to send mail", zap.Error(err))
+		return ErrorSendingMail
+	}
+
+	return nil
+}
+
+func (s *magicLinkService) Consume(ctx context.Context, token string) (*models.User, error) {
+	stored, err := s.tokenRepo.GetByHash(ctx, util.HashToken(token))
+	if err != nil {
+		s.log.Error("[Service][MagicLink] invalid magic link", zap.Error(err))
+		return nil, ErrInvalidMagicLink
+	}
+
+	if err := s.tokenRepo.MarkUsed(ctx, stored.ID); err != nil {
+		s.log.Error("[Service][MagicLink] failed to consume magic link", zap.Error(err))
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.userService.GetByID(ctx, stored.UserID)
+	if err != nil {
+		s.log.Error("[Service][MagicLink] failed to get user", zap.Error(err))
+		return nil, err
+	}
+
+	switch user.Status {
+	case models.StatusInactive:
+		return nil, ErrAccountInactive
+	case models.StatusPendingVerification:
+		// the link was delivered to the mailbox, which verifies the email as well
+		user, err = s.userService.Update(ctx, user.ID, models.UpdateUserInput{Status: models.StatusActive})
+		if err != nil {
+			s.log.Error("[Service][MagicLink] failed to activate user", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+	"user_service/pkg/totp"
+)
+
+type MFAService interface {
+	IsEnabled(ctx context.Context, userID string) (bool, error)
+	Enroll(ctx context.Context, userID string) (*models.MFAEnrollResponse, error)
+	Confirm(ctx context.Context, userID string, code string) ([]string, error)
+	Disable(ctx context.Context, userID string, code string, recoveryCode string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error)
+	CreateChallenge(ctx context.Context, userID string) (*models.MFAChallengeResponse, error)
+	VerifyChallenge(ctx context.Context, challengeToken string, code string, recoveryCode string) (*models.User, error)
+}
+
+type mfaService struct {
+	mfaRepo       repository.MFARepository
+	challengeRepo repository.MFAChallengeRepository
+	userService   UserService
+	loginHistory  LoginHistoryService
+	lockout       LockoutService
+	log           *zap.Logger
+	issuer        string
+	challengeTTL  time.Duration
+}
+
+const recoveryCodeCount = 10
+
+// maxChallengeAttempts wrong codes consume a challenge, the password has to be entered again for a new one
+const maxChallengeAttempts = 5
+
+var (
+	ErrMFANotEnabled       = errors.New("mfa is not enabled")
+	ErrMFAAlreadyEnabled   = errors.New("mfa is already enabled")
+	ErrMFANotEnrolled      = errors.New("mfa enrollment not started")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa challenge")
+)
+
+// NewMFAService creates the TOTP service. issuer is the name shown in authenticator apps. Wrong codes count as
+// failed logins for lockout.
+func NewMFAService(mfaRepo repository.MFARepository, challengeRepo repository.MFAChallengeRepository, userService UserService,
+	loginHistory LoginHistoryService, lockout LockoutService, log *zap.Logger, issuer string, challengeTTL time.Duration) MFAService {
+	return &mfaService{
+		mfaRepo:       mfaRepo,
+		challengeRepo: challengeRepo,
+		userService:   userService,
+		loginHistory:  loginHistory,
+		lockout:       lockout,
+		log:           log,
+		issuer:        issuer,
+		challengeTTL:  challengeTTL,
+	}
+}
+
+func (s *mfaService) IsEnabled(ctx context.Context, userID string) (bool, error) {
+	config, err := s.mfaRepo.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrMFANotFound) {
+			return false, nil
+		}
+		s.log.Error("[Service][MFA][IsEnabled] failed to get mfa config", zap.Error(err))
+		return false, err
+	}
+
+	return config.Enabled, nil
+}
+
+// Enroll generates a new secret. MFA only becomes active once Confirm receives a valid code for it.
+func (s *mfaService) Enroll(ctx context.Context, userID string) (*models.MFAEnrollResponse, error) {
+	enabled, err := s.IsEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	user, err := s.userService.GetByID(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][MFA][Enroll] failed to get user", zap.Error(err))
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.log.Error("[Service][MFA][Enroll] failed to generate secret", zap.Error(err))
+		return nil, err
+	}
+
+	if err := s.mfaRepo.Upsert(ctx, &models.MFAConfig{UserID: userID, Secret: secret}); err != nil {
+		s.log.Error("[Service][MFA][Enroll] failed to store secret", zap.Error(err))
+		return nil, err
+	}
+
+	return &models.MFAEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables MFA after the first valid code and returns the recovery codes, which are only shown once.
+func (s *mfaService) Confirm(ctx context.Context, userID string, code string) ([]string, error) {
+	config, err := s.mfaRepo.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrMFANotFound) {
+			return nil, ErrMFANotEnrolled
+		}
+		s.log.Error("[Service][MFA][Confirm] failed to get mfa config", zap.Error(err))
+		return nil, err
+	}
+
+	if config.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if err := s.checkCode(ctx, config, code); err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaRepo.Enable(ctx, userID); err != nil {
+		s.log.Error("[Service][MFA][Confirm] failed to enable mfa", zap.Error(err))
+		return nil, err
+	}
+
+	return s.replaceRecoveryCodes(ctx, userID)
+}
+
+// Disable removes MFA after a valid TOTP or recovery code. Wrong codes count as failed logins like at
+// VerifyChallenge, a stolen session must not be able to guess its way past the second factor.
+func (s *mfaService) Disable(ctx context.Context, userID string, code string, recoveryCode string) error {
+	config, err := s.enabledConfig(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userService.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, config, code, recoveryCode, nil); err != nil {
+		return err
+	}
+
+	if err := s.mfaRepo.Delete(ctx, userID); err != nil {
+		s.log.Error("[Service][MFA][Disable] failed to delete mfa config", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes requires a TOTP code, wrong codes count as failed logins like at Disable
+func (s *mfaService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	config, err := s.enabledConfig(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, config, code, "", nil); err != nil {
+		return nil, err
+	}
+
+	return s.replaceRecoveryCodes(ctx, userID)
+}
+
+// CreateChallenge issues the short lived token exchanged at /auth/mfa/verify once the password was checked.
+func (s *mfaService) CreateChallenge(ctx context.Context, userID string) (*models.MFAChallengeResponse, error) {
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][MFA][CreateChallenge] failed to generate token", zap.Error(err))
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.challengeTTL)
+	err = s.challengeRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    userID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][MFA][CreateChallenge] failed to store challenge", zap.Error(err))
+		return nil, err
+	}
+
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// VerifyChallenge checks the second factor for a pending login and consumes the challenge on success.
+// A challenge is consumed after maxChallengeAttempts wrong codes, which also count as failed logins: the
+// lockout may return a *LockoutError.
+func (s *mfaService) VerifyChallenge(ctx context.Context, challengeToken string, code string, recoveryCode string) (*models.User, error) {
+	challenge, err := s.challengeRepo.GetByHash(ctx, util.HashToken(challengeToken))
+	if err != nil {
+		s.log.Error("[Service][MFA][VerifyChallenge] invalid challenge", zap.Error(err))
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	config, err := s.enabledConfig(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.verifySecondFactor(ctx, user, config, code, recoveryCode, func() error {
+		s.loginHistory.RecordFailure(ctx, user.ID, user.Email, models.LoginMethodMFA, models.LoginFailureInvalidMFACode)
+		if err := s.challengeRepo.RecordFailedAttempt(ctx, challenge.ID, maxChallengeAttempts); err != nil {
+			s.log.Error("[Service][MFA][VerifyChallenge] failed to count attempt", zap.Error(err))
+			return ErrInvalidMFAChallenge
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.challengeRepo.MarkUsed(ctx, challenge.ID); err != nil {
+		s.log.Error("[Service][MFA][VerifyChallenge] failed to consume challenge", zap.Error(err))
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return user, nil
+}
+
+// verifySecondFactor checks a code under the login lockout of the user: it is refused while the account or IP
+// is locked, a wrong code is passed to onFailure and registered as a failed login, which may return a
+// *LockoutError, and a valid code resets the failures.
+func (s *mfaService) verifySecondFactor(ctx context.Context, user *models.User, config *models.MFAConfig, code string,
+	recoveryCode string, onFailure func() error) error {
+	ip := util.ClientInfoFromContext(ctx).IPAddress
+	if err := s.lockout.Check(ctx, user.Email, ip); err != nil {
+		return err
+	}
+
+	if err := s.checkSecondFactor(ctx, config, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if onFailure != nil {
+				if err := onFailure(); err != nil {
+					return err
+				}
+			}
+			if err := s.lockout.RegisterFailure(ctx, user.Email, ip); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	if err := s.lockout.RegisterSuccess(ctx, user.Email); err != nil {
+		s.log.Error("[Service][MFA] failed to reset login failures", zap.Error(err))
+	}
+
+	return nil
+}
+
+func (s *mfaService) enabledConfig(ctx context.Context, userID string) (*models.MFAConfig, error) {
+	config, err := s.mfaRepo.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrMFANotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		s.log.Error("[Service][MFA] failed to get mfa config", zap.Error(err))
+		return nil, err
+	}
+
+	if !config.Enabled {
+		return nil, ErrMFANotEnabled
+	}
+
+	return config, nil
+}
+
+func (s *mfaService) checkSecondFactor(ctx context.Context, config *models.MFAConfig, code string, recoveryCode string) error {
+	if recoveryCode != "" {
+		if err := s.mfaRepo.UseRecoveryCode(ctx, config.UserID, util.HashToken(normalizeRecoveryCode(recoveryCode))); err != nil {
+			s.log.Info("[Service][MFA] invalid recovery code", zap.String("userID", config.UserID), zap.Error(err))
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	return s.checkCode(ctx, config, code)
+}
+
+// checkCode validates a TOTP code and records its step so the same code cannot be replayed
+func (s *mfaService) checkCode(ctx context.Context, config *models.MFAConfig, code string) error {
+	step, ok := totp.Validate(config.Secret, code, time.Now())
+	if !ok {
+		s.log.Info("[Service][MFA] invalid totp code", zap.String("userID", config.UserID))
+		return ErrInvalidMFACode
+	}
+
+	if err := s.mfaRepo.UseStep(ctx, config.UserID, step); err != nil {
+		s.log.Info("[Service][MFA] totp code rejected", zap.String("userID", config.UserID), zap.Error(err))
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *mfaService) replaceRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			s.log.Error("[Service][MFA] failed to generate recovery code", zap.Error(err))
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, util.HashToken(code))
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		s.log.Error("[Service][MFA] failed to store recovery codes", zap.Error(err))
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// recoveryCodeAlphabet has exactly 32 characters so a random byte maps onto it without bias
+const recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[b[i]&31]
+	}
+	return string(b), nil
+}
+
+// normalizeRecoveryCode makes recovery codes case and separator insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(code))
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/pkg/totp"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+type fakeMFARepo struct {
+	repository.MFARepository
+	config        *models.MFAConfig
+	recoveryCodes map[string]bool
+	deleted       bool
+	replaced      bool
+}
+
+func (r *fakeMFARepo) Get(context.Context, string) (*models.MFAConfig, error) {
+	if r.deleted {
+		return nil, postgres.ErrMFANotFound
+	}
+	config := *r.config
+	return &config, nil
+}
+
+func (r *fakeMFARepo) UseStep(_ context.Context, _ string, step int64) error {
+	if step <= r.config.LastUsedStep {
+		return postgres.ErrMFACodeReplayed
+	}
+	r.config.LastUsedStep = step
+	return nil
+}
+
+func (r *fakeMFARepo) UseRecoveryCode(_ context.Context, _ string, codeHash string) error {
+	if !r.recoveryCodes[codeHash] {
+		return postgres.ErrRecoveryCodeInvalid
+	}
+	delete(r.recoveryCodes, codeHash)
+	return nil
+}
+
+func (r *fakeMFARepo) Delete(context.Context, string) error {
+	r.deleted = true
+	return nil
+}
+
+func (r *fakeMFARepo) ReplaceRecoveryCodes(context.Context, string, []string) error {
+	r.replaced = true
+	return nil
+}
+
+type fakeMFAUsers struct{ UserService }
+
+func (fakeMFAUsers) GetByID(_ context.Context, id string) (*models.User, error) {
+	return &models.User{ID: id, Email: "user@example.test", Status: models.StatusActive}, nil
+}
+
+// countingLockout locks the account after maxFailures failures
+type countingLockout struct {
+	LockoutService
+	maxFailures int
+	failures    int
+}
+
+func (l *countingLockout) Check(context.Context, string, string) error {
+	if l.failures >= l.maxFailures {
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (l *countingLockout) RegisterFailure(context.Context, string, string) error {
+	l.failures++
+	if l.failures >= l.maxFailures {
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (l *countingLockout) RegisterSuccess(context.Context, string) error {
+	l.failures = 0
+	return nil
+}
+
+// TestMFAManagementCountsWrongCodes guesses codes at the endpoints of a signed in user, they are limited by the
+// login lockout like the MFA step of a login
+func TestMFAManagementCountsWrongCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		// call runs the operation with a TOTP code
+		call func(s MFAService, code string) error
+		// done reports whether the operation went through
+		done func(repo *fakeMFARepo) bool
+	}{
+		{
+			name: "disable",
+			call: func(s MFAService, code string) error { return s.Disable(context.Background(), "user-1", code, "") },
+			done: func(repo *fakeMFARepo) bool { return repo.deleted },
+		},
+		{
+			name: "regenerate recovery codes",
+			call: func(s MFAService, code string) error {
+				_, err := s.RegenerateRecoveryCodes(context.Background(), "user-1", code)
+				return err
+			},
+			done: func(repo *fakeMFARepo) bool { return repo.replaced },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeMFARepo{config: &models.MFAConfig{UserID: "user-1", Secret: testTOTPSecret, Enabled: true}}
+			lockout := &countingLockout{maxFailures: 3}
+			s := NewMFAService(repo, nil, fakeMFAUsers{}, nil, lockout, zap.NewNop(), "test", time.Minute)
+
+			for i := 1; i <= 2; i++ {
+				if err := tt.call(s, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+					t.Fatalf("wrong code %d: err = %v, want ErrInvalidMFACode", i, err)
+				}
+			}
+			if err := tt.call(s, "000000"); !errors.As(err, new(*LockoutError)) {
+				t.Fatalf("wrong code 3: err = %v, want a lockout", err)
+			}
+			if lockout.failures != 3 {
+				t.Errorf("%d failures registered, want 3", lockout.failures)
+			}
+
+			// once locked even the right code is refused
+			code, _ := totp.Code(testTOTPSecret, totp.Step(time.Now()))
+			if err := tt.call(s, code); !errors.As(err, new(*LockoutError)) || tt.done(repo) {
+				t.Fatalf("right code while locked: err = %v, done %v", err, tt.done(repo))
+			}
+
+			lockout.failures = 2
+			if err := tt.call(s, code); err != nil || !tt.done(repo) {
+				t.Fatalf("right code: err = %v, done %v", err, tt.done(repo))
+			}
+			if lockout.failures != 0 {
+				t.Errorf("a valid code left %d failures, want them reset", lockout.failures)
+			}
+		})
+	}
+}
+
+func TestMFADisableCountsWrongRecoveryCodes(t *testing.T) {
+	repo := &fakeMFARepo{config: &models.MFAConfig{UserID: "user-1", Secret: testTOTPSecret, Enabled: true}}
+	lockout := &countingLockout{maxFailures: 3}
+	s := NewMFAService(repo, nil, fakeMFAUsers{}, nil, lockout, zap.NewNop(), "test", time.Minute)
+
+	err := s.Disable(context.Background(), "user-1", "", "abcde-fghij")
+	if !errors.Is(err, ErrInvalidMFACode) || lockout.failures != 1 || repo.deleted {
+		t.Errorf("err = %v, %d failures, deleted %v", err, lockout.failures, repo.deleted)
+	}
+}
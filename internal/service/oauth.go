@@ -0,0 +1,672 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"user_service/internal/events"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+)
+
+// OAuthService is the OAuth2 authorization server and OpenID Connect provider. Refresh tokens issued to
+// clients are opaque and stored by the AuthRepository next to the first-party ones, sharing rotation and
+// reuse detection.
+type OAuthService interface {
+	CreateClient(ctx context.Context, input models.CreateOAuthClientInput) (*models.OAuthClient, string, error)
+	ListClients(ctx context.Context) ([]*models.OAuthClient, error)
+	DeleteClient(ctx context.Context, id string) error
+	// ValidateAuthorization checks the client and redirect uri of an authorization request. Errors returned
+	// here must be shown to the user, the redirect uri cannot be trusted.
+	ValidateAuthorization(ctx context.Context, req models.AuthorizeRequest) (*models.OAuthClient, error)
+	// Authorize issues an authorization code for a signed in user and returns the redirect back to the client,
+	// carrying either the code or an OAuth error
+	Authorize(ctx context.Context, userID string, authTime time.Time, req models.AuthorizeRequest) (string, error)
+	Token(ctx context.Context, req models.TokenRequest) (*models.TokenResponse, error)
+	// AuthenticateService checks the credentials of a service account calling one of the token management endpoints
+	AuthenticateService(ctx context.Context, clientID, secret string) (*models.OAuthClient, error)
+	// UserInfo returns the OpenID Connect claims of the user released by scope
+	UserInfo(ctx context.Context, userID string, scope string) (map[string]interface{}, error)
+}
+
+// OAuth error codes of RFC 6749 section 4.1.2.1 and 5.2
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthAccessDenied            = "access_denied"
+	OAuthServerError             = "server_error"
+)
+
+// OAuthError is an error that is returned to the client as is
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+var (
+	ErrClientNotFound = errors.New("oauth client not found")
+)
+
+const (
+	codeChallengeS256 = "S256"
+	tokenTypeBearer   = "Bearer"
+)
+
+type oauthService struct {
+	clientRepo  repository.OAuthClientRepository
+	codeRepo    repository.AuthorizationCodeRepository
+	userRepo    repository.UserRepository
+	authRepo    repository.AuthRepository
+	revocations repository.RevocationStore
+	jwtService  *util.JwtImpl
+	events      events.Publisher
+	log         *zap.Logger
+	config      models.OAuthConfig
+	idTokenTTL  time.Duration
+	accessTTL   time.Duration
+}
+
+func NewOAuthService(clientRepo repository.OAuthClientRepository, codeRepo repository.AuthorizationCodeRepository,
+	userRepo repository.UserRepository, authRepo repository.AuthRepository, revocations repository.RevocationStore,
+	jwtService *util.JwtImpl, events events.Publisher, log *zap.Logger, config models.OAuthConfig) OAuthService {
+	return &oauthService{
+		clientRepo:  clientRepo,
+		codeRepo:    codeRepo,
+		userRepo:    userRepo,
+		authRepo:    authRepo,
+		revocations: revocations,
+		jwtService:  jwtService,
+		events:      events,
+		log:         log,
+		config:      config,
+		idTokenTTL:  util.ACCESS_TOKEN_EXPIRED_TIME,
+		accessTTL:   util.ACCESS_TOKEN_EXPIRED_TIME,
+	}
+}
+
+func (s *oauthService) CreateClient(ctx context.Context, input models.CreateOAuthClientInput) (*models.OAuthClient, string, error) {
+	if err := input.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	scopes := input.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail}
+	}
+
+	client := &models.OAuthClient{
+		ID:           uuid.NewString(),
+		Name:         strings.TrimSpace(input.Name),
+		RedirectURIs: input.RedirectURIs,
+		GrantTypes:   input.GrantTypes,
+		Scopes:       scopes,
+		Public:       input.Public,
+		CreatedAt:    time.Now(),
+	}
+
+	var secret string
+	if !client.Public {
+		var err error
+		if secret, err = util.GenerateToken(32); err != nil {
+			s.log.Error("[Service][OAuth][CreateClient] failed to generate secret", zap.Error(err))
+			return nil, "", err
+		}
+		client.SecretHash = util.HashToken(secret)
+	}
+
+	if err := s.clientRepo.Create(ctx, client); err != nil {
+		s.log.Error("[Service][OAuth][CreateClient] failed to create client", zap.Error(err))
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (s *oauthService) ListClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	clients, err := s.clientRepo.List(ctx)
+	if err != nil {
+		s.log.Error("[Service][OAuth][ListClients] failed to list clients", zap.Error(err))
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteClient also rejects the access tokens the client got with its own credentials, tokens it holds
+// for users expire on their own
+func (s *oauthService) DeleteClient(ctx context.Context, id string) error {
+	if err := s.clientRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, postgres.ErrClientNotFound) {
+			return ErrClientNotFound
+		}
+		s.log.Error("[Service][OAuth][DeleteClient] failed to delete client", zap.Error(err))
+		return err
+	}
+
+	if err := s.revocations.SetValidAfter(ctx, id, time.Now()); err != nil {
+		s.log.Error("[Service][OAuth][DeleteClient] failed to revoke access tokens", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *oauthService) ValidateAuthorization(ctx context.Context, req models.AuthorizeRequest) (*models.OAuthClient, error) {
+	if req.ClientID == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "client_id is required"}
+	}
+
+	client, err := s.clientRepo.GetByID(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrClientNotFound) {
+			return nil, &OAuthError{Code: OAuthInvalidClient, Description: "unknown client"}
+		}
+		s.log.Error("[Service][OAuth][Authorize] failed to get client", zap.Error(err))
+		return nil, err
+	}
+
+	// redirect uris are compared exactly, a prefix match would let an attacker pick the path the code is sent to
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "redirect_uri is not registered for the client"}
+	}
+
+	return client, nil
+}
+
+func (s *oauthService) Authorize(ctx context.Context, userID string, authTime time.Time, req models.AuthorizeRequest) (string, error) {
+	client, err := s.ValidateAuthorization(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	redirect := func(err *OAuthError) (string, error) {
+		return redirectWithParams(req.RedirectURI, map[string]string{
+			"error":             err.Code,
+			"error_description": err.Description,
+			"state":             req.State,
+		}), nil
+	}
+
+	if req.ResponseType != "code" {
+		return redirect(&OAuthError{Code: OAuthUnsupportedResponseType, Description: "only the code response type is supported"})
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return redirect(&OAuthError{Code: OAuthUnauthorizedClient, Description: "client may not use the authorization code grant"})
+	}
+
+	// PKCE is mandatory for public clients, plain challenges are not accepted
+	if req.CodeChallenge == "" && client.Public {
+		return redirect(&OAuthError{Code: OAuthInvalidRequest, Description: "code_challenge is required"})
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != codeChallengeS256 {
+		return redirect(&OAuthError{Code: OAuthInvalidRequest, Description: "code_challenge_method must be S256"})
+	}
+
+	scope, oauthErr := grantedScope(req.Scope, client.Scopes)
+	if oauthErr != nil {
+		return redirect(oauthErr)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][OAuth][Authorize] failed to get user", zap.Error(err))
+		return "", err
+	}
+	if user.Status != models.StatusActive {
+		return redirect(&OAuthError{Code: OAuthAccessDenied, Description: "account is not active"})
+	}
+
+	code, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][OAuth][Authorize] failed to generate code", zap.Error(err))
+		return "", err
+	}
+
+	err = s.codeRepo.Create(ctx, &models.AuthorizationCode{
+		CodeHash:            util.HashToken(code),
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            authTime,
+		ExpiresAt:           time.Now().Add(s.config.AuthorizationCodeTTL),
+		CreatedAt:           time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][OAuth][Authorize] failed to store code", zap.Error(err))
+		return "", err
+	}
+
+	return redirectWithParams(req.RedirectURI, map[string]string{"code": code, "state": req.State}), nil
+}
+
+func (s *oauthService) Token(ctx context.Context, req models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case models.GrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req)
+	case models.GrantRefreshToken:
+		return s.refresh(ctx, client, req)
+	case models.GrantClientCredentials:
+		return s.clientCredentials(ctx, client, req)
+	case "":
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "grant_type is required"}
+	}
+
+	return nil, &OAuthError{Code: OAuthUnsupportedGrantType, Description: "unsupported grant type " + req.GrantType}
+}
+
+func (s *oauthService) AuthenticateService(ctx context.Context, clientID, secret string) (*models.OAuthClient, error) {
+	client, err := s.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsServiceAccount() {
+		return nil, &OAuthError{Code: OAuthUnauthorizedClient, Description: "only service accounts may use this endpoint"}
+	}
+
+	return client, nil
+}
+
+// authenticateClient checks the client secret, public clients only identify themselves
+func (s *oauthService) authenticateClient(ctx context.Context, clientID, secret string) (*models.OAuthClient, error) {
+	invalid := &OAuthError{Code: OAuthInvalidClient, Description: "client authentication failed"}
+	if clientID == "" {
+		return nil, invalid
+	}
+
+	client, err := s.clientRepo.GetByID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrClientNotFound) {
+			return nil, invalid
+		}
+		s.log.Error("[Service][OAuth][Token] failed to get client", zap.Error(err))
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, invalid
+		}
+		return client, nil
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(util.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalid
+	}
+
+	return client, nil
+}
+
+func (s *oauthService) exchangeCode(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenResponse, error) {
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, &OAuthError{Code: OAuthUnauthorizedClient, Description: "client may not use the authorization code grant"}
+	}
+	if req.Code == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "code is required"}
+	}
+
+	invalid := &OAuthError{Code: OAuthInvalidGrant, Description: "invalid authorization code"}
+	code, err := s.codeRepo.Consume(ctx, util.HashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, postgres.ErrInvalidToken) {
+			return nil, invalid
+		}
+		s.log.Error("[Service][OAuth][Token] failed to consume code", zap.Error(err))
+		return nil, err
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI {
+		return nil, invalid
+	}
+
+	// RFC 7636 section 4.6, a verifier without a challenge is rejected as well to prevent PKCE downgrades
+	if code.CodeChallenge != "" || req.CodeVerifier != "" {
+		if code.CodeChallenge == "" || req.CodeVerifier == "" {
+			return nil, invalid
+		}
+		sum := sha256.Sum256([]byte(req.CodeVerifier))
+		challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+			return nil, invalid
+		}
+	}
+
+	user, err := s.activeUser(ctx, code.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, client, user, code.Scope, code.Nonce, code.AuthTime, nil)
+}
+
+func (s *oauthService) refresh(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenResponse, error) {
+	if !client.AllowsGrant(models.GrantRefreshToken) {
+		return nil, &OAuthError{Code: OAuthUnauthorizedClient, Description: "client may not use the refresh token grant"}
+	}
+	if req.RefreshToken == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "refresh_token is required"}
+	}
+
+	invalid := &OAuthError{Code: OAuthInvalidGrant, Description: "invalid refresh token"}
+	stored, err := s.authRepo.GetByToken(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, postgres.ErrInvalidToken) || errors.Is(err, postgres.ErrExpiredToken) {
+			return nil, invalid
+		}
+		s.log.Error("[Service][OAuth][Token] failed to get refresh token", zap.Error(err))
+		return nil, err
+	}
+
+	// first-party refresh tokens and tokens of other clients are rejected alike
+	if stored.ClientID != client.ID {
+		return nil, invalid
+	}
+	if stored.RotatedAt != nil {
+		return nil, s.handleTokenReuse(ctx, stored)
+	}
+	if stored.IsRevoked {
+		return nil, invalid
+	}
+
+	// the client may ask for fewer scopes than originally granted, never for more
+	scope := stored.Scope
+	if req.Scope != "" {
+		for _, requested := range models.ParseScope(req.Scope) {
+			if !models.HasScope(stored.Scope, requested) {
+				return nil, &OAuthError{Code: OAuthInvalidScope, Description: "scope exceeds the original grant"}
+			}
+		}
+		scope = strings.Join(models.ParseScope(req.Scope), " ")
+	}
+
+	if err := s.authRepo.RotateToken(ctx, req.RefreshToken); err != nil {
+		if errors.Is(err, postgres.ErrTokenUsed) {
+			return nil, s.handleTokenReuse(ctx, stored)
+		}
+		s.log.Error("[Service][OAuth][Token] failed to rotate refresh token", zap.Error(err))
+		return nil, err
+	}
+
+	user, err := s.activeUser(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session start stands in for auth_time, the nonce only belongs in the first ID token
+	return s.issueTokens(ctx, client, user, scope, "", stored.CreatedAt, stored)
+}
+
+// handleTokenReuse revokes the whole token family, like the first-party refresh flow does
+func (s *oauthService) handleTokenReuse(ctx context.Context, token *models.RefreshTokenData) error {
+	s.log.Warn("[Service][OAuth][Token] refresh token reuse detected",
+		zap.String("userID", token.UserID), zap.String("clientID", token.ClientID), zap.String("familyID", token.FamilyID))
+
+	if err := s.authRepo.RevokeFamily(ctx, token.FamilyID); err != nil {
+		s.log.Error("[Service][OAuth][Token] failed to revoke token family", zap.Error(err))
+		return err
+	}
+
+	s.events.Publish(ctx, events.Event{
+		Type:     events.TypeRefreshTokenReuse,
+		Severity: events.SeverityCritical,
+		UserID:   token.UserID,
+		Metadata: map[string]string{
+			"familyID": token.FamilyID,
+			"tokenID":  token.ID,
+			"clientID": token.ClientID,
+		},
+	})
+
+	return &OAuthError{Code: OAuthInvalidGrant, Description: "invalid refresh token"}
+}
+
+// clientCredentials issues a token for the client itself, it has no user and no refresh token. AuthMiddleware
+// accepts it and ACLMiddleware authorizes it on its scopes, see sub_type.
+func (s *oauthService) clientCredentials(ctx context.Context, client *models.OAuthClient, req models.TokenRequest) (*models.TokenResponse, error) {
+	if client.Public || !client.AllowsGrant(models.GrantClientCredentials) {
+		return nil, &OAuthError{Code: OAuthUnauthorizedClient, Description: "client may not use the client credentials grant"}
+	}
+
+	scope, oauthErr := grantedScope(req.Scope, client.Scopes)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	now := time.Now()
+	accessToken, err := s.jwtService.SignAccessToken(jwt.MapClaims{
+		"sub":       client.ID,
+		"sub_type":  models.SubTypeService,
+		"client_id": client.ID,
+		"aud":       client.ID,
+		"scope":     scope,
+		"jti":       uuid.NewString(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.accessTTL).Unix(),
+	})
+	if err != nil {
+		s.log.Error("[Service][OAuth][Token] failed to sign access token", zap.Error(err))
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenTypeBearer,
+		ExpiresIn:   int(s.accessTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+func (s *oauthService) activeUser(ctx context.Context, userID string) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "user no longer exists"}
+		}
+		s.log.Error("[Service][OAuth][Token] failed to get user", zap.Error(err))
+		return nil, err
+	}
+
+	if user.Status != models.StatusActive {
+		return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "account is not active"}
+	}
+
+	return user, nil
+}
+
+// issueTokens creates the access token, the ID token for openid requests and a refresh token when the client
+// may refresh. previous is the refresh token being rotated, its session carries over to the new one.
+func (s *oauthService) issueTokens(ctx context.Context, client *models.OAuthClient, user *models.User, scope, nonce string,
+	authTime time.Time, previous *models.RefreshTokenData) (*models.TokenResponse, error) {
+	now := time.Now()
+
+	// the access token is also accepted by AuthMiddleware, aud and scope tell resource servers which client holds it
+	accessToken, err := s.jwtService.SignAccessToken(jwt.MapClaims{
+		"userID":    user.ID,
+		"role":      user.Role,
+		"sub":       user.ID,
+		"client_id": client.ID,
+		"aud":       client.ID,
+		"scope":     scope,
+		"jti":       uuid.NewString(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.accessTTL).Unix(),
+	})
+	if err != nil {
+		s.log.Error("[Service][OAuth][Token] failed to sign access token", zap.Error(err))
+		return nil, err
+	}
+
+	response := &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenTypeBearer,
+		ExpiresIn:   int(s.accessTTL.Seconds()),
+		Scope:       scope,
+	}
+
+	if models.HasScope(scope, models.ScopeOpenID) {
+		if response.IDToken, err = s.idToken(client, user, scope, nonce, authTime); err != nil {
+			s.log.Error("[Service][OAuth][Token] failed to sign id token", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		refreshToken, err := util.GenerateToken(32)
+		if err != nil {
+			s.log.Error("[Service][OAuth][Token] failed to generate refresh token", zap.Error(err))
+			return nil, err
+		}
+
+		info := util.ClientInfoFromContext(ctx)
+		token := &models.RefreshTokenData{
+			ID:          uuid.NewString(),
+			UserID:      user.ID,
+			Token:       refreshToken,
+			FamilyID:    uuid.NewString(),
+			ExpiresAt:   now.Add(s.config.RefreshTokenTTL),
+			IssuedAt:    now,
+			UserAgent:   info.UserAgent,
+			IPAddress:   info.IPAddress,
+			DeviceLabel: info.DeviceLabel,
+			CreatedAt:   now,
+			LastUsedAt:  now,
+			ClientID:    client.ID,
+			Scope:       scope,
+		}
+		if previous != nil {
+			token.FamilyID = previous.FamilyID
+			token.UserAgent = previous.UserAgent
+			token.DeviceLabel = previous.DeviceLabel
+			token.CreatedAt = previous.CreatedAt
+		}
+
+		if err := s.authRepo.Create(ctx, token); err != nil {
+			s.log.Error("[Service][OAuth][Token] failed to store refresh token", zap.Error(err))
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	return response, nil
+}
+
+// idToken builds the OpenID Connect ID token, the profile claims depend on the granted scopes
+func (s *oauthService) idToken(client *models.OAuthClient, user *models.User, scope, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":       user.ID,
+		"aud":       client.ID,
+		"azp":       client.ID,
+		"auth_time": authTime.Unix(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.idTokenTTL).Unix(),
+	}
+	// marks the token so it is never accepted as an access token, it is signed with the same keys
+	claims[util.ClaimTokenUse] = util.TokenUseID
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for k, v := range userClaims(user, scope) {
+		claims[k] = v
+	}
+
+	return s.jwtService.Sign(claims)
+}
+
+func (s *oauthService) UserInfo(ctx context.Context, userID string, scope string) (map[string]interface{}, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.Error("[Service][OAuth][UserInfo] failed to get user", zap.Error(err))
+		return nil, err
+	}
+
+	claims := userClaims(user, scope)
+	claims["sub"] = user.ID
+	return claims, nil
+}
+
+// userClaims maps the user to the standard OpenID Connect claims released by the scope
+func userClaims(user *models.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{}
+
+	if models.HasScope(scope, models.ScopeProfile) {
+		claims["name"] = user.FullName
+		claims["updated_at"] = user.UpdatedAt.Unix()
+		if user.Avatar != "" {
+			claims["picture"] = user.Avatar
+		}
+	}
+	if models.HasScope(scope, models.ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.Status != models.StatusPendingVerification
+	}
+	if models.HasScope(scope, models.ScopePhone) && user.Phone != "" {
+		claims["phone_number"] = user.Phone
+	}
+
+	return claims
+}
+
+// grantedScope checks the requested scopes against the scopes of the client, no scope requests all of them
+func grantedScope(requested string, allowed []string) (string, *OAuthError) {
+	scopes := models.ParseScope(requested)
+	if len(scopes) == 0 {
+		return strings.Join(allowed, " "), nil
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return "", &OAuthError{Code: OAuthInvalidScope, Description: "scope " + scope + " is not allowed for the client"}
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// redirectWithParams adds the non-empty params to the query of a registered redirect uri
+func redirectWithParams(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
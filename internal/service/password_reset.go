@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+	"user_service/pkg/mail"
+)
+
+type PasswordResetService interface {
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+type passwordResetService struct {
+	userService UserService
+	tokenRepo   repository.OneTimeTokenRepository
+	authRepo    repository.AuthRepository
+	lockout     LockoutService
+	mailer      mail.Sender
+	log         *zap.Logger
+	resetURL    string
+	tokenTTL    time.Duration
+}
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrorSendingMail     = errors.New("failed to send mail")
+)
+
+// NewPasswordResetService creates the forgot/reset password flow. resetURL is the frontend page
+// receiving the token as a query parameter.
+func NewPasswordResetService(userService UserService, tokenRepo repository.OneTimeTokenRepository, authRepo repository.AuthRepository,
+	lockout LockoutService, mailer mail.Sender, log *zap.Logger, resetURL string, tokenTTL time.Duration) PasswordResetService {
+	return &passwordResetService{
+		userService: userService,
+		tokenRepo:   tokenRepo,
+		authRepo:    authRepo,
+		lockout:     lockout,
+		mailer:      mailer,
+		log:         log,
+		resetURL:    resetURL,
+		tokenTTL:    tokenTTL,
+	}
+}
+
+// ForgotPassword mails a reset link. Unknown emails are ignored and mail failures only logged so the endpoint
+// does not reveal which accounts exist.
+func (s *passwordResetService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userService.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			s.log.Info("[Service][ForgotPassword] reset requested for unknown email")
+			return nil
+		}
+		s.log.Error("[Service][ForgotPassword] failed to get user", zap.Error(err))
+		return ErrorGetUser
+	}
+
+	// only the most recent link stays valid
+	if err := s.tokenRepo.InvalidateByUserID(ctx, user.ID); err != nil {
+		s.log.Error("[Service][ForgotPassword] failed to invalidate previous tokens", zap.Error(err))
+		return err
+	}
+
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][ForgotPassword] failed to generate token", zap.Error(err))
+		return err
+	}
+
+	err = s.tokenRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    user.ID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(s.tokenTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.log.Error("[Service][ForgotPassword] failed to store token", zap.Error(err))
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Đặt lại mật khẩu",
+		Body: fmt.Sprintf("Xin chào %s,\n\nVui lòng truy cập liên kết sau để đặt lại mật khẩu (hết hạn sau %s):\n%s?token=%s\n\nNếu bạn không yêu cầu, hãy bỏ qua email này.",
+			user.FullName, s.tokenTTL, s.resetURL, token),
+	})
+	if err != nil {
+		// answered like an unknown email, a failure here would reveal that the account exists
+		s.log.Error("[Service][ForgotPassword] failed to send mail", zap.String("userID", user.ID), zap.Error(err))
+		return nil
+	}
+
+	return nil
+}
+
+// ResetPassword consumes the reset token, sets the new password, signs the user out everywhere and lifts a login lockout.
+func (s *passwordResetService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	stored, err := s.tokenRepo.GetByHash(ctx, util.HashToken(token))
+	if err != nil {
+		s.log.Error("[Service][ResetPassword] invalid reset token", zap.Error(err))
+		return ErrInvalidResetToken
+	}
+
+	// a password rejected by the policy must not burn the token
+	if err := s.userService.CheckPassword(ctx, stored.UserID, newPassword); err != nil {
+		s.log.Info("[Service][ResetPassword] password rejected", zap.Error(err))
+		return err
+	}
+
+	// consume first so two concurrent requests cannot both use the token
+	if err := s.tokenRepo.MarkUsed(ctx, stored.ID); err != nil {
+		s.log.Error("[Service][ResetPassword] failed to consume reset token", zap.Error(err))
+		return ErrInvalidResetToken
+	}
+
+	if err := s.userService.SetPassword(ctx, stored.UserID, newPassword); err != nil {
+		s.log.Error("[Service][ResetPassword] failed to set password", zap.Error(err))
+		return err
+	}
+
+	if err := s.tokenRepo.InvalidateByUserID(ctx, stored.UserID); err != nil {
+		s.log.Error("[Service][ResetPassword] failed to invalidate remaining tokens", zap.Error(err))
+		return err
+	}
+
+	if err := s.authRepo.RevokeAllTokens(ctx, stored.UserID); err != nil {
+		s.log.Error("[Service][ResetPassword] failed to revoke refresh tokens", zap.Error(err))
+		return err
+	}
+
+	// proving control of the mailbox is enough to lift a lockout
+	user, err := s.userService.GetByID(ctx, stored.UserID)
+	if err != nil {
+		s.log.Error("[Service][ResetPassword] failed to get user", zap.Error(err))
+		return err
+	}
+
+	if err := s.lockout.Unlock(ctx, user.Email); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+	"user_service/internal/util"
+)
+
+// PersonalAccessTokenService manages the long-lived tokens users create for scripts and resolves them to
+// the same claims an access token of the user carries
+type PersonalAccessTokenService interface {
+	Create(ctx context.Context, userID string, input models.CreatePersonalAccessTokenInput) (*models.CreatePersonalAccessTokenResponse, error)
+	List(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error)
+	Revoke(ctx context.Context, userID string, id string) error
+	// RevokeToken revokes a token by its value, unknown tokens are ignored
+	RevokeToken(ctx context.Context, token string) error
+	// Authenticate returns the claims for a raw token, scope holds the scopes of the token
+	Authenticate(ctx context.Context, token string) (jwt.MapClaims, error)
+}
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidPersonalAccessToken  = errors.New("invalid, expired or revoked personal access token")
+	ErrScopeNotAllowed             = errors.New("scope is not allowed for this account")
+)
+
+// TokenTypePersonalAccessToken is the token_type claim of requests authenticated with a personal access token
+const TokenTypePersonalAccessToken = "pat"
+
+// lastUsedInterval is how stale the last used timestamp of a token may get
+const lastUsedInterval = time.Minute
+
+type personalAccessTokenService struct {
+	tokenRepo repository.PersonalAccessTokenRepository
+	userRepo  repository.UserRepository
+	log       *zap.Logger
+}
+
+func NewPersonalAccessTokenService(tokenRepo repository.PersonalAccessTokenRepository, userRepo repository.UserRepository,
+	log *zap.Logger) PersonalAccessTokenService {
+	return &personalAccessTokenService{tokenRepo: tokenRepo, userRepo: userRepo, log: log}
+}
+
+func (s *personalAccessTokenService) Create(ctx context.Context, userID string, input models.CreatePersonalAccessTokenInput) (*models.CreatePersonalAccessTokenResponse, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][PersonalAccessToken][Create] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+	if slices.Contains(input.Scopes, models.ScopeAdmin) && user.Role != models.RoleAdmin {
+		return nil, ErrScopeNotAllowed
+	}
+
+	secret, err := util.GenerateToken(32)
+	if err != nil {
+		s.log.Error("[Service][PersonalAccessToken][Create] failed to generate token", zap.Error(err))
+		return nil, err
+	}
+	raw := models.PersonalAccessTokenPrefix + secret
+
+	token := &models.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(input.Name),
+		TokenHash: util.HashToken(raw),
+		Prefix:    raw[:len(models.PersonalAccessTokenPrefix)+6],
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(input.Scopes))),
+		CreatedAt: time.Now(),
+	}
+	if input.ExpiresInDays > 0 {
+		expiresAt := token.CreatedAt.AddDate(0, 0, input.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		s.log.Error("[Service][PersonalAccessToken][Create] failed to store token", zap.Error(err))
+		return nil, err
+	}
+
+	s.log.Info("[Service][PersonalAccessToken] token created", zap.String("userID", user.ID), zap.String("tokenID", token.ID))
+	return &models.CreatePersonalAccessTokenResponse{Token: token, AccessToken: raw}, nil
+}
+
+func (s *personalAccessTokenService) List(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error) {
+	tokens, err := s.tokenRepo.ListByUser(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][PersonalAccessToken][List] failed to list tokens", zap.Error(err))
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *personalAccessTokenService) Revoke(ctx context.Context, userID string, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	if err := s.tokenRepo.Revoke(ctx, userID, id); err != nil {
+		if errors.Is(err, postgres.ErrPersonalAccessTokenNotFound) {
+			return ErrPersonalAccessTokenNotFound
+		}
+		s.log.Error("[Service][PersonalAccessToken][Revoke] failed to revoke token", zap.Error(err))
+		return err
+	}
+
+	s.log.Info("[Service][PersonalAccessToken] token revoked", zap.String("userID", userID), zap.String("tokenID", id))
+	return nil
+}
+
+func (s *personalAccessTokenService) RevokeToken(ctx context.Context, raw string) error {
+	token, err := s.tokenRepo.GetByHash(ctx, util.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, postgres.ErrPersonalAccessTokenNotFound) {
+			return nil
+		}
+		s.log.Error("[Service][PersonalAccessToken][RevokeToken] failed to get token", zap.Error(err))
+		return err
+	}
+
+	return s.Revoke(ctx, token.UserID, token.ID)
+}
+
+// Authenticate looks the user up on every request, so a role change or a deactivated account takes
+// effect immediately instead of when a JWT would expire
+func (s *personalAccessTokenService) Authenticate(ctx context.Context, raw string) (jwt.MapClaims, error) {
+	if !strings.HasPrefix(raw, models.PersonalAccessTokenPrefix) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	token, err := s.tokenRepo.GetByHash(ctx, util.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, postgres.ErrPersonalAccessTokenNotFound) {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		s.log.Error("[Service][PersonalAccessToken][Authenticate] failed to get token", zap.Error(err))
+		return nil, err
+	}
+	if token.Expired(time.Now()) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		s.log.Error("[Service][PersonalAccessToken][Authenticate] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+	if user.Status != models.StatusActive {
+		return nil, ErrAccountInactive
+	}
+
+	if err := s.tokenRepo.TouchLastUsed(ctx, token.ID, lastUsedInterval); err != nil {
+		s.log.Error("[Service][PersonalAccessToken][Authenticate] failed to update last used", zap.Error(err))
+	}
+
+	// numeric dates are float64 as in the claims of a parsed JWT, so the jwt.MapClaims getters work
+	claims := jwt.MapClaims{
+		"userID":     user.ID,
+		"role":       user.Role,
+		"sub":        user.ID,
+		"jti":        token.ID,
+		"scope":      strings.Join(token.Scopes, " "),
+		"token_type": TokenTypePersonalAccessToken,
+		"iat":        float64(token.CreatedAt.Unix()),
+	}
+	if token.ExpiresAt != nil {
+		claims["exp"] = float64(token.ExpiresAt.Unix())
+	}
+
+	return claims, nil
+}
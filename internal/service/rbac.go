@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/postgres"
+)
+
+// RBACService manages roles, the permissions they grant and the roles of users, and answers permission
+// checks. Roles and permissions are cached for the configured TTL, changes made through the service
+// invalidate the cache of this instance, other instances pick them up when their entries expire.
+type RBACService interface {
+	ListRoles(ctx context.Context) ([]*models.Role, error)
+	GetRole(ctx context.Context, id string) (*models.Role, error)
+	// SaveRole creates the role or replaces its name, description and permissions
+	SaveRole(ctx context.Context, id string, input models.SaveRoleInput) (*models.Role, error)
+	DeleteRole(ctx context.Context, id string) error
+	ListPermissions(ctx context.Context) ([]*models.Permission, error)
+
+	GetUserRoles(ctx context.Context, userID string) (*models.UserRoles, error)
+	AssignRole(ctx context.Context, userID string, input models.AssignRoleInput) (*models.UserRoles, error)
+	UnassignRole(ctx context.Context, userID string, roleID string) (*models.UserRoles, error)
+
+	// UserRoles returns the primary role of the user followed by the additional ones
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	// RolesHavePermission reports whether any of the roles grants the permission
+	RolesHavePermission(ctx context.Context, roles []string, permission string) (bool, error)
+	// RolePermissions returns the permissions granted by any of the roles
+	RolePermissions(ctx context.Context, roles []string) ([]string, error)
+	HasPermission(ctx context.Context, userID string, permission string) (bool, error)
+}
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrSystemRole         = errors.New("system roles cannot be deleted and the admin role cannot be changed")
+	ErrUserRoleNotFound   = errors.New("user does not have the role")
+	ErrPrimaryRole        = errors.New("the primary role of a user is changed by updating the user")
+	ErrorCheckPermissions = errors.New("failed to check permissions")
+)
+
+type cachedStrings struct {
+	values    []string
+	expiresAt time.Time
+}
+
+type rbacService struct {
+	roleRepo repository.RoleRepository
+	userRepo repository.UserRepository
+	audit    AuditService
+	log      *zap.Logger
+	cacheTTL time.Duration
+
+	mu        sync.RWMutex
+	userRoles map[string]cachedStrings
+	rolePerms map[string]cachedStrings
+}
+
+func NewRBACService(roleRepo repository.RoleRepository, userRepo repository.UserRepository, audit AuditService, log *zap.Logger,
+	cacheTTL time.Duration) RBACService {
+	return &rbacService{
+		roleRepo:  roleRepo,
+		userRepo:  userRepo,
+		audit:     audit,
+		log:       log,
+		cacheTTL:  cacheTTL,
+		userRoles: make(map[string]cachedStrings),
+		rolePerms: make(map[string]cachedStrings),
+	}
+}
+
+func (s *rbacService) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	roles, err := s.roleRepo.List(ctx)
+	if err != nil {
+		s.log.Error("[Service][RBAC][ListRoles] failed to list roles", zap.Error(err))
+		return nil, err
+	}
+	for _, role := range roles {
+		withAdminPermissions(role)
+	}
+	return roles, nil
+}
+
+func (s *rbacService) GetRole(ctx context.Context, id string) (*models.Role, error) {
+	role, err := s.roleRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, postgres.ErrRoleNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		s.log.Error("[Service][RBAC][GetRole] failed to get role", zap.Error(err))
+		return nil, err
+	}
+	return withAdminPermissions(role), nil
+}
+
+func (s *rbacService) SaveRole(ctx context.Context, id string, input models.SaveRoleInput) (*models.Role, error) {
+	if err := models.ValidateRoleID(id); err != nil {
+		return nil, err
+	}
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	if id == models.RoleAdmin {
+		return nil, ErrSystemRole
+	}
+
+	role := &models.Role{
+		ID:          id,
+		Name:        strings.TrimSpace(input.Name),
+		Description: input.Description,
+		Permissions: slices.Compact(slices.Sorted(slices.Values(input.Permissions))),
+	}
+	var before map[string]any
+	if existing, err := s.roleRepo.GetByID(ctx, id); err == nil {
+		before = auditRole(existing)
+	}
+	if err := s.roleRepo.Upsert(ctx, role); err != nil {
+		s.log.Error("[Service][RBAC][SaveRole] failed to save role", zap.Error(err))
+		return nil, err
+	}
+	s.invalidateRole(id)
+
+	s.log.Info("[Service][RBAC] role saved", zap.String("roleID", id), zap.Strings("permissions", role.Permissions))
+	after := auditRole(role)
+	if before != nil {
+		before, after = auditDiff(before, after)
+	}
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditRoleSave, TargetType: models.AuditTargetRole, TargetID: id,
+		Before: before, After: after})
+	return role, nil
+}
+
+func (s *rbacService) DeleteRole(ctx context.Context, id string) error {
+	role, err := s.GetRole(ctx, id)
+	if err != nil {
+		return err
+	}
+	if role.System {
+		return ErrSystemRole
+	}
+
+	if err := s.roleRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, postgres.ErrRoleNotFound) {
+			return ErrRoleNotFound
+		}
+		s.log.Error("[Service][RBAC][DeleteRole] failed to delete role", zap.Error(err))
+		return err
+	}
+	// the assignments went with the role
+	s.invalidateRole(id)
+	s.invalidateUsers()
+
+	s.log.Info("[Service][RBAC] role deleted", zap.String("roleID", id))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditRoleDelete, TargetType: models.AuditTargetRole, TargetID: id,
+		Before: auditRole(role)})
+	return nil
+}
+
+func (s *rbacService) ListPermissions(ctx context.Context) ([]*models.Permission, error) {
+	permissions, err := s.roleRepo.ListPermissions(ctx)
+	if err != nil {
+		s.log.Error("[Service][RBAC][ListPermissions] failed to list permissions", zap.Error(err))
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (s *rbacService) GetUserRoles(ctx context.Context, userID string) (*models.UserRoles, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.Error("[Service][RBAC][GetUserRoles] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+
+	additional, err := s.roleRepo.UserRoles(ctx, user.ID)
+	if err != nil {
+		s.log.Error("[Service][RBAC][GetUserRoles] failed to get roles", zap.Error(err))
+		return nil, err
+	}
+	roles := effectiveRoles(user.Role, additional)
+
+	permissions, err := s.permissionsOf(ctx, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UserRoles{UserID: user.ID, PrimaryRole: user.Role, Roles: roles, Permissions: permissions}, nil
+}
+
+func (s *rbacService) AssignRole(ctx context.Context, userID string, input models.AssignRoleInput) (*models.UserRoles, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.GetByID(ctx, userID); err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.Error("[Service][RBAC][AssignRole] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+	if _, err := s.GetRole(ctx, input.RoleID); err != nil {
+		return nil, err
+	}
+
+	if err := s.roleRepo.AddUserRole(ctx, userID, input.RoleID); err != nil {
+		s.log.Error("[Service][RBAC][AssignRole] failed to assign role", zap.Error(err))
+		return nil, err
+	}
+	s.invalidateUser(userID)
+
+	s.log.Info("[Service][RBAC] role assigned", zap.String("userID", userID), zap.String("roleID", input.RoleID))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditRoleAssign, TargetType: models.AuditTargetUser, TargetID: userID,
+		Metadata: map[string]string{"roleID": input.RoleID}})
+	return s.GetUserRoles(ctx, userID)
+}
+
+func (s *rbacService) UnassignRole(ctx context.Context, userID string, roleID string) (*models.UserRoles, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.Error("[Service][RBAC][UnassignRole] failed to get user", zap.Error(err))
+		return nil, ErrorGetUser
+	}
+	if user.Role == roleID {
+		return nil, ErrPrimaryRole
+	}
+
+	if err := s.roleRepo.RemoveUserRole(ctx, userID, roleID); err != nil {
+		if errors.Is(err, postgres.ErrUserRoleNotFound) {
+			return nil, ErrUserRoleNotFound
+		}
+		s.log.Error("[Service][RBAC][UnassignRole] failed to unassign role", zap.Error(err))
+		return nil, err
+	}
+	s.invalidateUser(userID)
+
+	s.log.Info("[Service][RBAC] role unassigned", zap.String("userID", userID), zap.String("roleID", roleID))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditRoleUnassign, TargetType: models.AuditTargetUser, TargetID: userID,
+		Metadata: map[string]string{"roleID": roleID}})
+	return s.GetUserRoles(ctx, userID)
+}
+
+// UserRoles reads the primary role from the user rather than the token, so a demotion takes effect once
+// the cache entry expires instead of when the token does
+func (s *rbacService) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	if roles, ok := s.cached(s.userRoles, userID); ok {
+		return roles, nil
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		// a deleted user holds no roles
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			return nil, nil
+		}
+		s.log.Error("[Service][RBAC][UserRoles] failed to get user", zap.Error(err))
+		return nil, ErrorCheckPermissions
+	}
+	additional, err := s.roleRepo.UserRoles(ctx, userID)
+	if err != nil {
+		s.log.Error("[Service][RBAC][UserRoles] failed to get roles", zap.Error(err))
+		return nil, ErrorCheckPermissions
+	}
+
+	roles := effectiveRoles(user.Role, additional)
+	s.store(s.userRoles, userID, roles)
+	return roles, nil
+}
+
+func (s *rbacService) RolesHavePermission(ctx context.Context, roles []string, permission string) (bool, error) {
+	permissions, err := s.RolePermissions(ctx, roles)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(permissions, permission), nil
+}
+
+func (s *rbacService) RolePermissions(ctx context.Context, roles []string) ([]string, error) {
+	if slices.Contains(roles, models.RoleAdmin) {
+		return slices.Clone(models.Permissions), nil
+	}
+
+	var granted []string
+	for _, role := range roles {
+		permissions, ok := s.cached(s.rolePerms, role)
+		if !ok {
+			var err error
+			if permissions, err = s.roleRepo.PermissionsOf(ctx, []string{role}); err != nil {
+				s.log.Error("[Service][RBAC][RolePermissions] failed to get permissions", zap.Error(err))
+				return nil, ErrorCheckPermissions
+			}
+			s.store(s.rolePerms, role, permissions)
+		}
+		granted = append(granted, permissions...)
+	}
+
+	slices.Sort(granted)
+	return slices.Compact(granted), nil
+}
+
+func (s *rbacService) HasPermission(ctx context.Context, userID string, permission string) (bool, error) {
+	roles, err := s.UserRoles(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return s.RolesHavePermission(ctx, roles, permission)
+}
+
+// permissionsOf lists the permissions granted by the roles, uncached as it is only used to display them
+func (s *rbacService) permissionsOf(ctx context.Context, roles []string) ([]string, error) {
+	if slices.Contains(roles, models.RoleAdmin) {
+		return slices.Clone(models.Permissions), nil
+	}
+
+	permissions, err := s.roleRepo.PermissionsOf(ctx, roles)
+	if err != nil {
+		s.log.Error("[Service][RBAC] failed to get permissions", zap.Error(err))
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (s *rbacService) cached(cache map[string]cachedStrings, key string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := cache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.values, true
+}
+
+func (s *rbacService) store(cache map[string]cachedStrings, key string, values []string) {
+	if s.cacheTTL <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range cache {
+		if now.After(entry.expiresAt) {
+			delete(cache, k)
+		}
+	}
+	cache[key] = cachedStrings{values: values, expiresAt: now.Add(s.cacheTTL)}
+}
+
+func (s *rbacService) invalidateUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.userRoles, userID)
+}
+
+func (s *rbacService) invalidateUsers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.userRoles)
+}
+
+func (s *rbacService) invalidateRole(roleID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rolePerms, roleID)
+}
+
+// effectiveRoles puts the primary role first, followed by the additional roles without duplicates
+func effectiveRoles(primary string, additional []string) []string {
+	roles := []string{primary}
+	for _, role := range additional {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// withAdminPermissions fills in the permissions the admin role holds implicitly
+func withAdminPermissions(role *models.Role) *models.Role {
+	if role.ID == models.RoleAdmin {
+		role.Permissions = slices.Clone(models.Permissions)
+	}
+	return role
+}
+
+// auditRole is the state of a role recorded in the audit log
+func auditRole(role *models.Role) map[string]any {
+	return map[string]any{
+		"name":        role.Name,
+		"description": role.Description,
+		"permissions": []string(role.Permissions),
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+	"user_service/internal/events"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+	"user_service/pkg/geoip"
+	"user_service/pkg/mail"
+)
+
+// SuspiciousLoginService spots logins that do not look like the user's usual ones: from a device or a network
+// the user did not sign in from recently, or too far from the last login to have traveled there since.
+type SuspiciousLoginService interface {
+	// Check compares a login about to complete with the recent successful logins of the user and decides
+	// what to do about it following the policy. Logins are let through when the history cannot be read.
+	Check(ctx context.Context, user *models.User, method string) *models.LoginAssessment
+}
+
+// LoginNotifier tells someone about a suspicious login
+type LoginNotifier interface {
+	NotifySuspiciousLogin(ctx context.Context, user *models.User, reasons []string) error
+}
+
+// stepUpExempt are the login methods that already proved more than a password, they need no confirmation
+var stepUpExempt = []string{models.LoginMethodMFA, models.LoginMethodMagicLink}
+
+type suspiciousLoginService struct {
+	repo       repository.LoginEventRepository
+	geo        *geoip.DB
+	magicLinks MagicLinkService
+	notifiers  []LoginNotifier
+	policy     models.SuspiciousLoginPolicy
+	log        *zap.Logger
+}
+
+// NewSuspiciousLoginService creates the detection. Without a GeoIP database impossible travel is not detected.
+// Users confirm suspicious logins through a magic link, users with MFA are challenged on every login anyway.
+func NewSuspiciousLoginService(repo repository.LoginEventRepository, geo *geoip.DB, magicLinks MagicLinkService,
+	notifiers []LoginNotifier, policy models.SuspiciousLoginPolicy, log *zap.Logger) SuspiciousLoginService {
+	return &suspiciousLoginService{repo: repo, geo: geo, magicLinks: magicLinks, notifiers: notifiers, policy: policy, log: log}
+}
+
+func (s *suspiciousLoginService) Check(ctx context.Context, user *models.User, method string) *models.LoginAssessment {
+	assessment := &models.LoginAssessment{}
+
+	history, err := s.repo.RecentSuccesses(ctx, user.ID, s.policy.History)
+	if err != nil {
+		s.log.Error("[Service][SuspiciousLogin][Check] failed to get login history", zap.String("userID", user.ID), zap.Error(err))
+		return assessment
+	}
+	// nothing to compare the first login with
+	if len(history) == 0 {
+		return assessment
+	}
+
+	assessment.Reasons = s.reasons(util.ClientInfoFromContext(ctx), history, time.Now())
+	if !assessment.Suspicious() {
+		return assessment
+	}
+	s.log.Warn("[Service][SuspiciousLogin] suspicious login", zap.String("userID", user.ID), zap.String("method", method),
+		zap.Strings("reasons", assessment.Reasons))
+
+	if slices.Contains(s.policy.Actions, models.SuspiciousActionStepUp) && !slices.Contains(stepUpExempt, method) {
+		// the login stops here, the user is notified once it is confirmed and completes
+		assessment.StepUp = true
+		if err := s.magicLinks.SendLink(ctx, user.Email); err != nil {
+			s.log.Error("[Service][SuspiciousLogin][Check] failed to send confirmation", zap.String("userID", user.ID), zap.Error(err))
+		}
+		return assessment
+	}
+
+	if slices.Contains(s.policy.Actions, models.SuspiciousActionNotify) {
+		for _, notifier := range s.notifiers {
+			if err := notifier.NotifySuspiciousLogin(ctx, user, assessment.Reasons); err != nil {
+				s.log.Error("[Service][SuspiciousLogin][Check] failed to notify", zap.String("userID", user.ID), zap.Error(err))
+			}
+		}
+	}
+	assessment.RevokeSessions = slices.Contains(s.policy.Actions, models.SuspiciousActionRevoke)
+
+	return assessment
+}
+
+// reasons compares the client with the previous logins. Logins recorded before fingerprints and subnets
+// were kept have neither, they do not make every device new.
+func (s *suspiciousLoginService) reasons(client util.ClientInfo, history []*models.LoginEvent, now time.Time) []string {
+	var reasons []string
+
+	fingerprint, subnet := client.DeviceFingerprint(), util.IPSubnet(client.IPAddress)
+	var fingerprints, subnets []string
+	for _, event := range history {
+		if event.DeviceFingerprint != "" {
+			fingerprints = append(fingerprints, event.DeviceFingerprint)
+		}
+		if event.IPSubnet != "" {
+			subnets = append(subnets, event.IPSubnet)
+		}
+	}
+	if len(fingerprints) > 0 && !slices.Contains(fingerprints, fingerprint) {
+		reasons = append(reasons, models.SuspiciousNewDevice)
+	}
+	if subnet != "" && len(subnets) > 0 && !slices.Contains(subnets, subnet) {
+		reasons = append(reasons, models.SuspiciousNewSubnet)
+	}
+
+	if s.impossibleTravel(client.IPAddress, history[0], now) {
+		reasons = append(reasons, models.SuspiciousImpossibleTravel)
+	}
+
+	return reasons
+}
+
+// impossibleTravel reports whether getting from the last login to here since then would have been faster than
+// the maximum travel speed
+func (s *suspiciousLoginService) impossibleTravel(ip string, last *models.LoginEvent, now time.Time) bool {
+	if s.geo == nil {
+		return false
+	}
+
+	here, ok := s.geo.Lookup(ip)
+	if !ok {
+		return false
+	}
+	there, ok := s.geo.Lookup(last.IPAddress)
+	if !ok {
+		return false
+	}
+
+	distance := geoip.Distance(here, there)
+	if distance <= s.policy.MinTravel {
+		return false
+	}
+
+	hours := now.Sub(last.CreatedAt).Hours()
+	return hours <= 0 || distance/hours > s.policy.MaxTravelSpeed
+}
+
+type eventLoginNotifier struct {
+	events events.Publisher
+}
+
+// NewEventLoginNotifier publishes suspicious logins as security events
+func NewEventLoginNotifier(publisher events.Publisher) LoginNotifier {
+	return &eventLoginNotifier{events: publisher}
+}
+
+func (n *eventLoginNotifier) NotifySuspiciousLogin(ctx context.Context, user *models.User, reasons []string) error {
+	client := util.ClientInfoFromContext(ctx)
+	n.events.Publish(ctx, events.Event{
+		Type:     events.TypeSuspiciousLogin,
+		Severity: events.SeverityWarning,
+		UserID:   user.ID,
+		Metadata: map[string]string{
+			"reasons": strings.Join(reasons, ","),
+			"ip":      client.IPAddress,
+			"device":  client.DeviceLabel,
+		},
+	})
+	return nil
+}
+
+type mailLoginNotifier struct {
+	mailer mail.Sender
+}
+
+// NewMailLoginNotifier emails the user about suspicious logins to their account
+func NewMailLoginNotifier(mailer mail.Sender) LoginNotifier {
+	return &mailLoginNotifier{mailer: mailer}
+}
+
+// suspiciousReasonText describes the reasons to the user
+var suspiciousReasonText = map[string]string{
+	models.SuspiciousNewDevice:        "thiết bị mới",
+	models.SuspiciousNewSubnet:        "mạng mới",
+	models.SuspiciousImpossibleTravel: "vị trí quá xa lần đăng nhập trước",
+}
+
+func (n *mailLoginNotifier) NotifySuspiciousLogin(ctx context.Context, user *models.User, reasons []string) error {
+	descriptions := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		descriptions = append(descriptions, suspiciousReasonText[reason])
+	}
+
+	client := util.ClientInfoFromContext(ctx)
+	return n.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Đăng nhập bất thường vào tài khoản của bạn",
+		Body: fmt.Sprintf("Xin chào %s,\n\nTài khoản của bạn vừa được đăng nhập từ %s (%s) lúc %s.\nLý do cảnh báo: %s.\n\nNếu không phải bạn, hãy đổi mật khẩu và đăng xuất khỏi tất cả thiết bị ngay.",
+			user.FullName, client.DeviceLabel, client.IPAddress, time.Now().Format(time.RFC1123), strings.Join(descriptions, ", ")),
+	})
+}
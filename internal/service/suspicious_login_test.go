@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/util"
+	"user_service/pkg/geoip"
+)
+
+// two networks in Hanoi and one in Saigon, about 1140 km away
+const testGeoIPCSV = `network,latitude,longitude
+203.0.113.0/24,21.0285,105.8542
+192.0.2.0/24,21.0300,105.8500
+198.51.100.0/24,10.8231,106.6297
+`
+
+type fakeLoginEventRepo struct {
+	repository.LoginEventRepository
+	history []*models.LoginEvent
+	err     error
+}
+
+func (r *fakeLoginEventRepo) RecentSuccesses(_ context.Context, _ string, limit int) ([]*models.LoginEvent, error) {
+	return r.history[:min(limit, len(r.history))], r.err
+}
+
+type recordingMagicLinks struct {
+	MagicLinkService
+	sent []string
+}
+
+func (m *recordingMagicLinks) SendLink(_ context.Context, email string) error {
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+type recordingNotifier struct {
+	reasons [][]string
+}
+
+func (n *recordingNotifier) NotifySuspiciousLogin(_ context.Context, _ *models.User, reasons []string) error {
+	n.reasons = append(n.reasons, reasons)
+	return nil
+}
+
+var firefox = util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox"}
+
+// previousLogin is a successful login with the client, ago before now
+func previousLogin(client util.ClientInfo, ago time.Duration) *models.LoginEvent {
+	return &models.LoginEvent{
+		Success:           true,
+		IPAddress:         client.IPAddress,
+		DeviceFingerprint: client.DeviceFingerprint(),
+		IPSubnet:          util.IPSubnet(client.IPAddress),
+		CreatedAt:         time.Now().Add(-ago),
+	}
+}
+
+func testSuspiciousLoginService(t *testing.T, repo repository.LoginEventRepository, geo bool, actions ...string) (SuspiciousLoginService,
+	*recordingMagicLinks, *recordingNotifier) {
+	t.Helper()
+	var db *geoip.DB
+	if geo {
+		var err error
+		if db, err = geoip.Parse(strings.NewReader(testGeoIPCSV)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	magicLinks, notifier := &recordingMagicLinks{}, &recordingNotifier{}
+	policy := models.SuspiciousLoginPolicy{Actions: actions, History: 5, MaxTravelSpeed: 1000, MinTravel: 100}
+	return NewSuspiciousLoginService(repo, db, magicLinks, []LoginNotifier{notifier}, policy, zap.NewNop()), magicLinks, notifier
+}
+
+func TestSuspiciousLoginReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  util.ClientInfo
+		history []*models.LoginEvent
+		noGeoIP bool
+		want    []string
+	}{
+		{
+			name:    "first login",
+			client:  firefox,
+			history: nil,
+		},
+		{
+			name:    "usual device and network",
+			client:  firefox,
+			history: []*models.LoginEvent{previousLogin(firefox, time.Hour)},
+		},
+		{
+			name:    "new browser",
+			client:  util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Chrome"},
+			history: []*models.LoginEvent{previousLogin(firefox, time.Hour)},
+			want:    []string{models.SuspiciousNewDevice},
+		},
+		{
+			name:    "device id wins over the user agent",
+			client:  util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox", DeviceID: "phone-2"},
+			history: []*models.LoginEvent{previousLogin(util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox", DeviceID: "phone-1"}, time.Hour)},
+			want:    []string{models.SuspiciousNewDevice},
+		},
+		{
+			name:    "known device in an older login",
+			client:  firefox,
+			history: []*models.LoginEvent{previousLogin(util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Chrome"}, time.Hour), previousLogin(firefox, 2*time.Hour)},
+		},
+		{
+			name:    "new address in the same subnet",
+			client:  util.ClientInfo{IPAddress: "203.0.113.200", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, time.Hour)},
+		},
+		{
+			name:    "new subnet in the same city",
+			client:  util.ClientInfo{IPAddress: "192.0.2.10", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, time.Minute)},
+			want:    []string{models.SuspiciousNewSubnet},
+		},
+		{
+			name:    "logins recorded before fingerprints and subnets were kept",
+			client:  util.ClientInfo{IPAddress: "192.0.2.10", UserAgent: "Chrome"},
+			history: []*models.LoginEvent{{Success: true, IPAddress: "203.0.113.10", CreatedAt: time.Now().Add(-time.Hour)}},
+		},
+		{
+			name:    "impossible travel",
+			client:  util.ClientInfo{IPAddress: "198.51.100.10", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, 30*time.Minute)},
+			want:    []string{models.SuspiciousNewSubnet, models.SuspiciousImpossibleTravel},
+		},
+		{
+			name:    "possible travel",
+			client:  util.ClientInfo{IPAddress: "198.51.100.10", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, 3*time.Hour)},
+			want:    []string{models.SuspiciousNewSubnet},
+		},
+		{
+			name:   "travel is measured from the most recent login only",
+			client: util.ClientInfo{IPAddress: "198.51.100.10", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(util.ClientInfo{IPAddress: "198.51.100.20", UserAgent: "Firefox"}, 10*time.Minute),
+				previousLogin(firefox, 20*time.Minute)},
+		},
+		{
+			name:    "location unknown",
+			client:  util.ClientInfo{IPAddress: "10.0.0.1", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, time.Minute)},
+			want:    []string{models.SuspiciousNewSubnet},
+		},
+		{
+			name:    "without a GeoIP database",
+			client:  util.ClientInfo{IPAddress: "198.51.100.10", UserAgent: "Firefox"},
+			history: []*models.LoginEvent{previousLogin(firefox, time.Minute)},
+			noGeoIP: true,
+			want:    []string{models.SuspiciousNewSubnet},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := testSuspiciousLoginService(t, &fakeLoginEventRepo{history: tt.history}, !tt.noGeoIP)
+			ctx := util.WithClientInfo(context.Background(), tt.client)
+
+			assessment := s.Check(ctx, &models.User{ID: "user-1"}, models.LoginMethodPassword)
+			if !slices.Equal(assessment.Reasons, tt.want) {
+				t.Errorf("reasons %v, want %v", assessment.Reasons, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuspiciousLoginActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		actions     []string
+		method      string
+		wantStepUp  bool
+		wantNotify  bool
+		wantRevoke  bool
+		wantLinkFor string
+	}{
+		{"notify", []string{models.SuspiciousActionNotify}, models.LoginMethodPassword, false, true, false, ""},
+		{"step up a password login", []string{models.SuspiciousActionStepUp, models.SuspiciousActionNotify}, models.LoginMethodPassword,
+			true, false, false, "user@example.test"},
+		{"step up exempts MFA", []string{models.SuspiciousActionStepUp, models.SuspiciousActionNotify}, models.LoginMethodMFA,
+			false, true, false, ""},
+		{"step up exempts magic links", []string{models.SuspiciousActionStepUp}, models.LoginMethodMagicLink, false, false, false, ""},
+		{"revoke", []string{models.SuspiciousActionRevoke}, models.LoginMethodPassword, false, false, true, ""},
+		{"no action", nil, models.LoginMethodPassword, false, false, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeLoginEventRepo{history: []*models.LoginEvent{previousLogin(firefox, time.Hour)}}
+			s, magicLinks, notifier := testSuspiciousLoginService(t, repo, true, tt.actions...)
+			ctx := util.WithClientInfo(context.Background(), util.ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Chrome"})
+
+			assessment := s.Check(ctx, &models.User{ID: "user-1", Email: "user@example.test"}, tt.method)
+			if !assessment.Suspicious() {
+				t.Fatal("login not flagged")
+			}
+			if assessment.StepUp != tt.wantStepUp || assessment.RevokeSessions != tt.wantRevoke {
+				t.Errorf("step up %v, revoke %v, want %v, %v", assessment.StepUp, assessment.RevokeSessions, tt.wantStepUp, tt.wantRevoke)
+			}
+			if notified := len(notifier.reasons) > 0; notified != tt.wantNotify {
+				t.Errorf("notified %v, want %v", notified, tt.wantNotify)
+			}
+			if link := strings.Join(magicLinks.sent, ","); link != tt.wantLinkFor {
+				t.Errorf("confirmation sent to %q, want %q", link, tt.wantLinkFor)
+			}
+		})
+	}
+}
+
+func TestSuspiciousLoginLetsThroughWithoutHistory(t *testing.T) {
+	s, magicLinks, notifier := testSuspiciousLoginService(t, &fakeLoginEventRepo{err: errors.New("database down")}, true,
+		models.SuspiciousActionStepUp, models.SuspiciousActionNotify)
+	ctx := util.WithClientInfo(context.Background(), util.ClientInfo{IPAddress: "198.51.100.10", UserAgent: "Chrome"})
+
+	assessment := s.Check(ctx, &models.User{ID: "user-1"}, models.LoginMethodPassword)
+	if assessment.Suspicious() || assessment.StepUp || len(magicLinks.sent) > 0 || len(notifier.reasons) > 0 {
+		t.Errorf("assessment %+v without a login history", assessment)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"errors"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"time"
 	"user_service/internal/models"
 	"user_service/internal/repository"
 	"user_service/internal/repository/postgres"
 	"user_service/internal/util"
+	"user_service/pkg/password"
 )
 
 type UserService interface {
@@ -22,13 +22,33 @@ type UserService interface {
 	Validate(ctx context.Context, email, password string) (*models.User, error)
 	Count(ctx context.Context) (int, error)
 	ChangePassword(ctx context.Context, id string, input models.ChangePasswordInput) error
+	SetPassword(ctx context.Context, id string, newPassword string) error
+	// CheckPassword validates a new password for the user against the password policy without storing it
+	CheckPassword(ctx context.Context, id string, newPassword string) error
+	// CreateExternal creates an active user signing in through an identity provider, it has no usable password
+	CreateExternal(ctx context.Context, email, fullName string) (*models.User, error)
+	// ClaimPendingAccount activates an unverified account whose email an identity provider vouched for
+	ClaimPendingAccount(ctx context.Context, id string) (*models.User, error)
 }
 
 type userService struct {
-	repo repository.UserRepository
-	log  *zap.Logger
+	repo             repository.UserRepository
+	authRepo         repository.AuthRepository
+	revocations      repository.RevocationStore
+	passwordPolicy   *password.Policy
+	hasher           *password.Hasher
+	audit            AuditService
+	log              *zap.Logger
+	verificationMode string
 }
 
+// Email verification modes controlling how Validate treats accounts that have not verified their email
+const (
+	EmailVerificationOff     = "off"     // registrations are active immediately
+	EmailVerificationFlag    = "flag"    // unverified accounts can login, the login response flags them
+	EmailVerificationEnforce = "enforce" // unverified accounts cannot login
+)
+
 var (
 	ErrUserNotFound           = errors.New("user not found")
 	ErrorUserExists           = errors.New("user already exists")
@@ -39,6 +59,7 @@ var (
 	ErrorDeleting             = errors.New("failed to delete user")
 	ErrorListing              = errors.New("failed to list users")
 	ErrInvalidEmailOrPassword = errors.New("invalid email or password")
+	ErrEmailNotVerified       = errors.New("email not verified")
 )
 
 func (s userService) Create(ctx context.Context, input models.CreateUserInput) (*models.User, error) {
@@ -48,20 +69,30 @@ func (s userService) Create(ctx context.Context, input models.CreateUserInput) (
 		return nil, ErrorUserExists
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err := s.passwordPolicy.Check(input.Password, input.Email, input.FullName); err != nil {
+		s.log.Info("[Service][Create] password rejected by policy", zap.Error(err))
+		return nil, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(input.Password)
 	if err != nil {
 		s.log.Error("[Service][Create] failed to hash password", zap.Error(err))
 		return nil, ErrorHashing
 	}
 
+	status := input.Status
+	if status == "" {
+		status = models.StatusActive
+	}
+
 	user := &models.User{
 		Email:     input.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		FullName:  input.FullName,
 		Role:      input.Role,
 		Phone:     "default",
 		Avatar:    "default.jpg", // temporary
-		Status:    models.StatusActive,
+		Status:    status,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -78,6 +109,8 @@ func (s userService) Create(ctx context.Context, input models.CreateUserInput) (
 		return nil, ErrorCreating
 	}
 
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditUserCreate, TargetType: models.AuditTargetUser, TargetID: user.ID,
+		After: models.AuditUser(user)})
 	return user, nil
 }
 
@@ -109,6 +142,12 @@ func (s userService) GetByEmail(ctx context.Context, email string) (*models.User
 }
 
 func (s userService) Update(ctx context.Context, id string, input models.UpdateUserInput) (*models.User, error) {
+	// Validate and RefreshToken only turn away inactive accounts, an unknown status would leave one fully usable
+	if err := input.Validate(); err != nil {
+		s.log.Info("[Service][Update] invalid input", zap.Error(err))
+		return nil, err
+	}
+
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, postgres.ErrUserNotFound) {
@@ -118,6 +157,7 @@ func (s userService) Update(ctx context.Context, id string, input models.UpdateU
 		s.log.Error("[Service][Update] failed to get user", zap.Error(err))
 		return nil, ErrorGetUser
 	}
+	before := models.AuditUser(user)
 
 	if input.FullName != "" {
 		user.FullName = input.FullName
@@ -137,11 +177,20 @@ func (s userService) Update(ctx context.Context, id string, input models.UpdateU
 		return nil, ErrorUpdating
 	}
 
+	if input.Status != "" && input.Status != models.StatusActive {
+		if err := s.revokeTokens(ctx, user.ID); err != nil {
+			return nil, ErrorUpdating
+		}
+	}
+
+	changedBefore, changedAfter := auditDiff(before, models.AuditUser(user))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditUserUpdate, TargetType: models.AuditTargetUser, TargetID: user.ID,
+		Before: changedBefore, After: changedAfter})
 	return user, nil
 }
 
 func (s userService) Delete(ctx context.Context, id string) error {
-	_, err := s.repo.GetByID(ctx, id)
+	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, postgres.ErrUserNotFound) {
 			s.log.Error("[Service][Delete] user not found", zap.Error(err))
@@ -151,11 +200,17 @@ func (s userService) Delete(ctx context.Context, id string) error {
 		return ErrorGetUser
 	}
 
+	if err := s.revokeTokens(ctx, id); err != nil {
+		return ErrorDeleting
+	}
+
 	if err := s.repo.Delete(ctx, id); err != nil {
 		s.log.Error("[Service][Delete] failed to delete user", zap.Error(err))
 		return ErrorDeleting
 	}
 
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditUserDelete, TargetType: models.AuditTargetUser, TargetID: id,
+		Before: models.AuditUser(user)})
 	return nil
 }
 
@@ -180,14 +235,52 @@ func (s userService) Validate(ctx context.Context, email, password string) (*mod
 		return nil, ErrUserNotFound
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+	ok, needsRehash, err := s.hasher.Verify(password, user.Password)
+	if err != nil || !ok {
 		s.log.Error("[Service][Validate] invalid password", zap.Error(err))
 		return nil, ErrInvalidEmailOrPassword
 	}
 
+	if needsRehash {
+		s.rehash(ctx, user, password)
+	}
+
+	if user.Status == models.StatusInactive {
+		s.log.Info("[Service][Validate] login refused, user inactive", zap.String("userID", user.ID))
+		return nil, ErrAccountInactive
+	}
+
+	if user.Status == models.StatusPendingVerification {
+		switch s.verificationMode {
+		case EmailVerificationEnforce:
+			s.log.Info("[Service][Validate] login refused, email not verified", zap.String("userID", user.ID))
+			return nil, ErrEmailNotVerified
+		case EmailVerificationFlag:
+			s.log.Warn("[Service][Validate] login with unverified email", zap.String("userID", user.ID))
+		}
+	}
+
 	return user, nil
 }
 
+// rehash upgrades the stored hash to the current algorithm and parameters. The login goes on when it fails,
+// the next login tries again.
+func (s userService) rehash(ctx context.Context, user *models.User, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		s.log.Error("[Service][Validate] failed to rehash password", zap.Error(err))
+		return
+	}
+
+	user.Password = hashedPassword
+	if err := s.repo.Update(ctx, user); err != nil {
+		s.log.Error("[Service][Validate] failed to store rehashed password", zap.Error(err))
+		return
+	}
+
+	s.log.Info("[Service][Validate] password rehashed", zap.String("userID", user.ID), zap.String("algorithm", s.hasher.Algorithm))
+}
+
 func (s userService) Count(ctx context.Context) (int, error) {
 	count, err := s.repo.CountUser(ctx)
 	if err != nil {
@@ -209,19 +302,24 @@ func (s userService) ChangePassword(ctx context.Context, id string, input models
 		return ErrorGetUser
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+	if ok, _, err := s.hasher.Verify(input.CurrentPassword, user.Password); err != nil || !ok {
 		s.log.Error("[Service][ChangePassword] invalid password", zap.Error(err))
 		return ErrInvalidEmailOrPassword
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err := s.passwordPolicy.Check(input.NewPassword, user.Email, user.FullName); err != nil {
+		s.log.Info("[Service][ChangePassword] password rejected by policy", zap.Error(err))
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(input.NewPassword)
 
 	if err != nil {
 		s.log.Error("[Service][ChangePassword] failed to hash password", zap.Error(err))
 		return ErrorHashing
 	}
 
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
 	user.UpdatedAt = time.Now()
 
 	if err := s.repo.Update(ctx, user); err != nil {
@@ -229,9 +327,148 @@ func (s userService) ChangePassword(ctx context.Context, id string, input models
 		return ErrorUpdating
 	}
 
+	if err := s.revokeTokens(ctx, user.ID); err != nil {
+		return ErrorUpdating
+	}
+
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditPasswordChange, TargetType: models.AuditTargetUser, TargetID: user.ID})
+	return nil
+}
+
+// SetPassword replaces the password without checking the current one. Callers must have verified the user another way.
+func (s userService) SetPassword(ctx context.Context, id string, newPassword string) error {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			s.log.Error("[Service][SetPassword] user not found", zap.Error(err))
+			return ErrUserNotFound
+		}
+		s.log.Error("[Service][SetPassword] failed to get user", zap.Error(err))
+		return ErrorGetUser
+	}
+
+	if err := s.passwordPolicy.Check(newPassword, user.Email, user.FullName); err != nil {
+		s.log.Info("[Service][SetPassword] password rejected by policy", zap.Error(err))
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		s.log.Error("[Service][SetPassword] failed to hash password", zap.Error(err))
+		return ErrorHashing
+	}
+
+	user.Password = hashedPassword
+	user.UpdatedAt = time.Now()
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		s.log.Error("[Service][SetPassword] failed to update user", zap.Error(err))
+		return ErrorUpdating
+	}
+
+	if err := s.revokeTokens(ctx, user.ID); err != nil {
+		return ErrorUpdating
+	}
+
+	// a password reset is not authenticated, the user is the actor
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditPasswordSet, TargetType: models.AuditTargetUser, TargetID: user.ID,
+		ActorID: user.ID})
 	return nil
 }
 
-func NewUserService(repo repository.UserRepository, log *zap.Logger) UserService {
-	return &userService{repo: repo, log: log}
+func (s userService) CheckPassword(ctx context.Context, id string, newPassword string) error {
+	user, err := s.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.passwordPolicy.Check(newPassword, user.Email, user.FullName)
+}
+
+func (s userService) CreateExternal(ctx context.Context, email, fullName string) (*models.User, error) {
+	if fullName == "" {
+		fullName = email
+	}
+
+	// an empty hash never verifies, the user can set a password through the password reset
+	user := &models.User{
+		Email:     email,
+		Password:  "",
+		FullName:  fullName,
+		Role:      models.RoleUser,
+		Phone:     "default",
+		Avatar:    "default.jpg", // temporary
+		Status:    models.StatusActive,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		s.log.Error("[Service][CreateExternal] failed to create user", zap.Error(err))
+		return nil, ErrorCreating
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		s.log.Error("[Service][CreateExternal] user doesnt exist", zap.Error(err))
+		return nil, ErrorCreating
+	}
+
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditUserCreate, TargetType: models.AuditTargetUser, TargetID: user.ID,
+		ActorID: user.ID, After: models.AuditUser(user), Metadata: map[string]string{"source": "identity_provider"}})
+	return user, nil
+}
+
+// ClaimPendingAccount activates the account and drops its password. Anyone can register an unverified account
+// for an email, keeping the password would let them sign in to the account of the email's real owner.
+func (s userService) ClaimPendingAccount(ctx context.Context, id string) (*models.User, error) {
+	user, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status != models.StatusPendingVerification {
+		return user, nil
+	}
+	before := models.AuditUser(user)
+
+	user.Password = ""
+	user.Status = models.StatusActive
+	user.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, user); err != nil {
+		s.log.Error("[Service][ClaimPendingAccount] failed to update user", zap.Error(err))
+		return nil, ErrorUpdating
+	}
+
+	if err := s.revokeTokens(ctx, id); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("[Service][ClaimPendingAccount] pending account claimed by identity provider login", zap.String("userID", id))
+	changedBefore, changedAfter := auditDiff(before, models.AuditUser(user))
+	s.audit.Record(ctx, models.AuditEntry{Action: models.AuditUserClaim, TargetType: models.AuditTargetUser, TargetID: id,
+		ActorID: id, Before: changedBefore, After: changedAfter})
+	return user, nil
+}
+
+// NewUserService creates the user service. Every new password is checked against passwordPolicy,
+// the methods setting one return a *password.PolicyError listing the broken rules.
+func NewUserService(repo repository.UserRepository, authRepo repository.AuthRepository, revocations repository.RevocationStore,
+	passwordPolicy *password.Policy, hasher *password.Hasher, audit AuditService, log *zap.Logger, verificationMode string) UserService {
+	return &userService{repo: repo, authRepo: authRepo, revocations: revocations, passwordPolicy: passwordPolicy, hasher: hasher, audit: audit,
+		log: log, verificationMode: verificationMode}
+}
+
+// revokeTokens invalidates the access and refresh tokens already handed out to the user, used when their
+// credentials or account status change
+func (s userService) revokeTokens(ctx context.Context, userID string) error {
+	if err := s.authRepo.RevokeAllTokens(ctx, userID); err != nil {
+		s.log.Error("[Service] failed to revoke refresh tokens", zap.String("userID", userID), zap.Error(err))
+		return err
+	}
+	if err := s.revocations.SetValidAfter(ctx, userID, time.Now()); err != nil {
+		s.log.Error("[Service] failed to revoke access tokens", zap.String("userID", userID), zap.Error(err))
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"testing"
+	"user_service/internal/models"
+	"user_service/internal/repository/memory"
+)
+
+// savingUserRepo keeps the users written by Update
+type savingUserRepo struct {
+	*fakeUserRepo
+}
+
+func (r *savingUserRepo) Update(_ context.Context, user *models.User) error {
+	saved := *user
+	r.users[user.ID] = &saved
+	return nil
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		wantErr    error
+		wantStatus string
+	}{
+		{name: "no status keeps the current one", wantStatus: models.StatusActive},
+		{name: "active", status: models.StatusActive, wantStatus: models.StatusActive},
+		{name: "inactive", status: models.StatusInactive, wantStatus: models.StatusInactive},
+		{name: "pending verification", status: models.StatusPendingVerification, wantStatus: models.StatusPendingVerification},
+		{name: "unknown status", status: "banned", wantErr: models.ErrInvalidStatus, wantStatus: models.StatusActive},
+		{name: "different case", status: "ACTIVE", wantErr: models.ErrInvalidStatus, wantStatus: models.StatusActive},
+		{name: "surrounding spaces", status: " inactive", wantErr: models.ErrInvalidStatus, wantStatus: models.StatusActive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &savingUserRepo{&fakeUserRepo{users: map[string]*models.User{
+				"user-1": {ID: "user-1", Email: "user@example.test", Role: models.RoleUser, Status: models.StatusActive},
+			}}}
+			authRepo := &memoryAuthRepository{tokens: make(map[string]*models.RefreshTokenData)}
+			svc := NewUserService(repo, authRepo, memory.NewRevocationStore(), nil, nil, &recordingAudit{}, zap.NewNop(),
+				EmailVerificationOff)
+
+			_, err := svc.Update(context.Background(), "user-1", models.UpdateUserInput{FullName: "Renamed", Status: tt.status})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update error = %v, want %v", err, tt.wantErr)
+			}
+			if got := repo.users["user-1"].Status; got != tt.wantStatus {
+				t.Errorf("stored status = %q, want %q", got, tt.wantStatus)
+			}
+			if tt.wantErr != nil && repo.users["user-1"].FullName != "" {
+				t.Error("a rejected update was saved")
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"os"
 	"time"
 	_ "time/tzdata"
@@ -16,26 +18,58 @@ type Jwt interface {
 
 const TOKEN_EXPIRED_TIME = 30 * time.Minute
 
+const ACCESS_TOKEN_EXPIRED_TIME = 1 * time.Hour
+
+// The token_use claim tells the tokens signed with the key store apart, only access tokens are accepted as
+// bearer tokens. An ID token is signed with the same keys and must not pass for one.
+const (
+	ClaimTokenUse  = "token_use"
+	TokenUseAccess = "access"
+	TokenUseID     = "id"
+)
+
+var ErrNotAccessToken = errors.New("token is not an access token")
+
+// JwtImpl signs access tokens with the asymmetric keys of a KeyStore so other services can verify them
+// through /.well-known/jwks.json. Refresh tokens are only ever read by this service and stay HMAC signed.
 type JwtImpl struct {
+	keys   KeyStore
+	issuer string
 }
 
-func NewJwtImpl() *JwtImpl {
-	return &JwtImpl{}
+func NewJwtImpl(keys KeyStore, issuer string) *JwtImpl {
+	return &JwtImpl{keys: keys, issuer: issuer}
 }
 
 func (j JwtImpl) GenerateAccessToken(userID string, role string) (string, error) {
-	location, err := time.LoadLocation("Asia/Ho_Chi_Minh")
-	if err != nil {
-		return "", err
-	}
-	expireTime := time.Now().In(location).Local().Add(1 * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	now := time.Now()
+	return j.SignAccessToken(jwt.MapClaims{
 		"userID": userID,
 		"role":   role,
-		"exp":    expireTime.Unix(),
+		"sub":    userID,
+		"jti":    uuid.NewString(), // lets a single token be revoked before it expires
+		"iat":    now.Unix(),
+		"exp":    now.Add(ACCESS_TOKEN_EXPIRED_TIME).Unix(),
 	})
+}
+
+// Issuer is the iss claim of every token, with OpenID Connect it is also the base URL of the provider
+func (j JwtImpl) Issuer() string {
+	return j.issuer
+}
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("ACCESS_SECRET_KEY")))
+// Sign signs the claims with the active key, iss is always set to the issuer
+func (j JwtImpl) Sign(claims jwt.MapClaims) (string, error) {
+	key, err := j.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	claims["iss"] = j.issuer
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
@@ -43,13 +77,17 @@ func (j JwtImpl) GenerateAccessToken(userID string, role string) (string, error)
 	return tokenString, nil
 }
 
+// SignAccessToken signs the claims as an access token, the only kind ValidateAccessToken accepts
+func (j JwtImpl) SignAccessToken(claims jwt.MapClaims) (string, error) {
+	claims[ClaimTokenUse] = TokenUseAccess
+	return j.Sign(claims)
+}
+
 func (j JwtImpl) ValidateAccessToken(token string) (jwt.MapClaims, error) {
-	t, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(os.Getenv("ACCESS_SECRET_KEY")), nil
-	})
+	t, err := jwt.Parse(token, j.verificationKey,
+		jwt.WithValidMethods(AsymmetricAlgorithms),
+		jwt.WithIssuer(j.issuer),
+		jwt.WithExpirationRequired())
 	if err != nil {
 		return nil, err
 	}
@@ -58,15 +96,38 @@ func (j JwtImpl) ValidateAccessToken(token string) (jwt.MapClaims, error) {
 	if !ok {
 		return nil, jwt.ErrTokenInvalidClaims
 	}
+	if use, _ := claims[ClaimTokenUse].(string); use != TokenUseAccess {
+		return nil, ErrNotAccessToken
+	}
 
 	return claims, nil
 }
 
+// verificationKey resolves the public key from the kid header, accepting active and retired keys
+func (j JwtImpl) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	key, err := j.keys.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	return key.Public, nil
+}
+
 func (j JwtImpl) GenerateRefreshToken(userID string, role string) (string, error) {
 	expireTime := time.Now().Add(24 * time.Hour)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userID": userID,
 		"role":   role,
+		"jti":    uuid.NewString(), // tokens issued in the same second must still differ, they are stored by digest
 		"exp":    expireTime.Unix(),
 	})
 
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+func TestValidateAccessTokenRequiresTokenUse(t *testing.T) {
+	keys, err := NewEphemeralKeyStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtService := NewJwtImpl(keys, testIssuer)
+
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "user-1", "userID": "user-1", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		sign    func() (string, error)
+		wantErr error
+	}{
+		{"access token", func() (string, error) { return jwtService.GenerateAccessToken("user-1", "admin") }, nil},
+		{"signed as an access token", func() (string, error) {
+			return jwtService.SignAccessToken(claims(jwt.MapClaims{"aud": "client-1", "scope": "openid"}))
+		}, nil},
+		{"id token", func() (string, error) {
+			return jwtService.Sign(claims(jwt.MapClaims{"aud": "client-1", ClaimTokenUse: TokenUseID}))
+		}, ErrNotAccessToken},
+		{"no token use", func() (string, error) { return jwtService.Sign(claims(nil)) }, ErrNotAccessToken},
+		{"unknown token use", func() (string, error) {
+			return jwtService.Sign(claims(jwt.MapClaims{ClaimTokenUse: "Access"}))
+		}, ErrNotAccessToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.sign()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := jwtService.ValidateAccessToken(token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateAccessToken error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
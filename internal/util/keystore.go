@@ -0,0 +1,325 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigningKey is an asymmetric JWT key identified by its kid. Retired keys only verify tokens issued
+// before a rotation and may hold just the public half.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.PrivateKey
+	Public    crypto.PublicKey
+	Retired   bool
+	CreatedAt time.Time
+}
+
+// KeyStore holds the keys used to sign and verify access tokens.
+type KeyStore interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (*SigningKey, error)
+	// VerificationKey returns the active or retired key with the given kid
+	VerificationKey(kid string) (*SigningKey, error)
+	// PublicKeys returns every key published at /.well-known/jwks.json
+	PublicKeys() []*SigningKey
+}
+
+var (
+	ErrKeyNotFound       = errors.New("signing key not found")
+	ErrNoSigningKey      = errors.New("no active signing key")
+	ErrUnsupportedKey    = errors.New("unsupported key type")
+	AsymmetricAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// FileKeyStore loads PEM encoded keys from a directory. The file name (without extension) is the kid.
+//
+//	keys/2025-05.pem          active, can sign and verify
+//	keys/retired/2025-01.pem  retired, verifies and is published until removed
+//
+// The signing key is activeKID, or the most recently modified active key when activeKID is empty.
+// Keys can be generated with e.g. `openssl genpkey -algorithm ed25519 -out keys/2025-05.pem`.
+type FileKeyStore struct {
+	dir       string
+	activeKID string
+
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active *SigningKey
+}
+
+func NewFileKeyStore(dir, activeKID string) (*FileKeyStore, error) {
+	store := &FileKeyStore{dir: dir, activeKID: activeKID}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the key directory so keys can be rotated without a restart. The previous keys are
+// kept when the directory cannot be loaded.
+func (s *FileKeyStore) Reload() error {
+	keys := make(map[string]*SigningKey)
+
+	if err := loadKeyDir(s.dir, false, keys); err != nil {
+		return err
+	}
+	if err := loadKeyDir(filepath.Join(s.dir, "retired"), true, keys); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var active *SigningKey
+	if s.activeKID != "" {
+		key, ok := keys[s.activeKID]
+		if !ok || key.Retired || key.Private == nil {
+			return fmt.Errorf("%w: %s", ErrNoSigningKey, s.activeKID)
+		}
+		active = key
+	} else {
+		for _, key := range keys {
+			if key.Retired || key.Private == nil {
+				continue
+			}
+			if active == nil || key.CreatedAt.After(active.CreatedAt) {
+				active = key
+			}
+		}
+	}
+	if active == nil {
+		return ErrNoSigningKey
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.active = active
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *FileKeyStore) SigningKey() (*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.active == nil {
+		return nil, ErrNoSigningKey
+	}
+	return s.active, nil
+}
+
+func (s *FileKeyStore) VerificationKey(kid string) (*SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *FileKeyStore) PublicKeys() []*SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// memoryKeyStore holds a single generated key. Tokens do not survive a restart, only meant for local development.
+type memoryKeyStore struct {
+	key *SigningKey
+}
+
+func NewEphemeralKeyStore() (KeyStore, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSigningKey("ephemeral-"+time.Now().Format("20060102150405"), private, nil)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = time.Now()
+
+	return &memoryKeyStore{key: key}, nil
+}
+
+func (s *memoryKeyStore) SigningKey() (*SigningKey, error) {
+	return s.key, nil
+}
+
+func (s *memoryKeyStore) VerificationKey(kid string) (*SigningKey, error) {
+	if kid != s.key.ID {
+		return nil, ErrKeyNotFound
+	}
+	return s.key, nil
+}
+
+func (s *memoryKeyStore) PublicKeys() []*SigningKey {
+	return []*SigningKey{s.key}
+}
+
+func loadKeyDir(dir string, retired bool, keys map[string]*SigningKey) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+		key, err := parseKeyPEM(kid, data)
+		if err != nil {
+			return fmt.Errorf("load key %s: %w", path, err)
+		}
+		key.Retired = retired
+		key.CreatedAt = info.ModTime()
+
+		if _, exists := keys[kid]; exists {
+			return fmt.Errorf("duplicate key id %s", kid)
+		}
+		keys[kid] = key
+	}
+
+	return nil
+}
+
+func parseKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSigningKey(kid, private, nil)
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSigningKey(kid, private, nil)
+	case "EC PRIVATE KEY":
+		private, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSigningKey(kid, private, nil)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newSigningKey(kid, nil, public)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+}
+
+func newSigningKey(kid string, private crypto.PrivateKey, public crypto.PublicKey) (*SigningKey, error) {
+	if private != nil {
+		switch k := private.(type) {
+		case *rsa.PrivateKey:
+			public = &k.PublicKey
+		case *ecdsa.PrivateKey:
+			public = &k.PublicKey
+		case ed25519.PrivateKey:
+			public = k.Public()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+	}
+
+	var method jwt.SigningMethod
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, ErrUnsupportedKey
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return &SigningKey{ID: kid, Method: method, Private: private, Public: public}, nil
+}
+
+// JWK returns the public half of the key as a RFC 7517 JSON Web Key
+func (k *SigningKey) JWK() map[string]string {
+	jwk := map[string]string{
+		"kid": k.ID,
+		"alg": k.Method.Alg(),
+		"use": "sig",
+	}
+
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
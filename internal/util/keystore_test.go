@@ -0,0 +1,210 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://auth.example.test"
+
+// writeKey stores a new ed25519 key as dir/kid.pem, modified at modTime
+func writeKey(t *testing.T, dir, kid string, modTime time.Time) ed25519.PrivateKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, kid+".pem")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func newTestFileKeyStore(t *testing.T) (string, *FileKeyStore) {
+	t.Helper()
+	dir := t.TempDir()
+	writeKey(t, dir, "2025-01", time.Now().Add(-time.Hour))
+	store, err := NewFileKeyStore(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, store
+}
+
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestFileKeyStoreRotation(t *testing.T) {
+	dir, store := newTestFileKeyStore(t)
+	jwtService := NewJwtImpl(store, testIssuer)
+
+	before, err := jwtService.GenerateAccessToken("user-1", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate: the old key moves to retired/ and a new one becomes active
+	if err := os.MkdirAll(filepath.Join(dir, "retired"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "2025-01.pem"), filepath.Join(dir, "retired", "2025-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2025-05", time.Now())
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := jwtService.GenerateAccessToken("user-1", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKID(t, after); kid != "2025-05" {
+		t.Errorf("new token signed with %s, want 2025-05", kid)
+	}
+	if _, err := jwtService.ValidateAccessToken(before); err != nil {
+		t.Errorf("token signed before the rotation is rejected during the grace period: %v", err)
+	}
+	if _, err := jwtService.ValidateAccessToken(after); err != nil {
+		t.Errorf("token signed after the rotation: %v", err)
+	}
+	if n := len(store.PublicKeys()); n != 2 {
+		t.Errorf("%d keys published, want the active and the retired one", n)
+	}
+
+	// the grace period ends when the retired key is removed
+	if err := os.Remove(filepath.Join(dir, "retired", "2025-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwtService.ValidateAccessToken(before); err == nil {
+		t.Error("token of a removed key is still accepted")
+	}
+}
+
+func TestFileKeyStoreActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "older", time.Now().Add(-time.Hour))
+	writeKey(t, dir, "newer", time.Now())
+	writeKey(t, filepath.Join(dir, "retired"), "newest-retired", time.Now().Add(time.Hour))
+
+	store, err := NewFileKeyStore(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := store.SigningKey(); key.ID != "newer" {
+		t.Errorf("signing with %s, want the most recent active key", key.ID)
+	}
+
+	pinned, err := NewFileKeyStore(dir, "older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := pinned.SigningKey(); key.ID != "older" {
+		t.Errorf("signing with %s, want the configured key", key.ID)
+	}
+
+	if _, err := NewFileKeyStore(dir, "newest-retired"); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("retired key as signing key: err = %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestFileKeyStoreReloadKeepsKeysOnError(t *testing.T) {
+	dir, store := newTestFileKeyStore(t)
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Reload(); err == nil {
+		t.Fatal("a broken key file was loaded")
+	}
+	if key, err := store.SigningKey(); err != nil || key.ID != "2025-01" {
+		t.Errorf("signing key after a failed reload = %v, %v", key, err)
+	}
+}
+
+func TestValidateAccessTokenRejectsForgedHeaders(t *testing.T) {
+	_, store := newTestFileKeyStore(t)
+	jwtService := NewJwtImpl(store, testIssuer)
+
+	valid, err := jwtService.GenerateAccessToken("user-1", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the forged tokens carry exactly the claims of a valid one
+	parsed, _, err := jwt.NewParser().ParseUnverified(valid, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+	active, _ := store.SigningKey()
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    any
+		key    any
+	}{
+		{"unknown kid", jwt.SigningMethodEdDSA, "2024-12", otherKey},
+		{"known kid signed with another key", jwt.SigningMethodEdDSA, "2025-01", otherKey},
+		{"missing kid", jwt.SigningMethodEdDSA, nil, active.Private},
+		{"HS256 keyed with the public key", jwt.SigningMethodHS256, "2025-01", []byte(active.Public.(ed25519.PublicKey))},
+		{"HS256 with an unknown kid", jwt.SigningMethodHS256, "2024-12", []byte("secret")},
+		{"algorithm other than the key's", jwt.SigningMethodES256, "2025-01", ecKey},
+		{"none", jwt.SigningMethodNone, "2025-01", jwt.UnsafeAllowNoneSignatureType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, claims)
+			if tt.kid != nil {
+				token.Header["kid"] = tt.kid
+			}
+			forged, err := token.SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := jwtService.ValidateAccessToken(forged); err == nil {
+				t.Error("forged token accepted")
+			}
+		})
+	}
+
+	if _, err := jwtService.ValidateAccessToken(valid); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
@@ -121,7 +121,7 @@ func toSnakeCase(str string) string {
 
 // BuildUserListQuery constructs SQL query for user listing with dynamic filters
 func BuildUserListQuery(params PaginationParams) (string, string, []interface{}, error) {
-	baseQuery := "SELECT id, name, email, role, status, phone, created_at, updated_at FROM users"
+	baseQuery := "SELECT id, name, email, role, status, phone, created_at, updated_at, last_login_at, last_activity_at FROM users"
 	countQuery := "SELECT COUNT(*) FROM users"
 
 	whereConditions := []string{}
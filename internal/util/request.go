@@ -0,0 +1,168 @@
+package util
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientInfo describes where a request comes from, it is attached to the request context by middleware.ClientInfoMiddleware
+type ClientInfo struct {
+	IPAddress   string
+	UserAgent   string
+	DeviceLabel string
+	DeviceID    string
+}
+
+type clientInfoKey struct{}
+
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFromContext returns the client of the current request, empty outside of an HTTP request
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only read when the request comes from one of
+// the trusted proxies (Kong, Heroku router): its entries are walked from the last one, appended by the proxy
+// itself, as long as they are trusted proxies too. Earlier entries are supplied by the client and can be forged.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	ip := RemoteIP(r)
+	if !trustedProxy(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// RemoteIP returns the address of the peer of the connection, the client itself or the last proxy
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func trustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of proxy networks in CIDR notation, single addresses
+// are accepted as well
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// DeviceLabelFromUserAgent builds a short human readable label such as "Chrome on Windows"
+func DeviceLabelFromUserAgent(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := "unknown OS"
+	switch {
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	return browser + " on " + platform
+}
+
+// DeviceFingerprint identifies the device of the client, by the id the client app keeps for it or else by its user agent
+func (c ClientInfo) DeviceFingerprint() string {
+	if c.DeviceID != "" {
+		return HashToken("id:" + c.DeviceID)
+	}
+	return HashToken("ua:" + c.UserAgent)
+}
+
+// IPSubnet returns the /24 network of an IPv4 address or the /48 network of an IPv6 address, a client keeps
+// its subnet while its ISP reassigns its address
+func IPSubnet(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
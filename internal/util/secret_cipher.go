@@ -0,0 +1,82 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealedPrefix marks a value sealed by SecretCipher, the version allows changing the scheme later
+const sealedPrefix = "enc:v1:"
+
+var ErrSecretCorrupted = errors.New("sealed secret cannot be opened")
+
+// SecretCipher encrypts secrets that have to be read back, e.g. TOTP seeds, with AES-256-GCM before they are
+// stored. The associated data binds a sealed value to its row so it cannot be copied to another one.
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher takes a 32 byte key
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("secret encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretCipher{aead: aead}, nil
+}
+
+// NewSecretCipherFromBase64 takes the key in standard base64 encoding, as it is configured
+func NewSecretCipherFromBase64(encoded string) (*SecretCipher, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("secret encryption key must be base64 encoded")
+	}
+	return NewSecretCipher(key)
+}
+
+func (c *SecretCipher) Seal(plaintext string, associatedData string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(associatedData))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *SecretCipher) Open(value string, associatedData string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return "", ErrSecretCorrupted
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrSecretCorrupted
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(associatedData))
+	if err != nil {
+		return "", ErrSecretCorrupted
+	}
+
+	return string(plaintext), nil
+}
+
+// IsSealed tells sealed values from ones stored before encryption was introduced
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSecretCipher(t *testing.T) {
+	c, err := NewSecretCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := c.Seal("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "GEZDGNBVGY3TQOJQ") {
+		t.Fatalf("sealed value %q", sealed)
+	}
+	again, _ := c.Seal("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "user-1")
+	if again == sealed {
+		t.Error("sealing twice gave the same value, the nonce is not random")
+	}
+
+	plaintext, err := c.Open(sealed, "user-1")
+	if err != nil || plaintext != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("Open = %q, %v", plaintext, err)
+	}
+
+	other, _ := NewSecretCipher(bytes.Repeat([]byte{8}, 32))
+	tampered := sealed[:len(sealed)-2] + "AA"
+	tests := []struct {
+		name   string
+		cipher *SecretCipher
+		value  string
+		aad    string
+	}{
+		{"other row", c, sealed, "user-2"},
+		{"other key", other, sealed, "user-1"},
+		{"tampered", c, tampered, "user-1"},
+		{"plaintext", c, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "user-1"},
+		{"truncated", c, sealedPrefix + "AAAA", "user-1"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.cipher.Open(tt.value, tt.aad); !errors.Is(err, ErrSecretCorrupted) {
+			t.Errorf("%s: err = %v, want ErrSecretCorrupted", tt.name, err)
+		}
+	}
+}
+
+func TestNewSecretCipherFromBase64(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	if _, err := NewSecretCipherFromBase64(valid); err != nil {
+		t.Errorf("valid key: %v", err)
+	}
+
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16))} {
+		if _, err := NewSecretCipherFromBase64(key); err == nil {
+			t.Errorf("key %q was accepted", key)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package util
+
+import "testing"
+
+func TestHashToken(t *testing.T) {
+	// sha256("abc") from FIPS 180-2
+	if got := HashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("HashToken(abc) = %s", got)
+	}
+	if HashToken("token-a") == HashToken("token-b") {
+		t.Error("different tokens share a digest")
+	}
+}
+
+func TestGenerateRefreshTokenIsUnique(t *testing.T) {
+	t.Setenv("REFRESH_SECRET_KEY", "refresh-test-secret")
+	keys, err := NewEphemeralKeyStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtService := NewJwtImpl(keys, "https://auth.example.test")
+
+	// tokens are looked up by digest, two logins of the same user in the same second must not collide
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		token, err := jwtService.GenerateRefreshToken("user-1", "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[HashToken(token)] {
+			t.Fatalf("token %d has the digest of an earlier one", i)
+		}
+		seen[HashToken(token)] = true
+
+		if _, err := jwtService.ValidateRefreshToken(token); err != nil {
+			t.Errorf("token %d: %v", i, err)
+		}
+	}
+}
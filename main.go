@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gorilla/handlers"
 	"github.com/jmoiron/sqlx"
@@ -8,12 +9,28 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 	"user_service/api/middleware"
 	"user_service/internal/delivery/rest"
+	"user_service/internal/events"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/repository/memory"
 	"user_service/internal/repository/postgres"
 	"user_service/internal/service"
 	"user_service/internal/util"
+	"user_service/pkg/geoip"
 	pkg "user_service/pkg/logger"
+	"user_service/pkg/mail"
+	"user_service/pkg/oidc"
+	"user_service/pkg/password"
+	"user_service/pkg/policy"
+	"user_service/policies"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -76,16 +93,134 @@ func main() {
 	// Initialize repositories
 	userRepo := postgres.NewUserRepository(db)
 	authRepo := postgres.NewAuthRepository(db)
+	passwordResetRepo := postgres.NewPasswordResetRepository(db)
+	emailVerificationRepo := postgres.NewEmailVerificationRepository(db)
+	// TOTP secrets have to be read back, they are encrypted rather than hashed. Losing the key disables MFA for everyone.
+	mfaSecrets, err := util.NewSecretCipherFromBase64(getEnv("MFA_SECRET_KEY", ""))
+	if err != nil {
+		logger.Error("Invalid MFA_SECRET_KEY, expected 32 random bytes in base64", zap.Error(err))
+		os.Exit(1)
+	}
+	mfaRepo := postgres.NewMFARepository(db, mfaSecrets)
+	mfaChallengeRepo := postgres.NewMFAChallengeRepository(db)
+	loginFailureRepo := postgres.NewLoginFailureRepository(db)
+	magicLinkRepo := postgres.NewMagicLinkRepository(db)
+	oauthClientRepo := postgres.NewOAuthClientRepository(db)
+	authorizationCodeRepo := postgres.NewAuthorizationCodeRepository(db)
+	identityProviderRepo := postgres.NewIdentityProviderRepository(db)
+	externalIdentityRepo := postgres.NewExternalIdentityRepository(db)
+	externalLoginStateRepo := postgres.NewExternalLoginStateRepository(db)
+	personalAccessTokenRepo := postgres.NewPersonalAccessTokenRepository(db)
+	roleRepo := postgres.NewRoleRepository(db)
+	impersonationRepo := postgres.NewImpersonationRepository(db)
+	auditRepo := postgres.NewAuditRepository(db)
+	loginEventRepo := postgres.NewLoginEventRepository(db)
+
+	// access token revocations: the in-memory store is only correct when running a single instance
+	var revocationStore repository.RevocationStore
+	if getEnv("TOKEN_REVOCATION_STORE", "postgres") == "memory" {
+		revocationStore = memory.NewRevocationStore()
+	} else {
+		revocationStore = postgres.NewRevocationStore(db)
+	}
+
+	// rate limit counters: postgres when several instances run behind a load balancer
+	var rateLimitStore repository.RateLimitStore
+	if getEnv("RATE_LIMIT_STORE", "memory") == "postgres" {
+		rateLimitStore = postgres.NewRateLimitStore(db)
+	} else {
+		rateLimitStore = memory.NewRateLimitStore()
+	}
+
+	// mail sender: SMTP when configured, otherwise messages are written to a local outbox file
+	var mailer mail.Sender
+	if smtpHost := getEnv("SMTP_HOST", ""); smtpHost != "" {
+		mailer = mail.NewSMTPSender(smtpHost, getEnv("SMTP_PORT", "587"), getEnv("SMTP_USERNAME", ""),
+			getEnv("SMTP_PASSWORD", ""), getEnv("MAIL_FROM", "no-reply@m3xd.dev"))
+	} else {
+		mailer = mail.NewFileSender(getEnv("MAIL_OUTBOX", "./log/mail.txt"), logger)
+	}
 
 	// jwt service
-	jwtService := util.NewJwtImpl()
+	keyStore, err := newKeyStore(logger)
+	if err != nil {
+		logger.Error("Failed to load JWT signing keys", zap.Error(err))
+		os.Exit(1)
+	}
+	// the issuer is also the OpenID Connect issuer, set it to the public base URL when acting as identity provider
+	jwtService := util.NewJwtImpl(keyStore, getEnv("JWT_ISSUER", "user_service"))
+
+	passwordHasher, err := password.NewHasher(getEnv("PASSWORD_HASH_ALGORITHM", password.AlgorithmArgon2id), password.Argon2Params{
+		Memory:      uint32(getEnvInt("ARGON2_MEMORY_KIB", int(password.DefaultArgon2Params.Memory))),
+		Iterations:  uint32(getEnvInt("ARGON2_ITERATIONS", int(password.DefaultArgon2Params.Iterations))),
+		Parallelism: uint8(getEnvInt("ARGON2_PARALLELISM", int(password.DefaultArgon2Params.Parallelism))),
+		SaltLength:  password.DefaultArgon2Params.SaltLength,
+		KeyLength:   password.DefaultArgon2Params.KeyLength,
+	}, getEnvInt("BCRYPT_COST", 12))
+	if err != nil {
+		logger.Error("Invalid password hashing configuration", zap.Error(err))
+		os.Exit(1)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(passwordHasher)
+	if err != nil {
+		logger.Error("Failed to load password policy", zap.Error(err))
+		os.Exit(1)
+	}
 
 	// Initialize services
-	userService := service.NewUserService(userRepo, logger)
-	authService := service.NewAuthService(userRepo, jwtService, logger, authRepo)
+	emailVerificationMode := getEnv("EMAIL_VERIFICATION_MODE", service.EmailVerificationEnforce)
+	auditService := service.NewAuditService(auditRepo, logger)
+	loginHistoryService := service.NewLoginHistoryService(loginEventRepo, userRepo, logger, getEnvDuration("LAST_ACTIVITY_INTERVAL", 5*time.Minute))
+	userService := service.NewUserService(userRepo, authRepo, revocationStore, passwordPolicy, passwordHasher, auditService, logger, emailVerificationMode)
+	securityEvents := events.NewLogPublisher(logger)
+	magicLinkService := service.NewMagicLinkService(userService, magicLinkRepo, mailer, logger,
+		getEnv("MAGIC_LINK_URL", "http://localhost:3000/magic-link"), getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute))
+	suspiciousLoginService, err := newSuspiciousLoginService(loginEventRepo, magicLinkService, securityEvents, mailer, logger)
+	if err != nil {
+		logger.Error("Failed to set up suspicious login detection", zap.Error(err))
+		os.Exit(1)
+	}
+	authService := service.NewAuthService(userRepo, jwtService, logger, authRepo, securityEvents, revocationStore, auditService, loginHistoryService,
+		suspiciousLoginService)
+	oauthService := service.NewOAuthService(oauthClientRepo, authorizationCodeRepo, userRepo, authRepo, revocationStore, jwtService, securityEvents, logger,
+		models.OAuthConfig{
+			AuthorizationCodeTTL: getEnvDuration("OAUTH_CODE_TTL", 1*time.Minute),
+			RefreshTokenTTL:      getEnvDuration("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		})
+	federationService := service.NewFederationService(identityProviderRepo, externalIdentityRepo, externalLoginStateRepo, userService,
+		oidc.NewClient(nil, getEnvDuration("EXTERNAL_OIDC_CACHE_TTL", time.Hour)), logger,
+		getEnv("EXTERNAL_LOGIN_CALLBACK_URL", "http://localhost:3000/login/{provider}/callback"), getEnvDuration("EXTERNAL_LOGIN_TTL", 10*time.Minute))
+	lockoutService := service.NewLockoutService(loginFailureRepo, models.LockoutPolicy{
+		MaxAccountFailures: getEnvInt("LOCKOUT_MAX_ACCOUNT_FAILURES", 10),
+		MaxIPFailures:      getEnvInt("LOCKOUT_MAX_IP_FAILURES", 100),
+		LockDuration:       getEnvDuration("LOCKOUT_DURATION", 15*time.Minute),
+		Window:             getEnvDuration("LOCKOUT_WINDOW", 15*time.Minute),
+		DelayAfter:         getEnvInt("LOCKOUT_DELAY_AFTER", 3),
+		BaseDelay:          getEnvDuration("LOCKOUT_BASE_DELAY", 1*time.Second),
+		MaxDelay:           getEnvDuration("LOCKOUT_MAX_DELAY", 30*time.Second),
+	}, logger)
+	passwordResetService := service.NewPasswordResetService(userService, passwordResetRepo, authRepo, lockoutService, mailer, logger,
+		getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"), getEnvDuration("PASSWORD_RESET_TTL", 30*time.Minute))
+	emailVerificationService := service.NewEmailVerificationService(userService, emailVerificationRepo, mailer, logger, emailVerificationMode,
+		getEnv("EMAIL_VERIFICATION_URL", "http://localhost:3000/verify-email"), getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour))
+	mfaService := service.NewMFAService(mfaRepo, mfaChallengeRepo, userService, loginHistoryService, lockoutService, logger,
+		getEnv("MFA_ISSUER", "User Service"), getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute))
+	personalAccessTokenService := service.NewPersonalAccessTokenService(personalAccessTokenRepo, userRepo, logger)
+	introspectionService := service.NewIntrospectionService(jwtService, authRepo, revocationStore, personalAccessTokenService, logger)
+	rbacService := service.NewRBACService(roleRepo, userRepo, auditService, logger, getEnvDuration("RBAC_CACHE_TTL", time.Minute))
+	impersonationService := service.NewImpersonationService(impersonationRepo, userRepo, rbacService, revocationStore, jwtService, securityEvents,
+		auditService, logger, getEnvDuration("IMPERSONATION_TTL", 15*time.Minute))
+
+	policyEngine, err := newPolicyEngine(logger)
+	if err != nil {
+		logger.Error("Failed to load access policies", zap.Error(err))
+		os.Exit(1)
+	}
 
 	// Initialize auth middleware
-	authMiddleware := middleware.NewAuthMiddleware(jwtService)
+	authMiddleware := middleware.NewAuthMiddleware(jwtService, revocationStore, personalAccessTokenService, rbacService, policyEngine, impersonationService,
+		loginHistoryService)
 
 	port := getEnv("PORT", "8083")
 
@@ -101,14 +236,49 @@ func main() {
 		httpSwagger.DomID("swagger-ui"),
 	)).Methods(http.MethodGet)
 	router.Use(middleware.NewLogMiddleware(logger).LoggingMiddleware)
+	trustedProxies, err := util.ParseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		logger.Error("Invalid TRUSTED_PROXIES", zap.Error(err))
+		os.Exit(1)
+	}
+	router.Use(middleware.ClientInfoMiddleware(trustedProxies))
+	router.Use(newRateLimitMiddleware(rateLimitStore, jwtService, logger))
 
 	// Initialize handlers
 	userHandler := rest.NewUserHandler(userService, logger, authMiddleware)
-	authHandler := rest.NewAuthHandler(authService, userService, router, logger, *jwtService)
+	authHandler := rest.NewAuthHandler(authService, userService, passwordResetService, emailVerificationService, mfaService, lockoutService,
+		loginHistoryService, router, logger, *jwtService)
+
+	mfaHandler := rest.NewMFAHandler(mfaService, authService, authMiddleware, logger)
+	wellKnownHandler := rest.NewWellKnownHandler(keyStore, jwtService.Issuer(), logger)
+	sessionHandler := rest.NewSessionHandler(authService, authMiddleware, logger)
+	magicLinkHandler := rest.NewMagicLinkHandler(magicLinkService, mfaService, authService, logger)
+	oauthHandler := rest.NewOAuthHandler(oauthService, introspectionService, authMiddleware, logger, getEnv("OAUTH_LOGIN_URL", "http://localhost:3000/oauth/login"))
+	externalLoginHandler := rest.NewExternalLoginHandler(federationService, mfaService, authService, authMiddleware, logger)
+	adminHandler := rest.NewAdminHandler(userService, lockoutService, authMiddleware, logger)
+	personalAccessTokenHandler := rest.NewPersonalAccessTokenHandler(personalAccessTokenService, authMiddleware, logger)
+	forwardAuthHandler := rest.NewForwardAuthHandler(authMiddleware, logger)
+	rbacHandler := rest.NewRBACHandler(rbacService, authMiddleware, logger)
+	impersonationHandler := rest.NewImpersonationHandler(impersonationService, authMiddleware, logger)
+	auditHandler := rest.NewAuditHandler(auditService, authMiddleware, logger)
+	loginHistoryHandler := rest.NewLoginHistoryHandler(loginHistoryService, authMiddleware, logger)
 
 	// Register routes
 	userHandler.RegisterRoutes(router)
 	authHandler.RegisterRoutes()
+	mfaHandler.RegisterRoutes(router)
+	wellKnownHandler.RegisterRoutes(router)
+	sessionHandler.RegisterRoutes(router)
+	magicLinkHandler.RegisterRoutes(router)
+	oauthHandler.RegisterRoutes(router)
+	externalLoginHandler.RegisterRoutes(router)
+	adminHandler.RegisterRoutes(router)
+	personalAccessTokenHandler.RegisterRoutes(router)
+	forwardAuthHandler.RegisterRoutes(router)
+	rbacHandler.RegisterRoutes(router)
+	impersonationHandler.RegisterRoutes(router)
+	auditHandler.RegisterRoutes(router)
+	loginHistoryHandler.RegisterRoutes(router)
 
 	fmt.Println(os.Getenv("SECRET_KEY"))
 	// Start server
@@ -130,3 +300,190 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// newRateLimitMiddleware limits requests per route, the credential endpoints are limited per client IP
+// and much stricter than the authenticated API
+func newRateLimitMiddleware(store repository.RateLimitStore, jwtService util.Jwt, logger *zap.Logger) mux.MiddlewareFunc {
+	limiter := middleware.NewRateLimiter(store, logger)
+	perIP := func(name string, limit int, window time.Duration) middleware.RateLimitPolicy {
+		return middleware.RateLimitPolicy{Name: name, Limit: limit, Window: window, Key: middleware.KeyByIP}
+	}
+
+	return limiter.RouteMiddleware(map[string]middleware.RateLimitPolicy{
+		"/auth/login":               perIP("login", getEnvInt("RATE_LIMIT_LOGIN", 10), time.Minute),
+		"/auth/register":            perIP("register", getEnvInt("RATE_LIMIT_REGISTER", 5), time.Hour),
+		"/auth/forgot-password":     perIP("forgot-password", 5, time.Hour),
+		"/auth/resend-verification": perIP("resend-verification", 5, time.Hour),
+		"/auth/mfa/verify":          perIP("mfa-verify", 10, time.Minute),
+		"/auth/magic-link":          perIP("magic-link", 5, time.Hour),
+		"/auth/magic-link/consume":  perIP("magic-link-consume", 20, time.Minute),
+		"/auth/external":            perIP("external-login", 30, time.Minute),
+		"/oauth/token":              perIP("oauth-token", getEnvInt("RATE_LIMIT_OAUTH_TOKEN", 60), time.Minute),
+		// the gateway calls it for every request it forwards, so it is limited per user rather than per gateway IP
+		"/auth/verify": {
+			Name:   "verify",
+			Limit:  getEnvInt("RATE_LIMIT_VERIFY", 600),
+			Window: time.Minute,
+			Key:    middleware.KeyByUser(jwtService),
+		},
+		"/users": {
+			Name:   "users",
+			Limit:  getEnvInt("RATE_LIMIT_USERS", 120),
+			Window: time.Minute,
+			Key:    middleware.KeyByUser(jwtService),
+		},
+	}, perIP("default", getEnvInt("RATE_LIMIT_DEFAULT", 300), time.Minute))
+}
+
+// newPasswordPolicy builds the password policy, PASSWORD_BREACHED_DIR points to an optional directory of
+// breached password SHA-1 hash ranges, see password.OpenBreachedRanges
+func newPasswordPolicy(hasher *password.Hasher) (*password.Policy, error) {
+	// bcrypt only hashes the first 72 bytes
+	maxLength := 128
+	if hasher.Algorithm == password.AlgorithmBcrypt {
+		maxLength = 72
+	}
+
+	policy := &password.Policy{
+		MinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:     maxLength,
+		RequireUpper:  getEnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  getEnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+	}
+
+	if dir := getEnv("PASSWORD_BREACHED_DIR", ""); dir != "" {
+		breached, err := password.OpenBreachedRanges(dir)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// newPolicyEngine loads the policy files listed in POLICY_FILES, comma separated, or the embedded policies.
+// The tests in the files have to pass. Decisions are logged at info level by the "policy" logger.
+func newPolicyEngine(logger *zap.Logger) (*policy.Engine, error) {
+	var docs []*policy.Document
+	if files := getEnv("POLICY_FILES", ""); files != "" {
+		for _, name := range strings.Split(files, ",") {
+			doc, err := policy.LoadFile(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+	} else {
+		var err error
+		if docs, err = policies.Default(); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy.NewEngine(logger.Named("policy"), docs...)
+}
+
+// newSuspiciousLoginService configures the suspicious login detection. SUSPICIOUS_LOGIN_ACTIONS lists what is done
+// about a suspicious login (notify, step_up, revoke) and SUSPICIOUS_LOGIN_NOTIFIERS who is told (events, email).
+// Impossible travel is only detected with the GeoIP database of GEOIP_CSV.
+func newSuspiciousLoginService(loginEventRepo repository.LoginEventRepository, magicLinkService service.MagicLinkService,
+	securityEvents events.Publisher, mailer mail.Sender, logger *zap.Logger) (service.SuspiciousLoginService, error) {
+	loginPolicy := models.SuspiciousLoginPolicy{
+		History:        getEnvInt("SUSPICIOUS_LOGIN_HISTORY", 20),
+		MaxTravelSpeed: float64(getEnvInt("IMPOSSIBLE_TRAVEL_SPEED_KMH", 1000)),
+		MinTravel:      float64(getEnvInt("IMPOSSIBLE_TRAVEL_MIN_KM", 200)),
+	}
+	for _, action := range strings.Split(getEnv("SUSPICIOUS_LOGIN_ACTIONS", models.SuspiciousActionNotify), ",") {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		if !slices.Contains(models.SuspiciousActions, action) {
+			return nil, fmt.Errorf("unknown suspicious login action %q", action)
+		}
+		loginPolicy.Actions = append(loginPolicy.Actions, action)
+	}
+
+	var notifiers []service.LoginNotifier
+	for _, name := range strings.Split(getEnv("SUSPICIOUS_LOGIN_NOTIFIERS", "events"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "events":
+			notifiers = append(notifiers, service.NewEventLoginNotifier(securityEvents))
+		case "email":
+			notifiers = append(notifiers, service.NewMailLoginNotifier(mailer))
+		default:
+			return nil, fmt.Errorf("unknown suspicious login notifier %q", name)
+		}
+	}
+
+	var geo *geoip.DB
+	if file := getEnv("GEOIP_CSV", ""); file != "" {
+		var err error
+		if geo, err = geoip.LoadFile(file); err != nil {
+			return nil, err
+		}
+		logger.Info("GeoIP database loaded", zap.String("file", file), zap.Int("networks", geo.Len()))
+	}
+
+	return service.NewSuspiciousLoginService(loginEventRepo, geo, magicLinkService, notifiers, loginPolicy, logger), nil
+}
+
+// newKeyStore loads the JWT signing keys from JWT_KEYS_DIR. Keys are rotated by adding a new key to the
+// directory, moving the previous one to the retired folder and sending SIGHUP.
+func newKeyStore(logger *zap.Logger) (util.KeyStore, error) {
+	dir := getEnv("JWT_KEYS_DIR", "./keys")
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		logger.Warn("JWT key directory not found, using an ephemeral signing key", zap.String("dir", dir))
+		return util.NewEphemeralKeyStore()
+	}
+
+	store, err := util.NewFileKeyStore(dir, getEnv("JWT_SIGNING_KID", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := store.Reload(); err != nil {
+				logger.Error("Failed to reload JWT signing keys", zap.Error(err))
+				continue
+			}
+			logger.Info("JWT signing keys reloaded")
+		}
+	}()
+
+	return store, nil
+}
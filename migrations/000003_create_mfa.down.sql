@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS mfa_challenge_tokens;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
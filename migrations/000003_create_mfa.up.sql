@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS user_mfa
+(
+    user_id        UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret         TEXT        NOT NULL,
+    enabled        BOOLEAN     NOT NULL DEFAULT FALSE,
+    confirmed_at   TIMESTAMPTZ,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes
+(
+    id         UUID PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  TEXT        NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenge_tokens
+(
+    id         UUID PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash TEXT        NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenge_tokens_user_id ON mfa_challenge_tokens (user_id);
@@ -0,0 +1,9 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+
+-- every existing token starts its own family
+UPDATE refresh_tokens SET family_id = id::uuid WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
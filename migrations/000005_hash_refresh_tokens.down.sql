@@ -0,0 +1,7 @@
+-- the raw tokens cannot be recovered from their digest, every session is revoked
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token TEXT;
+
+UPDATE refresh_tokens SET token = token_hash, is_revoked = true;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_token_hash;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token_hash;
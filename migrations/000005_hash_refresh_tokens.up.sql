@@ -0,0 +1,17 @@
+-- refresh tokens are looked up by their SHA-256 digest, the raw JWT is no longer stored.
+-- Existing rows are rehashed in place so current sessions keep working (sha256() requires PostgreSQL 11+).
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash TEXT;
+
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex') WHERE token_hash IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
+
+-- refresh tokens used to have no random part, the same token could be stored twice when issued in the same second.
+-- Which session such a token belongs to cannot be told, the duplicates are dropped and their users sign in again.
+DELETE FROM refresh_tokens WHERE token_hash IN (
+    SELECT token_hash FROM refresh_tokens GROUP BY token_hash HAVING count(*) > 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token;
@@ -0,0 +1,14 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_label TEXT NOT NULL DEFAULT '';
+-- created_at is the start of the session (login) and is carried over when the token is rotated
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET created_at = issued_at WHERE created_at IS NULL;
+UPDATE refresh_tokens SET last_used_at = issued_at WHERE last_used_at IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id_active ON refresh_tokens (user_id) WHERE is_revoked = false;
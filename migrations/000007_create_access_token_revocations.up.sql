@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS revoked_access_tokens
+(
+    jti        TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_access_tokens_expires_at ON revoked_access_tokens (expires_at);
+
+-- access tokens of a user issued before valid_after are rejected. No foreign key: the row has to outlive a
+-- deleted user until their last access token expired.
+CREATE TABLE IF NOT EXISTS user_token_validity
+(
+    user_id     UUID PRIMARY KEY,
+    valid_after TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,10 @@
+-- key is "account:<email>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    key             TEXT PRIMARY KEY,
+    failures        INTEGER     NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure_at ON login_failures (last_failure_at);
@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS client_id;
+
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,32 @@
+-- clients of the OAuth2 / OpenID Connect provider. Public clients (SPAs, mobile apps) have no secret and must use PKCE.
+CREATE TABLE IF NOT EXISTS oauth_clients
+(
+    id            TEXT PRIMARY KEY,
+    secret_hash   TEXT        NOT NULL DEFAULT '',
+    name          TEXT        NOT NULL,
+    redirect_uris TEXT[]      NOT NULL DEFAULT '{}',
+    grant_types   TEXT[]      NOT NULL DEFAULT '{}',
+    scopes        TEXT[]      NOT NULL DEFAULT '{}',
+    is_public     BOOLEAN     NOT NULL DEFAULT false,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes
+(
+    code_hash             TEXT PRIMARY KEY,
+    client_id             TEXT        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id               UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri          TEXT        NOT NULL,
+    scope                 TEXT        NOT NULL DEFAULT '',
+    nonce                 TEXT        NOT NULL DEFAULT '',
+    code_challenge        TEXT        NOT NULL DEFAULT '',
+    code_challenge_method TEXT        NOT NULL DEFAULT '',
+    auth_time             TIMESTAMPTZ NOT NULL,
+    expires_at            TIMESTAMPTZ NOT NULL,
+    used_at               TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- OAuth refresh tokens are stored with the first-party ones, client_id is NULL for tokens issued by /auth/login
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id TEXT REFERENCES oauth_clients (id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,40 @@
+-- external OpenID Connect providers users can sign in with, managed through the admin API.
+-- The client secret has to be sent to the provider and is therefore stored as is.
+CREATE TABLE IF NOT EXISTS identity_providers
+(
+    id            TEXT PRIMARY KEY,
+    name          TEXT        NOT NULL,
+    issuer        TEXT        NOT NULL,
+    client_id     TEXT        NOT NULL,
+    client_secret TEXT        NOT NULL DEFAULT '',
+    scopes        TEXT[]      NOT NULL DEFAULT '{}',
+    enabled       BOOLEAN     NOT NULL DEFAULT true,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS external_identities
+(
+    id            UUID PRIMARY KEY,
+    user_id       UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider_id   TEXT        NOT NULL REFERENCES identity_providers (id) ON DELETE CASCADE,
+    subject       TEXT        NOT NULL,
+    email         TEXT        NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider_id, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities (user_id);
+
+-- pending login requests, the state parameter identifies them and only its hash is stored
+CREATE TABLE IF NOT EXISTS external_login_states
+(
+    state_hash    TEXT PRIMARY KEY,
+    provider_id   TEXT        NOT NULL REFERENCES identity_providers (id) ON DELETE CASCADE,
+    nonce         TEXT        NOT NULL,
+    code_verifier TEXT        NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    used_at       TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
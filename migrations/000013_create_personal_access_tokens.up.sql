@@ -0,0 +1,17 @@
+-- long-lived tokens for scripts and automation. Only the hash of a token is stored, the prefix is its
+-- first characters so a user can tell their tokens apart.
+CREATE TABLE IF NOT EXISTS personal_access_tokens
+(
+    id           UUID PRIMARY KEY,
+    user_id      UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT        NOT NULL,
+    token_hash   TEXT        NOT NULL UNIQUE,
+    prefix       TEXT        NOT NULL,
+    scopes       TEXT[]      NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
@@ -0,0 +1,47 @@
+-- roles group permissions. users.role stays the primary role of a user, user_roles grants additional ones.
+-- The admin role holds every permission without rows in role_permissions.
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          TEXT PRIMARY KEY,
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    is_system   BOOLEAN     NOT NULL DEFAULT false,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- the permissions the code checks, see models.Permissions
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id          TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id       TEXT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id TEXT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id    TEXT        NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (id, name, description, is_system)
+VALUES ('admin', 'Administrator', 'Every permission', true),
+       ('user', 'User', 'Default role of new accounts', true)
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO permissions (id, description)
+VALUES ('users:create', 'Create user accounts'),
+       ('users:delete', 'Delete user accounts'),
+       ('users:unlock', 'Unlock accounts locked after failed logins'),
+       ('roles:manage', 'Manage roles and the roles of users')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE id = 'users:impersonate';
+DROP TABLE IF EXISTS impersonation_actions;
+DROP TABLE IF EXISTS impersonation_sessions;
@@ -0,0 +1,33 @@
+-- audit trail of admins acting as other users. The ids are not foreign keys, the trail outlives the
+-- accounts.
+CREATE TABLE IF NOT EXISTS impersonation_sessions
+(
+    id         UUID PRIMARY KEY,
+    actor_id   UUID        NOT NULL,
+    subject_id UUID        NOT NULL,
+    reason     TEXT        NOT NULL,
+    ip         TEXT        NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_actor_id ON impersonation_sessions (actor_id);
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_subject_id ON impersonation_sessions (subject_id);
+
+-- every request made with an impersonation token, blocked ones included
+CREATE TABLE IF NOT EXISTS impersonation_actions
+(
+    id         BIGSERIAL PRIMARY KEY,
+    session_id UUID        NOT NULL REFERENCES impersonation_sessions (id) ON DELETE CASCADE,
+    method     TEXT        NOT NULL,
+    path       TEXT        NOT NULL,
+    status     INTEGER     NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_actions_session_id ON impersonation_actions (session_id);
+
+INSERT INTO permissions (id, description)
+VALUES ('users:impersonate', 'Act as another user to see what they see')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,47 @@
+-- append-only record of security and admin events. Each row holds the hash of the previous one, editing
+-- or removing a row breaks the chain from there on, see GET /admin/audit-events/verify.
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id              BIGSERIAL PRIMARY KEY,
+    occurred_at     TIMESTAMPTZ NOT NULL,
+    actor_type      TEXT        NOT NULL,
+    actor_id        TEXT        NOT NULL DEFAULT '',
+    impersonator_id TEXT        NOT NULL DEFAULT '',
+    action          TEXT        NOT NULL,
+    target_type     TEXT        NOT NULL DEFAULT '',
+    target_id       TEXT        NOT NULL DEFAULT '',
+    ip              TEXT        NOT NULL DEFAULT '',
+    user_agent      TEXT        NOT NULL DEFAULT '',
+    before          JSONB,
+    after           JSONB,
+    metadata        JSONB,
+    prev_hash       TEXT        NOT NULL,
+    hash            TEXT        NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events (occurred_at);
+
+-- the service only ever inserts, refuse everything else so a compromised application cannot rewrite history
+CREATE OR REPLACE FUNCTION audit_events_immutable() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_no_update ON audit_events;
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_immutable();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_immutable();
+
+INSERT INTO permissions (id, description)
+VALUES ('audit:read', 'Read and verify the audit log')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS login_events;
+ALTER TABLE users DROP COLUMN IF EXISTS last_activity_at;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
@@ -0,0 +1,19 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_activity_at TIMESTAMPTZ;
+
+-- every login attempt. user_id is NULL for attempts on unknown emails, the email is kept as typed.
+CREATE TABLE IF NOT EXISTS login_events
+(
+    id             UUID PRIMARY KEY,
+    user_id        UUID REFERENCES users (id) ON DELETE CASCADE,
+    email          TEXT        NOT NULL DEFAULT '',
+    success        BOOLEAN     NOT NULL,
+    method         TEXT        NOT NULL,
+    failure_reason TEXT        NOT NULL DEFAULT '',
+    ip_address     TEXT        NOT NULL DEFAULT '',
+    user_agent     TEXT        NOT NULL DEFAULT '',
+    device_label   TEXT        NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id_created_at ON login_events (user_id, created_at DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE login_events DROP COLUMN IF EXISTS suspicious_reasons;
+ALTER TABLE login_events DROP COLUMN IF EXISTS ip_subnet;
+ALTER TABLE login_events DROP COLUMN IF EXISTS device_fingerprint;
@@ -0,0 +1,4 @@
+ALTER TABLE login_events ADD COLUMN IF NOT EXISTS device_fingerprint TEXT NOT NULL DEFAULT '';
+ALTER TABLE login_events ADD COLUMN IF NOT EXISTS ip_subnet TEXT NOT NULL DEFAULT '';
+-- why the login was flagged, empty for ordinary logins
+ALTER TABLE login_events ADD COLUMN IF NOT EXISTS suspicious_reasons TEXT[] NOT NULL DEFAULT '{}';
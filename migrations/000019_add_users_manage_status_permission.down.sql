@@ -0,0 +1 @@
+DELETE FROM permissions WHERE id = 'users:manage-status';
@@ -0,0 +1,4 @@
+-- changing the status of an account, e.g. activating an unverified one, needs its own permission
+INSERT INTO permissions (id, description)
+VALUES ('users:manage-status', 'Activate and deactivate accounts')
+ON CONFLICT (id) DO NOTHING;
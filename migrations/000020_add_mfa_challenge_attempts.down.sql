@@ -0,0 +1 @@
+ALTER TABLE mfa_challenge_tokens DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,2 @@
+-- wrong codes are counted per challenge, a challenge is consumed after too many
+ALTER TABLE mfa_challenge_tokens ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- sealed secrets stay sealed, the previous version cannot read them: disable MFA for affected users before downgrading
+COMMENT ON COLUMN user_mfa.secret IS NULL;
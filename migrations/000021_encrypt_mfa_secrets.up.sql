@@ -0,0 +1,3 @@
+-- TOTP secrets are sealed with AES-256-GCM under MFA_SECRET_KEY ("enc:v1:" prefix), the service cannot do it here
+-- without the key: rows stored in plaintext before are sealed the first time they are read.
+COMMENT ON COLUMN user_mfa.secret IS 'TOTP secret sealed with MFA_SECRET_KEY, plaintext only for rows not read since 000021';
@@ -0,0 +1,161 @@
+// Package geoip looks up the approximate location of IP addresses in a database loaded from a local CSV
+// file, no request leaves the service. The file lists networks in CIDR notation with their coordinates, the
+// GeoLite2 City blocks files (GeoLite2-City-Blocks-IPv4.csv, ...) can be used as they are.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Location is where an address is, Country is empty when the file has no country column
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Country   string
+}
+
+type network struct {
+	prefix   netip.Prefix
+	location Location
+}
+
+// DB holds the networks sorted by their first address
+type DB struct {
+	networks []network
+}
+
+var ErrInvalidDatabase = errors.New("invalid geoip database")
+
+// Columns read from the CSV header, the country column is optional
+var (
+	networkColumns   = []string{"network"}
+	latitudeColumns  = []string{"latitude", "lat"}
+	longitudeColumns = []string{"longitude", "lon", "lng"}
+	countryColumns   = []string{"country_iso_code", "country"}
+)
+
+// LoadFile loads a CSV database, see Parse
+func LoadFile(name string) (*DB, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return db, nil
+}
+
+// Parse reads a CSV file whose header names a network, a latitude and a longitude column. Networks without
+// coordinates are skipped, the GeoLite2 files list some with a country only.
+func Parse(r io.Reader) (*DB, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	networkCol, latitudeCol, longitudeCol := column(header, networkColumns), column(header, latitudeColumns), column(header, longitudeColumns)
+	countryCol := column(header, countryColumns)
+	if networkCol < 0 || latitudeCol < 0 || longitudeCol < 0 {
+		return nil, fmt.Errorf("%w: the header needs network, latitude and longitude columns", ErrInvalidDatabase)
+	}
+
+	db := &DB{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+		}
+		if record[latitudeCol] == "" || record[longitudeCol] == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(record[networkCol])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidDatabase, line, err)
+		}
+		latitude, err := strconv.ParseFloat(record[latitudeCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid latitude", ErrInvalidDatabase, line)
+		}
+		longitude, err := strconv.ParseFloat(record[longitudeCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid longitude", ErrInvalidDatabase, line)
+		}
+
+		location := Location{Latitude: latitude, Longitude: longitude}
+		if countryCol >= 0 {
+			location.Country = record[countryCol]
+		}
+		db.networks = append(db.networks, network{prefix: prefix.Masked(), location: location})
+	}
+
+	slices.SortFunc(db.networks, func(a, b network) int { return a.prefix.Addr().Compare(b.prefix.Addr()) })
+	return db, nil
+}
+
+func column(header []string, names []string) int {
+	for i, name := range header {
+		if slices.Contains(names, strings.ToLower(strings.TrimSpace(name))) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lookup returns the location of the network containing the address
+func (db *DB) Lookup(ip string) (Location, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	addr = addr.Unmap()
+
+	// the last network starting at or before the address is the only one that can contain it, networks
+	// of a GeoIP database do not overlap
+	i, found := slices.BinarySearchFunc(db.networks, addr, func(n network, addr netip.Addr) int { return n.prefix.Addr().Compare(addr) })
+	if !found {
+		i--
+	}
+	if i < 0 || !db.networks[i].prefix.Contains(addr) {
+		return Location{}, false
+	}
+	return db.networks[i].location, true
+}
+
+// Len returns the number of networks in the database
+func (db *DB) Len() int {
+	return len(db.networks)
+}
+
+const earthRadiusKm = 6371
+
+// Distance returns the great-circle distance between two locations in kilometers
+func Distance(a, b Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat, dLon := lat2-lat1, radians(b.Longitude-a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,117 @@
+package geoip
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// a GeoLite2 City blocks file, trimmed to the columns that matter
+const geoLiteCSV = `network,geoname_id,registered_country_geoname_id,latitude,longitude,accuracy_radius
+203.0.113.0/24,1581130,1562822,21.0285,105.8542,20
+198.51.100.0/24,1566083,1562822,10.8231,106.6297,20
+192.0.2.128/25,2988507,3017382,48.8566,2.3522,50
+192.0.2.0/25,,3017382,,,
+2001:db8::/32,2643743,2635167,51.5074,-0.1278,100
+`
+
+func TestParseAndLookup(t *testing.T) {
+	db, err := Parse(strings.NewReader(geoLiteCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the network without coordinates is skipped
+	if db.Len() != 4 {
+		t.Errorf("Len = %d, want 4", db.Len())
+	}
+
+	tests := []struct {
+		ip       string
+		wantOK   bool
+		wantLat  float64
+		wantLong float64
+	}{
+		{"203.0.113.0", true, 21.0285, 105.8542},
+		{"203.0.113.255", true, 21.0285, 105.8542},
+		{"198.51.100.7", true, 10.8231, 106.6297},
+		{"192.0.2.200", true, 48.8566, 2.3522},
+		{"::ffff:203.0.113.9", true, 21.0285, 105.8542},
+		{"2001:db8:1::1", true, 51.5074, -0.1278},
+		{"192.0.2.1", false, 0, 0},
+		{"203.0.114.1", false, 0, 0},
+		{"1.1.1.1", false, 0, 0},
+		{"2001:db9::1", false, 0, 0},
+		{"not an ip", false, 0, 0},
+		{"", false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		location, ok := db.Lookup(tt.ip)
+		if ok != tt.wantOK || location.Latitude != tt.wantLat || location.Longitude != tt.wantLong {
+			t.Errorf("Lookup(%q) = %+v, %v, want (%v, %v), %v", tt.ip, location, ok, tt.wantLat, tt.wantLong, tt.wantOK)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		csv         string
+		wantErr     bool
+		wantCountry string
+	}{
+		{"short column names with country", "Network,Lat,Lng,Country\n203.0.113.0/24,21.0,105.8,VN\n", false, "VN"},
+		{"unmasked network", "network,latitude,longitude\n203.0.113.7/24,21.0,105.8\n", false, ""},
+		{"missing longitude column", "network,latitude\n203.0.113.0/24,21.0\n", true, ""},
+		{"empty file", "", true, ""},
+		{"invalid network", "network,latitude,longitude\n203.0.113/24,21.0,105.8\n", true, ""},
+		{"invalid latitude", "network,latitude,longitude\n203.0.113.0/24,north,105.8\n", true, ""},
+		{"missing field", "network,latitude,longitude\n203.0.113.0/24,21.0\n", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Parse(strings.NewReader(tt.csv))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDatabase) {
+					t.Errorf("err = %v, want ErrInvalidDatabase", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			location, ok := db.Lookup("203.0.113.20")
+			if !ok || location.Country != tt.wantCountry {
+				t.Errorf("Lookup = %+v, %v, want country %q", location, ok, tt.wantCountry)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	hanoi := Location{Latitude: 21.0285, Longitude: 105.8542}
+	saigon := Location{Latitude: 10.8231, Longitude: 106.6297}
+	paris := Location{Latitude: 48.8566, Longitude: 2.3522}
+	london := Location{Latitude: 51.5074, Longitude: -0.1278}
+
+	tests := []struct {
+		name string
+		a, b Location
+		want float64
+	}{
+		{"same place", hanoi, hanoi, 0},
+		{"Hanoi to Saigon", hanoi, saigon, 1138},
+		{"Paris to London", paris, london, 344},
+		{"symmetric", london, paris, 344},
+		{"antipodes", Location{Latitude: 0, Longitude: 0}, Location{Latitude: 0, Longitude: 180}, math.Pi * earthRadiusKm},
+		{"across the date line", Location{Latitude: 0, Longitude: 179.5}, Location{Latitude: 0, Longitude: -179.5}, 111},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); math.Abs(got-tt.want) > 1 {
+			t.Errorf("%s: Distance = %.1f km, want %.0f km", tt.name, got, tt.want)
+		}
+	}
+}
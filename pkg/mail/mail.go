@@ -0,0 +1,85 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers transactional emails (password reset, ...)
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// fileSender appends every message to a local file instead of delivering it. Used for local development.
+type fileSender struct {
+	path string
+	log  *zap.Logger
+	mu   sync.Mutex
+}
+
+func NewFileSender(path string, log *zap.Logger) Sender {
+	return &fileSender{path: path, log: log}
+}
+
+func (s *fileSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n----\n",
+		time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("[Mail][FileSender] message written to outbox",
+		zap.String("to", msg.To), zap.String("subject", msg.Subject), zap.String("path", s.path))
+
+	return nil
+}
+
+// headerSanitizer strips line breaks so user supplied values cannot inject extra headers
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+type smtpSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPSender(host, port, username, password, from string) Sender {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpSender{addr: host + ":" + port, auth: auth, from: from}
+}
+
+func (s *smtpSender) Send(ctx context.Context, msg Message) error {
+	var b strings.Builder
+	b.WriteString("From: " + s.from + "\r\n")
+	b.WriteString("To: " + headerSanitizer.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + headerSanitizer.Replace(msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{headerSanitizer.Replace(msg.To)}, []byte(b.String()))
+}
@@ -0,0 +1,275 @@
+// Package oidc is a minimal OpenID Connect relying party: provider discovery, the authorization code
+// exchange and ID token verification against the provider's JWKS. Plain http issuers are accepted so it
+// can be pointed at a local stub provider.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrIssuerMismatch = errors.New("issuer of the discovery document does not match")
+	ErrNoIDToken      = errors.New("token response has no id_token")
+	ErrNonceMismatch  = errors.New("id token nonce does not match")
+	ErrKeyNotFound    = errors.New("id token signing key not found")
+)
+
+// Provider is the subset of the discovery document used by the client
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the verified claims of an ID token the client cares about
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type cachedProvider struct {
+	provider  *Provider
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// Client talks to any number of providers, discovery documents and keys are cached for cacheTTL
+type Client struct {
+	http     *http.Client
+	cacheTTL time.Duration
+
+	mu        sync.Mutex
+	providers map[string]*cachedProvider
+}
+
+func NewClient(httpClient *http.Client, cacheTTL time.Duration) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{http: httpClient, cacheTTL: cacheTTL, providers: make(map[string]*cachedProvider)}
+}
+
+// Discover fetches <issuer>/.well-known/openid-configuration
+func (c *Client) Discover(ctx context.Context, issuer string) (*Provider, error) {
+	cached, err := c.cached(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return cached.provider, nil
+}
+
+// AuthCodeURL builds the authorization request URL, the code challenge is the S256 PKCE challenge
+func (p *Provider) AuthCodeURL(clientID, redirectURI, scope, state, nonce, codeChallenge string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {scope},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the raw ID token
+func (c *Client) Exchange(ctx context.Context, p *Provider, clientID, clientSecret, code, redirectURI, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	var res struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := c.do(req, &res); err != nil {
+		if res.Error != "" {
+			return "", fmt.Errorf("token exchange failed: %s %s", res.Error, res.ErrorDescription)
+		}
+		return "", err
+	}
+
+	if res.IDToken == "" {
+		return "", ErrNoIDToken
+	}
+	return res.IDToken, nil
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce of an ID token
+func (c *Client) VerifyIDToken(ctx context.Context, issuer, rawIDToken, clientID, nonce string) (*Claims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.key(ctx, issuer, kid)
+	}
+
+	token, err := jwt.Parse(rawIDToken, keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	result := &Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	// some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return result, nil
+}
+
+// key returns the verification key with the kid, the key set is fetched again once for unknown kids
+// so a key rotation at the provider does not need a restart
+func (c *Client) key(ctx context.Context, issuer, kid string) (interface{}, error) {
+	cached, err := c.cached(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := lookupKey(cached.keys, kid); ok {
+		return key, nil
+	}
+
+	c.mu.Lock()
+	delete(c.providers, issuer)
+	c.mu.Unlock()
+
+	if cached, err = c.cached(ctx, issuer); err != nil {
+		return nil, err
+	}
+	if key, ok := lookupKey(cached.keys, kid); ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// lookupKey finds the key by kid, tokens without kid are accepted when the set has a single key
+func lookupKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Client) cached(ctx context.Context, issuer string) (*cachedProvider, error) {
+	c.mu.Lock()
+	cached, ok := c.providers[issuer]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < c.cacheTTL {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var provider Provider
+	if err := c.do(req, &provider); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if provider.Issuer != issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, provider.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.do(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching jwks failed: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped, the provider may publish more than we can use
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	cached = &cachedProvider{provider: &provider, keys: keys, fetchedAt: time.Now()}
+	c.mu.Lock()
+	c.providers[issuer] = cached
+	c.mu.Unlock()
+
+	return cached, nil
+}
+
+// do sends the request and decodes the JSON body, out is also decoded for error responses
+func (c *Client) do(req *http.Request, out interface{}) error {
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	decodeErr := json.Unmarshal(body, out)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: status %d", req.Method, req.URL.Redacted(), res.StatusCode)
+	}
+	return decodeErr
+}
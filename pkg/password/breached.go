@@ -0,0 +1,116 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList looks up breached passwords with the k-anonymity model of the Pwned Passwords range API:
+// only the first 5 hex characters of the SHA-1 hash are used for the lookup, the caller compares the suffixes.
+type BreachedList interface {
+	// Range returns the hash suffixes starting with prefix and how often each was seen in breaches
+	Range(prefix string) (map[string]int, error)
+}
+
+// IsBreached reports whether the password is on the list
+func IsBreached(list BreachedList, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := list.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := suffixes[hash[5:]]
+	return ok, nil
+}
+
+// rangeDir reads the ranges from a directory holding one file per hash prefix, as written by the Pwned
+// Passwords downloader. Only the file of the prefix looked up is read, the full list is never loaded.
+type rangeDir struct {
+	dir string
+}
+
+// OpenBreachedRanges opens a directory of range files named after their prefix (ABCDE.txt), each listing
+// the remaining 35 hex characters of the hashes in the range as "SUFFIX:COUNT" lines, the format of the
+// range API responses. A missing file is an empty range.
+func OpenBreachedRanges(dir string) (BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+
+	return &rangeDir{dir: dir}, nil
+}
+
+func (d *rangeDir) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != 5 || !isHex(prefix) {
+		return nil, fmt.Errorf("invalid hash prefix %q", prefix)
+	}
+
+	name := filepath.Join(d.dir, prefix+".txt")
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseRange(f, name)
+}
+
+// parseRange reads "SUFFIX:COUNT" lines, the count is optional
+func parseRange(r io.Reader, name string) (map[string]int, error) {
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		suffix, countText, _ := strings.Cut(text, ":")
+		suffix = strings.ToUpper(suffix)
+		if len(suffix) != sha1.Size*2-5 || !isHex(suffix) {
+			return nil, fmt.Errorf("%s:%d: invalid hash suffix", name, line)
+		}
+
+		count := 1
+		if countText != "" {
+			var err error
+			if count, err = strconv.Atoi(strings.TrimSpace(countText)); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid count", name, line)
+			}
+		}
+		suffixes[suffix] = count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return suffixes, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBreachedRanges(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("password1"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	// suffixes are matched case-insensitively, the downloads are uppercase
+	content := strings.ToLower(hash[5:]) + ":2427\n0000000000000000000000000000000000A:3\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := OpenBreachedRanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password1", true},
+		{"Password1", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		got, err := IsBreached(list, tt.password)
+		if err != nil {
+			t.Fatalf("IsBreached(%q): %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	if _, err := list.Range("../.."); err == nil {
+		t.Error("Range accepted a prefix that is not a hash prefix")
+	}
+}
+
+func TestBreachedRangesRejectsMalformedFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ABCDE.txt"), []byte("not a hash:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := OpenBreachedRanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.Range("abcde"); err == nil {
+		t.Error("Range accepted a malformed range file")
+	}
+}
@@ -0,0 +1,143 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Hash algorithms supported by Hasher
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrUnknownAlgorithm  = errors.New("unknown password hash algorithm")
+)
+
+// Argon2Params are the argon2id cost parameters, Memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP recommendation (19 MiB, 2 iterations, 1 lane)
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// Hasher hashes new passwords with the configured algorithm and verifies hashes of every supported algorithm.
+// Hashes are self-describing: argon2id uses the PHC string format ($argon2id$v=19$m=...,t=...,p=...$salt$key),
+// bcrypt its usual $2a$cost$ format.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+func NewHasher(algorithm string, argon2Params Argon2Params, bcryptCost int) (*Hasher, error) {
+	if algorithm != AlgorithmArgon2id && algorithm != AlgorithmBcrypt {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if argon2Params.Memory == 0 || argon2Params.Iterations == 0 || argon2Params.Parallelism == 0 ||
+		argon2Params.SaltLength == 0 || argon2Params.KeyLength == 0 {
+		return nil, errors.New("argon2 parameters must not be zero")
+	}
+
+	return &Hasher{Algorithm: algorithm, Argon2: argon2Params, BcryptCost: bcryptCost}, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := h.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches the hash. needsRehash is set for a matching password whose hash
+// uses another algorithm or weaker parameters than the hasher, the caller should store a new Hash.
+func (h *Hasher) Verify(password, hash string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+
+		needsRehash = h.Algorithm != AlgorithmArgon2id ||
+			params.Memory < h.Argon2.Memory || params.Iterations < h.Argon2.Iterations || params.Parallelism < h.Argon2.Parallelism ||
+			uint32(len(salt)) < h.Argon2.SaltLength || uint32(len(key)) < h.Argon2.KeyLength
+		return true, needsRehash, nil
+	case strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, err
+		}
+		return true, h.Algorithm != AlgorithmBcrypt || cost < h.BcryptCost, nil
+	}
+
+	return false, false, ErrUnknownHashFormat
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,175 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// small parameters keep the tests fast, only their order matters
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestHasher(t *testing.T, algorithm string, params Argon2Params, bcryptCost int) *Hasher {
+	t.Helper()
+	h, err := NewHasher(algorithm, params, bcryptCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHasherRehash(t *testing.T) {
+	weaker := testArgon2
+	weaker.Iterations = 1
+	shortSalt := testArgon2
+	shortSalt.SaltLength = 8
+
+	tests := []struct {
+		name       string
+		stored     *Hasher
+		current    *Hasher
+		password   string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{
+			name:    "argon2id same parameters",
+			stored:  newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			current: newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			wantOK:  true,
+		},
+		{
+			name:       "argon2id fewer iterations",
+			stored:     newTestHasher(t, AlgorithmArgon2id, weaker, bcrypt.MinCost),
+			current:    newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			wantOK:     true,
+			wantRehash: true,
+		},
+		{
+			name:       "argon2id shorter salt",
+			stored:     newTestHasher(t, AlgorithmArgon2id, shortSalt, bcrypt.MinCost),
+			current:    newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			wantOK:     true,
+			wantRehash: true,
+		},
+		{
+			name:    "argon2id stronger than configured",
+			stored:  newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			current: newTestHasher(t, AlgorithmArgon2id, weaker, bcrypt.MinCost),
+			wantOK:  true,
+		},
+		{
+			name:       "bcrypt to argon2id",
+			stored:     newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			current:    newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			wantOK:     true,
+			wantRehash: true,
+		},
+		{
+			name:       "argon2id to bcrypt",
+			stored:     newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			current:    newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			wantOK:     true,
+			wantRehash: true,
+		},
+		{
+			name:       "bcrypt lower cost",
+			stored:     newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			current:    newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost+1),
+			wantOK:     true,
+			wantRehash: true,
+		},
+		{
+			name:    "bcrypt same cost",
+			stored:  newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			current: newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			wantOK:  true,
+		},
+		{
+			name:     "argon2id wrong password is never rehashed",
+			stored:   newTestHasher(t, AlgorithmArgon2id, weaker, bcrypt.MinCost),
+			current:  newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			password: "wrong password",
+		},
+		{
+			name:     "bcrypt wrong password is never rehashed",
+			stored:   newTestHasher(t, AlgorithmBcrypt, testArgon2, bcrypt.MinCost),
+			current:  newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost),
+			password: "wrong password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.stored.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			password := tt.password
+			if password == "" {
+				password = "correct horse battery staple"
+			}
+			ok, needsRehash, err := tt.current.Verify(password, hash)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if ok != tt.wantOK || needsRehash != tt.wantRehash {
+				t.Errorf("Verify = (%v, %v), want (%v, %v)", ok, needsRehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestHasherArgon2Format(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost)
+	first, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=64,t=2,p=1$") {
+		t.Errorf("hash %q is not in PHC format with the configured parameters", first)
+	}
+	if first == second {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestHasherUnknownFormat(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id, testArgon2, bcrypt.MinCost)
+
+	hashes := []string{
+		"",
+		"5f4dcc3b5aa765d61d8327deb882cf99",
+		"$argon2i$v=19$m=64,t=2,p=1$c2FsdA$a2V5",
+		"$argon2id$v=18$m=64,t=2,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=2,p=1$c2FsdA$",
+	}
+	for _, hash := range hashes {
+		ok, needsRehash, err := h.Verify("secret", hash)
+		if !errors.Is(err, ErrUnknownHashFormat) || ok || needsRehash {
+			t.Errorf("Verify(%q) = (%v, %v, %v), want ErrUnknownHashFormat", hash, ok, needsRehash, err)
+		}
+	}
+}
+
+func TestNewHasherRejectsBadConfig(t *testing.T) {
+	if _, err := NewHasher("scrypt", testArgon2, bcrypt.MinCost); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v, want ErrUnknownAlgorithm", err)
+	}
+	if _, err := NewHasher(AlgorithmBcrypt, testArgon2, bcrypt.MaxCost+1); err == nil {
+		t.Error("bcrypt cost above the maximum was accepted")
+	}
+	zero := testArgon2
+	zero.Parallelism = 0
+	if _, err := NewHasher(AlgorithmArgon2id, zero, bcrypt.MinCost); err == nil {
+		t.Error("zero argon2 parallelism was accepted")
+	}
+}
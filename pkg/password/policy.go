@@ -0,0 +1,138 @@
+// Package password checks new passwords against a configurable policy: length, character classes,
+// similarity to the user's own details and a list of breached passwords.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Rules reported in a Violation
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleUppercase     = "uppercase"
+	RuleLowercase     = "lowercase"
+	RuleDigit         = "digit"
+	RuleSymbol        = "symbol"
+	RulePersonalInfo  = "personal_info"
+	RuleBreached      = "breached"
+	RuleBreachedCheck = "breached_check"
+)
+
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// PolicyError lists every rule a password breaks so the client can show them all at once
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return "password violates policy: " + strings.Join(rules, ", ")
+}
+
+type Policy struct {
+	MinLength     int
+	MaxLength     int // in bytes, bcrypt ignores everything after 72
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached is optional, nil skips the check
+	Breached BreachedList
+}
+
+// minPersonalInfoLength keeps short name parts like "An" from rejecting half of all passwords
+const minPersonalInfoLength = 3
+
+// Check returns a *PolicyError when the password breaks a rule. personalInfo holds the email, name, ...
+// of the user, the password may not contain any of them or their parts.
+func (p *Policy) Check(password string, personalInfo ...string) error {
+	var violations []Violation
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, Violation{RuleMinLength, fmt.Sprintf("password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("password must be at most %d bytes long", p.MaxLength)})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, Violation{RuleUppercase, "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, Violation{RuleLowercase, "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, Violation{RuleDigit, "password must contain a digit"})
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, Violation{RuleSymbol, "password must contain a symbol"})
+	}
+
+	if containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, Violation{RulePersonalInfo, "password must not contain your email or name"})
+	}
+
+	if p.Breached != nil {
+		breached, err := IsBreached(p.Breached, password)
+		switch {
+		case err != nil:
+			violations = append(violations, Violation{RuleBreachedCheck, "password could not be checked, please try again"})
+		case breached:
+			violations = append(violations, Violation{RuleBreached, "password appears in a list of breached passwords"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	lowered := strings.ToLower(password)
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if info == "" {
+			continue
+		}
+
+		parts := []string{info}
+		if local, _, ok := strings.Cut(info, "@"); ok {
+			parts = append(parts, local)
+		}
+		parts = append(parts, strings.FieldsFunc(info, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+
+		for _, part := range parts {
+			if len([]rune(part)) >= minPersonalInfoLength && strings.Contains(lowered, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
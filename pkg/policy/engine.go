@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"go.uber.org/zap"
+)
+
+// Engine evaluates the rules of all its documents and writes every decision to the decision log
+type Engine struct {
+	rules []Rule
+	log   *zap.Logger
+}
+
+// NewEngine combines the documents, rule ids have to be unique across them
+func NewEngine(log *zap.Logger, docs ...*Document) (*Engine, error) {
+	combined := &Document{}
+	for _, doc := range docs {
+		combined.Rules = append(combined.Rules, doc.Rules...)
+	}
+	if err := combined.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Engine{rules: combined.Rules, log: log}, nil
+}
+
+// Decide evaluates the request and logs the decision with the attributes it was based on
+func (e *Engine) Decide(req Request) Decision {
+	decision := Evaluate(e.rules, req)
+
+	e.log.Info("[Policy] decision",
+		zap.String("effect", decision.Effect()),
+		zap.String("rule", decision.Rule),
+		zap.String("reason", decision.Reason),
+		zap.String("action", req.Action),
+		zap.Any("subject", req.Subject),
+		zap.Any("resource", req.Resource),
+	)
+	return decision
+}
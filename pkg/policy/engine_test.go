@@ -0,0 +1,184 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+	"user_service/pkg/policy"
+)
+
+// TestShippedPolicies runs the test cases of every policy document in policies/ against the rules of all of
+// them combined, as the service evaluates them
+func TestShippedPolicies(t *testing.T) {
+	names, err := filepath.Glob("../../policies/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no policy documents found")
+	}
+
+	var docs []*policy.Document
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc policy.Document
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := doc.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		docs = append(docs, &doc)
+	}
+
+	engine, err := policy.NewEngine(zap.NewNop(), docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, doc := range docs {
+		for _, test := range doc.Tests {
+			t.Run(filepath.Base(names[i])+"/"+test.Name, func(t *testing.T) {
+				decision := engine.Decide(test.Request)
+				if decision.Effect() != test.Expect {
+					t.Errorf("expected %s, got %s by %q", test.Expect, decision.Effect(), decision.Rule)
+				}
+				if test.Rule != "" && decision.Rule != test.Rule {
+					t.Errorf("expected rule %q, got %q", test.Rule, decision.Rule)
+				}
+			})
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	subject := policy.Attributes{"type": "user", "id": "u1", "roles": []any{"user", "support"}, "verified": true}
+	tests := []struct {
+		name      string
+		condition policy.Condition
+		want      bool
+	}{
+		{"eq", policy.Condition{Attribute: "subject.type", Operator: policy.OpEquals, Value: "user"}, true},
+		{"eq mismatch", policy.Condition{Attribute: "subject.type", Operator: policy.OpEquals, Value: "service"}, false},
+		{"eq boolean", policy.Condition{Attribute: "subject.verified", Operator: policy.OpEquals, Value: true}, true},
+		{"ne", policy.Condition{Attribute: "subject.type", Operator: policy.OpNotEquals, Value: "service"}, true},
+		{"in", policy.Condition{Attribute: "subject.type", Operator: policy.OpIn, Value: []any{"user", "service"}}, true},
+		{"in mismatch", policy.Condition{Attribute: "subject.type", Operator: policy.OpIn, Value: []any{"service"}}, false},
+		{"not_in", policy.Condition{Attribute: "subject.type", Operator: policy.OpNotIn, Value: []any{"service"}}, true},
+		{"contains", policy.Condition{Attribute: "subject.roles", Operator: policy.OpContains, Value: "support"}, true},
+		{"contains mismatch", policy.Condition{Attribute: "subject.roles", Operator: policy.OpContains, Value: "admin"}, false},
+		{"not_contains", policy.Condition{Attribute: "subject.roles", Operator: policy.OpNotContains, Value: "admin"}, true},
+		{"exists", policy.Condition{Attribute: "subject.id", Operator: policy.OpExists}, true},
+		{"exists missing", policy.Condition{Attribute: "subject.actor", Operator: policy.OpExists}, false},
+		{"not_exists", policy.Condition{Attribute: "subject.actor", Operator: policy.OpNotExists}, true},
+		{"missing attribute never holds", policy.Condition{Attribute: "subject.actor", Operator: policy.OpNotEquals, Value: "a1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluate(t, tt.condition, subject, policy.Attributes{"type": "user", "id": "u2"})
+			if got != tt.want {
+				t.Errorf("condition holds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefResolution(t *testing.T) {
+	sameID := policy.Condition{Attribute: "subject.id", Operator: policy.OpEquals, Ref: "resource.id"}
+	notOwner := policy.Condition{Attribute: "resource.owner", Operator: policy.OpNotEquals, Ref: "subject.id"}
+	tests := []struct {
+		name      string
+		condition policy.Condition
+		resource  policy.Attributes
+		want      bool
+	}{
+		{"ref equal", sameID, policy.Attributes{"type": "user", "id": "u1"}, true},
+		{"ref different", sameID, policy.Attributes{"type": "user", "id": "u2"}, false},
+		{"ref missing", sameID, policy.Attributes{"type": "user"}, false},
+		{"ref empty", sameID, policy.Attributes{"type": "user", "id": ""}, false},
+		{"ref from resource to subject", notOwner, policy.Attributes{"type": "user", "owner": "u2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluate(t, tt.condition, policy.Attributes{"type": "user", "id": "u1", "roles": []any{"user"}}, tt.resource)
+			if got != tt.want {
+				t.Errorf("condition holds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenyOverridesAllow(t *testing.T) {
+	rules := []policy.Rule{
+		{ID: "allow-users", Effect: policy.EffectAllow, Subjects: []string{"*"}, Actions: []string{"users:*"}, Resources: []string{"user"}},
+		{ID: "deny-delete", Effect: policy.EffectDeny, Subjects: []string{"*"}, Actions: []string{"users:delete"}, Resources: []string{"user"}},
+	}
+	req := policy.Request{Subject: policy.Attributes{"roles": []any{"user"}}, Resource: policy.Attributes{"type": "user"}}
+
+	req.Action = "users:read"
+	if decision := policy.Evaluate(rules, req); !decision.Allowed || decision.Rule != "allow-users" {
+		t.Errorf("users:read: got %+v", decision)
+	}
+	req.Action = "users:delete"
+	if decision := policy.Evaluate(rules, req); decision.Allowed || decision.Rule != "deny-delete" {
+		t.Errorf("users:delete: got %+v", decision)
+	}
+	req.Action = "roles:manage"
+	if decision := policy.Evaluate(rules, req); decision.Allowed || decision.Rule != "" {
+		t.Errorf("roles:manage: got %+v", decision)
+	}
+}
+
+func TestValidateRejectsBrokenRules(t *testing.T) {
+	valid := func() policy.Rule {
+		return policy.Rule{ID: "r", Effect: policy.EffectAllow, Subjects: []string{"*"}, Actions: []string{"*"}, Resources: []string{"*"}}
+	}
+	tests := []struct {
+		name   string
+		modify func(r *policy.Rule)
+	}{
+		{"unknown effect", func(r *policy.Rule) { r.Effect = "maybe" }},
+		{"no subjects", func(r *policy.Rule) { r.Subjects = nil }},
+		{"bad pattern", func(r *policy.Rule) { r.Actions = []string{"["} }},
+		{"unknown operator", func(r *policy.Rule) {
+			r.Conditions = []policy.Condition{{Attribute: "subject.id", Operator: "like", Value: "u"}}
+		}},
+		{"bad attribute root", func(r *policy.Rule) {
+			r.Conditions = []policy.Condition{{Attribute: "request.ip", Operator: policy.OpExists}}
+		}},
+		{"value and ref", func(r *policy.Rule) {
+			r.Conditions = []policy.Condition{{Attribute: "subject.id", Operator: policy.OpEquals, Value: "u", Ref: "resource.id"}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid()
+			tt.modify(&rule)
+			doc := policy.Document{Rules: []policy.Rule{rule}}
+			if err := doc.Validate(); !errors.Is(err, policy.ErrInvalidPolicy) {
+				t.Errorf("Validate() = %v, want %v", err, policy.ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+// evaluate reports whether a single allow rule with the condition applies
+func evaluate(t *testing.T, condition policy.Condition, subject, resource policy.Attributes) bool {
+	t.Helper()
+	rule := policy.Rule{ID: "r", Effect: policy.EffectAllow, Subjects: []string{"*"}, Actions: []string{"*"}, Resources: []string{"*"},
+		Conditions: []policy.Condition{condition}}
+	doc := policy.Document{Rules: []policy.Rule{rule}}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return policy.Evaluate(doc.Rules, policy.Request{Subject: subject, Action: "users:read", Resource: resource}).Allowed
+}
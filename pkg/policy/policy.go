@@ -0,0 +1,312 @@
+// Package policy decides whether a subject may perform an action on a resource by evaluating declarative
+// rules against their attributes. Policy documents are JSON files holding the rules and test cases which
+// are checked when the document is loaded, so a broken policy fails at startup instead of in production.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Condition operators. The attribute is compared with Value or, when Ref is set, with another attribute.
+// A condition on a missing attribute does not hold, except for OpNotExists.
+const (
+	OpEquals      = "eq"
+	OpNotEquals   = "ne"
+	OpIn          = "in"           // the attribute is one of the values of a list
+	OpNotIn       = "not_in"       // the attribute is none of the values of a list
+	OpContains    = "contains"     // the list attribute contains the value
+	OpNotContains = "not_contains" // the list attribute does not contain the value
+	OpExists      = "exists"
+	OpNotExists   = "not_exists"
+)
+
+var operators = []string{OpEquals, OpNotEquals, OpIn, OpNotIn, OpContains, OpNotContains, OpExists, OpNotExists}
+
+// Attribute roots conditions refer to, e.g. subject.id or resource.role
+const (
+	RootSubject  = "subject"
+	RootResource = "resource"
+)
+
+// Attributes of a subject or resource, values are strings, booleans, numbers or lists of strings
+type Attributes map[string]any
+
+// Request is what a decision is made about. Subject["roles"] is matched against the subjects of a rule
+// and Resource["type"] against its resources.
+type Request struct {
+	Subject  Attributes `json:"subject"`
+	Action   string     `json:"action"`
+	Resource Attributes `json:"resource"`
+}
+
+type Condition struct {
+	Attribute string `json:"attribute"`
+	Operator  string `json:"operator"`
+	Value     any    `json:"value,omitempty"`
+	Ref       string `json:"ref,omitempty"`
+}
+
+// Rule applies to a request when its subjects, actions and resources match and all its conditions hold.
+// Subjects, actions and resources are patterns as in path.Match, "*" matches everything and "users:*"
+// every action on users.
+type Rule struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description,omitempty"`
+	Effect      string      `json:"effect"`
+	Subjects    []string    `json:"subjects"`
+	Actions     []string    `json:"actions"`
+	Resources   []string    `json:"resources"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+}
+
+// TestCase expects a decision, and optionally the rule making it, for a request
+type TestCase struct {
+	Name    string  `json:"name"`
+	Request Request `json:"request"`
+	Expect  string  `json:"expect"`
+	Rule    string  `json:"rule,omitempty"`
+}
+
+type Document struct {
+	Rules []Rule     `json:"rules"`
+	Tests []TestCase `json:"tests,omitempty"`
+}
+
+// Decision names the rule it is based on, requests no rule applies to are denied without one
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (d Decision) Effect() string {
+	if d.Allowed {
+		return EffectAllow
+	}
+	return EffectDeny
+}
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// LoadFile parses a policy document and runs its tests
+func LoadFile(name string) (*Document, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Load(name, data)
+}
+
+// Load parses a policy document and runs its tests, name is only used in errors
+func Load(name string, data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, name, err)
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := doc.Test(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &doc, nil
+}
+
+// Validate checks the rules are well-formed
+func (d *Document) Validate() error {
+	seen := make(map[string]bool)
+	for i, rule := range d.Rules {
+		if rule.ID == "" {
+			return fmt.Errorf("%w: rule %d has no id", ErrInvalidPolicy, i)
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("%w: duplicate rule %s", ErrInvalidPolicy, rule.ID)
+		}
+		seen[rule.ID] = true
+
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return fmt.Errorf("%w: rule %s: effect must be allow or deny", ErrInvalidPolicy, rule.ID)
+		}
+		for field, patterns := range map[string][]string{"subjects": rule.Subjects, "actions": rule.Actions, "resources": rule.Resources} {
+			if len(patterns) == 0 {
+				return fmt.Errorf("%w: rule %s: %s are required, use \"*\" for any", ErrInvalidPolicy, rule.ID, field)
+			}
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("%w: rule %s: invalid pattern %q", ErrInvalidPolicy, rule.ID, pattern)
+				}
+			}
+		}
+		for _, condition := range rule.Conditions {
+			if err := condition.validate(); err != nil {
+				return fmt.Errorf("%w: rule %s: %v", ErrInvalidPolicy, rule.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if !validAttribute(c.Attribute) {
+		return fmt.Errorf("attribute %q must start with subject. or resource.", c.Attribute)
+	}
+	if !slices.Contains(operators, c.Operator) {
+		return fmt.Errorf("unknown operator %q, allowed operators are %s", c.Operator, strings.Join(operators, ", "))
+	}
+	if c.Ref != "" && !validAttribute(c.Ref) {
+		return fmt.Errorf("ref %q must start with subject. or resource.", c.Ref)
+	}
+	if c.Ref != "" && c.Value != nil {
+		return fmt.Errorf("condition on %s has both a value and a ref", c.Attribute)
+	}
+	return nil
+}
+
+func validAttribute(attribute string) bool {
+	root, name, ok := strings.Cut(attribute, ".")
+	return ok && name != "" && (root == RootSubject || root == RootResource)
+}
+
+// Test evaluates the test cases of the document against its rules
+func (d *Document) Test() error {
+	var failures []string
+	for _, test := range d.Tests {
+		decision := Evaluate(d.Rules, test.Request)
+		if decision.Effect() != test.Expect || (test.Rule != "" && decision.Rule != test.Rule) {
+			failures = append(failures, fmt.Sprintf("%s: expected %s by %q, got %s by %q",
+				test.Name, test.Expect, test.Rule, decision.Effect(), decision.Rule))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: failing tests:\n%s", ErrInvalidPolicy, strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// Evaluate denies when a deny rule applies, allows when an allow rule applies and denies otherwise
+func Evaluate(rules []Rule, req Request) Decision {
+	var allow *Rule
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.applies(req) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			return Decision{Allowed: false, Rule: rule.ID, Reason: "denied by rule"}
+		}
+		if allow == nil {
+			allow = rule
+		}
+	}
+
+	if allow != nil {
+		return Decision{Allowed: true, Rule: allow.ID, Reason: "allowed by rule"}
+	}
+	return Decision{Allowed: false, Reason: "no rule allows the request"}
+}
+
+func (r *Rule) applies(req Request) bool {
+	if !matchAny(r.Actions, []string{req.Action}) ||
+		!matchAny(r.Resources, list(req.Resource["type"])) ||
+		!matchAny(r.Subjects, list(req.Subject["roles"])) {
+		return false
+	}
+
+	for _, condition := range r.Conditions {
+		if !condition.holds(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether any value matches any pattern
+func matchAny(patterns []string, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Condition) holds(req Request) bool {
+	value, ok := lookup(req, c.Attribute)
+	switch c.Operator {
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+
+	other := c.Value
+	if c.Ref != "" {
+		if other, ok = lookup(req, c.Ref); !ok {
+			return false
+		}
+	}
+
+	switch c.Operator {
+	case OpEquals:
+		return fmt.Sprint(value) == fmt.Sprint(other)
+	case OpNotEquals:
+		return fmt.Sprint(value) != fmt.Sprint(other)
+	case OpIn:
+		return slices.Contains(list(other), fmt.Sprint(value))
+	case OpNotIn:
+		return !slices.Contains(list(other), fmt.Sprint(value))
+	case OpContains:
+		return slices.Contains(list(value), fmt.Sprint(other))
+	case OpNotContains:
+		return !slices.Contains(list(value), fmt.Sprint(other))
+	}
+	return false
+}
+
+func lookup(req Request, attribute string) (any, bool) {
+	root, name, _ := strings.Cut(attribute, ".")
+	attributes := req.Subject
+	if root == RootResource {
+		attributes = req.Resource
+	}
+
+	value, ok := attributes[name]
+	if !ok || value == nil || value == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+// list turns an attribute into a list, lists decoded from JSON are []any
+func list(value any) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []string:
+		return v
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
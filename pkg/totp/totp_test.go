@@ -0,0 +1,111 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// the RFC lists 8 digit codes, 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		offset   int64
+		wantOK   bool
+		wantStep int64
+	}{
+		{"current step", 0, true, current},
+		{"previous step", -1, true, current - 1},
+		{"next step", 1, true, current + 1},
+		{"two steps behind", -2, false, 0},
+		{"two steps ahead", 2, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		wantOK bool
+	}{
+		{"surrounding space", rfcSecret, " 005924 ", true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "005924", true},
+		{"wrong code", rfcSecret, "005925", false},
+		{"8 digit code", rfcSecret, "89005924", false},
+		{"short code", rfcSecret, "05924", false},
+		{"empty code", rfcSecret, "", false},
+		{"invalid secret", "not base32!", "005924", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, now); ok != tt.wantOK {
+				t.Errorf("Validate(%q, %q) = %v, want %v", tt.secret, tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 160 bits are 32 base32 characters without padding
+	if len(first) != 32 || first == second {
+		t.Errorf("secrets %q and %q", first, second)
+	}
+	if _, err := Code(first, 1); err != nil {
+		t.Errorf("generated secret cannot be used: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+// Package policies holds the access policies of the service, see pkg/policy for the format. They are
+// embedded in the binary and used unless POLICY_FILES names other files.
+package policies
+
+import (
+	"embed"
+	"io/fs"
+	"user_service/pkg/policy"
+)
+
+//go:embed *.json
+var files embed.FS
+
+// Default loads the embedded policy documents and runs their tests
+func Default() ([]*policy.Document, error) {
+	names, err := fs.Glob(files, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*policy.Document, 0, len(names))
+	for _, name := range names {
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := policy.Load(name, data)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}